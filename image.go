@@ -0,0 +1,291 @@
+package Netpbm
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PPMImage adapts a PPM to the image.Image interface of the standard library,
+// so that it can be passed to image/draw, image/png or image/jpeg. It is a
+// separate type because PPM.At already returns a Pixel. The adapter shares the
+// pixels of the PPM, so later changes to the PPM are visible through it.
+type PPMImage struct {
+	PPM *PPM
+}
+
+// ColorModel returns color.RGBAModel.
+func (img PPMImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the rectangle from (0, 0) to the size of the PPM.
+func (img PPMImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.PPM.width, img.PPM.height)
+}
+
+// At returns the pixel at (x, y) as an opaque color.RGBA, scaled from the max
+// value of the PPM to 255. Pixels outside the image are transparent black.
+func (img PPMImage) At(x, y int) color.Color {
+	ppm := img.PPM
+	if x < 0 || x >= ppm.width || y < 0 || y >= ppm.height {
+		return color.RGBA{}
+	}
+	pixel := ppm.data[y][x]
+	return color.RGBA{
+		R: scaleTo255(pixel.R, ppm.max),
+		G: scaleTo255(pixel.G, ppm.max),
+		B: scaleTo255(pixel.B, ppm.max),
+		A: 255,
+	}
+}
+
+// PGMImage adapts a PGM to the image.Image interface of the standard library,
+// with color.Gray pixels. Like PPMImage, it shares the pixels of the PGM.
+type PGMImage struct {
+	PGM *PGM
+}
+
+// ColorModel returns color.GrayModel.
+func (img PGMImage) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+// Bounds returns the rectangle from (0, 0) to the size of the PGM.
+func (img PGMImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.PGM.width, img.PGM.height)
+}
+
+// At returns the pixel at (x, y) as a color.Gray, scaled from the max value of
+// the PGM to 255. Pixels outside the image are black.
+func (img PGMImage) At(x, y int) color.Color {
+	pgm := img.PGM
+	if x < 0 || x >= pgm.width || y < 0 || y >= pgm.height {
+		return color.Gray{}
+	}
+	return color.Gray{Y: scaleTo255(pgm.data[y][x], pgm.max)}
+}
+
+// scaleTo255 maps a sample from [0, max] to [0, 255], rounding to the nearest
+// value. Samples above max are treated as max, and a max of 0 gives black.
+func scaleTo255(v, max uint8) uint8 {
+	if max == 0 {
+		return 0
+	}
+	if max == 255 {
+		return v
+	}
+	return uint8((uint32(min(v, max))*255 + uint32(max)/2) / uint32(max))
+}
+
+// PBMImage adapts a PBM to the image.Image interface of the standard library,
+// with true pixels black and false pixels white. Like PPMImage, it shares the
+// pixels of the PBM.
+type PBMImage struct {
+	PBM *PBM
+}
+
+// pbmPalette is the color model of PBMImage: white for false, black for true.
+var pbmPalette = color.Palette{color.White, color.Black}
+
+// ColorModel returns a two-color palette of white and black.
+func (img PBMImage) ColorModel() color.Model {
+	return pbmPalette
+}
+
+// Bounds returns the rectangle from (0, 0) to the size of the PBM.
+func (img PBMImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.PBM.width, img.PBM.height)
+}
+
+// At returns color.Black for true pixels and color.White for false ones.
+// Pixels outside the image are white.
+func (img PBMImage) At(x, y int) color.Color {
+	pbm := img.PBM
+	if x >= 0 && x < pbm.width && y >= 0 && y < pbm.height && pbm.data[y][x] {
+		return color.Black
+	}
+	return color.White
+}
+
+// ToImage returns the PPM as an image.Image, e.g. for png.Encode(w, ppm.ToImage()).
+// No pixels are copied: the adapter reads them from the PPM.
+func (ppm *PPM) ToImage() image.Image {
+	return PPMImage{ppm}
+}
+
+// ToImage returns the PGM as an image.Image with color.Gray pixels.
+// No pixels are copied: the adapter reads them from the PGM.
+func (pgm *PGM) ToImage() image.Image {
+	return PGMImage{pgm}
+}
+
+// ToImage returns the PBM as a black and white image.Image.
+// No pixels are copied: the adapter reads them from the PBM.
+func (pbm *PBM) ToImage() image.Image {
+	return PBMImage{pbm}
+}
+
+// PPMFromImage converts any image.Image, such as one decoded from a PNG, to a
+// P6 PPM with a max value of 255. Colors are quantized to 8 bits per channel,
+// ignoring transparency, and the bounds are moved so that they start at (0, 0).
+func PPMFromImage(img image.Image) *PPM {
+	bounds := img.Bounds()
+	ppm := &PPM{
+		data:        make([][]Pixel, bounds.Dy()),
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+		magicNumber: "P6",
+		max:         255,
+	}
+	for y := range ppm.data {
+		ppm.data[y] = make([]Pixel, ppm.width)
+		for x := range ppm.data[y] {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			ppm.data[y][x] = Pixel{R: c.R, G: c.G, B: c.B}
+		}
+	}
+	return ppm
+}
+
+// PGMFromImage converts any image.Image to a P5 PGM with a max value of 255,
+// using color.GrayModel. The bounds are moved so that they start at (0, 0).
+func PGMFromImage(img image.Image) *PGM {
+	bounds := img.Bounds()
+	pgm := &PGM{
+		data:        make([][]uint8, bounds.Dy()),
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+		magicNumber: "P5",
+		max:         255,
+	}
+	for y := range pgm.data {
+		pgm.data[y] = make([]uint8, pgm.width)
+		for x := range pgm.data[y] {
+			pgm.data[y][x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return pgm
+}
+
+// PBMFromImage converts any image.Image to a P4 PBM in which pixels whose
+// luminance is below half intensity are black (true). The bounds are moved so
+// that they start at (0, 0).
+func PBMFromImage(img image.Image) *PBM {
+	bounds := img.Bounds()
+	pbm := &PBM{
+		data:        make([][]bool, bounds.Dy()),
+		width:       bounds.Dx(),
+		height:      bounds.Dy(),
+		magicNumber: "P4",
+	}
+	for y := range pbm.data {
+		pbm.data[y] = make([]bool, pbm.width)
+		for x := range pbm.data[y] {
+			pbm.data[y][x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y < 128
+		}
+	}
+	return pbm
+}
+
+// SaveAuto saves the image in the format given by the extension of filename:
+// ".ppm" for the ASCII form of PPM, or ".png" to encode it with image/png.
+// Use SaveAutoBinary for the binary form. Other extensions, including those of
+// the other netpbm types, are rejected with an error.
+func (ppm *PPM) SaveAuto(filename string) error {
+	return ppm.saveAuto(filename, false)
+}
+
+// SaveAutoBinary is like SaveAuto, but writes ".ppm" files in binary form.
+func (ppm *PPM) SaveAutoBinary(filename string) error {
+	return ppm.saveAuto(filename, true)
+}
+
+// saveAuto implements SaveAuto and SaveAutoBinary.
+func (ppm *PPM) saveAuto(filename string, binary bool) error {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".ppm":
+		if binary {
+			return ppm.SaveBinary(filename)
+		}
+		return ppm.SaveASCII(filename)
+	case ".png":
+		return savePNG(filename, ppm.ToImage())
+	default:
+		return fmt.Errorf("unsupported extension for a PPM image: %q", ext)
+	}
+}
+
+// SaveAuto saves the image in the format given by the extension of filename:
+// ".pgm" for the ASCII form of PGM, or ".png" to encode it with image/png.
+// Use SaveAutoBinary for the binary form. Other extensions, including those of
+// the other netpbm types, are rejected with an error.
+func (pgm *PGM) SaveAuto(filename string) error {
+	return pgm.saveAuto(filename, false)
+}
+
+// SaveAutoBinary is like SaveAuto, but writes ".pgm" files in binary form.
+func (pgm *PGM) SaveAutoBinary(filename string) error {
+	return pgm.saveAuto(filename, true)
+}
+
+// saveAuto implements SaveAuto and SaveAutoBinary.
+func (pgm *PGM) saveAuto(filename string, binary bool) error {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".pgm":
+		if binary {
+			return pgm.SaveBinary(filename)
+		}
+		return pgm.SaveASCII(filename)
+	case ".png":
+		return savePNG(filename, pgm.ToImage())
+	default:
+		return fmt.Errorf("unsupported extension for a PGM image: %q", ext)
+	}
+}
+
+// SaveAuto saves the image in the format given by the extension of filename:
+// ".pbm" for the ASCII form of PBM, or ".png" to encode it with image/png.
+// Use SaveAutoBinary for the binary form. Other extensions, including those of
+// the other netpbm types, are rejected with an error.
+func (pbm *PBM) SaveAuto(filename string) error {
+	return pbm.saveAuto(filename, false)
+}
+
+// SaveAutoBinary is like SaveAuto, but writes ".pbm" files in binary form.
+func (pbm *PBM) SaveAutoBinary(filename string) error {
+	return pbm.saveAuto(filename, true)
+}
+
+// saveAuto implements SaveAuto and SaveAutoBinary.
+func (pbm *PBM) saveAuto(filename string, binary bool) error {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".pbm":
+		if binary {
+			return pbm.SaveBinary(filename)
+		}
+		return pbm.SaveASCII(filename)
+	case ".png":
+		return savePNG(filename, pbm.ToImage())
+	default:
+		return fmt.Errorf("unsupported extension for a PBM image: %q", ext)
+	}
+}
+
+// savePNG encodes img to a PNG file.
+func savePNG(filename string, img image.Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("error encoding PNG: %v", err)
+	}
+	return file.Close()
+}
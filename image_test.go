@@ -0,0 +1,190 @@
+package Netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPNGEncode(t *testing.T) {
+	ppm := testPPM(t, 7, 5)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, PPMImage{ppm}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.Bounds(), image.Rect(0, 0, 7, 5); got != want {
+		t.Fatalf("PPM: got bounds %v, want %v", got, want)
+	}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			p := ppm.At(x, y)
+			if got, want := color.NRGBAModel.Convert(decoded.At(x, y)), (color.NRGBA{p.R, p.G, p.B, 255}); got != want {
+				t.Errorf("PPM (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	pgm := testPGM(t, 6, 4)
+	buf.Reset()
+	if err := png.Encode(&buf, PGMImage{pgm}); err != nil {
+		t.Fatal(err)
+	}
+	gray, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gray.Bounds(), image.Rect(0, 0, 6, 4); got != want {
+		t.Fatalf("PGM: got bounds %v, want %v", got, want)
+	}
+	if got, want := gray.At(5, 3), (color.Gray{pgm.At(5, 3)}); got != want {
+		t.Errorf("PGM (5, 3): got %v, want %v", got, want)
+	}
+}
+
+func TestToImage(t *testing.T) {
+	ppm := testPPM(t, 5, 4)
+	img := ppm.ToImage()
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			p := ppm.At(x, y)
+			if got, want := img.At(x, y), (color.RGBA{p.R, p.G, p.B, 255}); got != want {
+				t.Errorf("PPM (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	// The adapter shares the pixels.
+	ppm.Set(2, 1, Pixel{9, 8, 7})
+	if got, want := img.At(2, 1), (color.RGBA{9, 8, 7, 255}); got != want {
+		t.Errorf("PPM after Set: got %v, want %v", got, want)
+	}
+	if got := img.At(5, 0); got != (color.RGBA{}) {
+		t.Errorf("PPM outside: got %v, want transparent black", got)
+	}
+
+	// Samples are scaled from the max value to 255.
+	pgm, err := NewPGM(3, 1, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgm.Set(1, 0, 5)
+	pgm.Set(2, 0, 15)
+	gray := pgm.ToImage()
+	for x, want := range []uint8{0, 85, 255} {
+		if got := gray.At(x, 0); got != (color.Gray{want}) {
+			t.Errorf("PGM (%d, 0): got %v, want gray %d", x, got, want)
+		}
+	}
+
+	pbm := pbmFromRows("#.", ".#")
+	bw := pbm.ToImage()
+	for _, tt := range []struct {
+		x, y int
+		want color.Color
+	}{{0, 0, color.Black}, {1, 0, color.White}, {1, 1, color.Black}, {2, 0, color.White}} {
+		if got := bw.At(tt.x, tt.y); got != tt.want {
+			t.Errorf("PBM (%d, %d): got %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+	if got, want := bw.Bounds(), image.Rect(0, 0, 2, 2); got != want {
+		t.Errorf("PBM: got bounds %v, want %v", got, want)
+	}
+}
+
+func TestFromImage(t *testing.T) {
+	// A small RGBA image whose bounds do not start at the origin.
+	src := image.NewRGBA(image.Rect(10, 20, 13, 22))
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 128, 0, 255}, {10, 20, 30, 255}, {0, 0, 0, 255}, {255, 255, 255, 255}, {200, 200, 200, 255}}
+	for i, c := range colors {
+		src.SetRGBA(10+i%3, 20+i/3, c)
+	}
+
+	ppm := PPMFromImage(src)
+	if w, h := ppm.Size(); w != 3 || h != 2 || ppm.max != 255 {
+		t.Fatalf("got %dx%d max %d, want 3x2 max 255", w, h, ppm.max)
+	}
+	back := ppm.ToImage()
+	if got, want := back.Bounds(), image.Rect(0, 0, 3, 2); got != want {
+		t.Errorf("got bounds %v, want %v", got, want)
+	}
+	for i, c := range colors {
+		if got := back.At(i%3, i/3); got != c {
+			t.Errorf("round trip (%d, %d): got %v, want %v", i%3, i/3, got, c)
+		}
+	}
+
+	pgm := PGMFromImage(src)
+	if got, want := pgm.At(2, 1), color.GrayModel.Convert(colors[5]).(color.Gray).Y; got != want {
+		t.Errorf("PGM (2, 1): got %d, want %d", got, want)
+	}
+	pbm := PBMFromImage(src)
+	if !slices.EqualFunc(pbm.data, pbmFromRows("###", "#..").data, slices.Equal) {
+		t.Errorf("PBM: got %v", pbm.data)
+	}
+}
+
+func TestSaveAuto(t *testing.T) {
+	type saver interface {
+		SaveAuto(filename string) error
+		SaveAutoBinary(filename string) error
+	}
+	for _, tt := range []struct {
+		img                saver
+		ext, ascii, binary string
+		wrongExt           string
+	}{
+		{testPPM(t, 3, 2), ".ppm", "P3", "P6", ".pgm"},
+		{testPGM(t, 3, 2), ".pgm", "P2", "P5", ".pbm"},
+		{pbmFromRows("#.#", ".#."), ".pbm", "P1", "P4", ".ppm"},
+	} {
+		dir := t.TempDir()
+		for _, save := range []struct {
+			name  string
+			fn    func(string) error
+			magic string
+		}{{"SaveAuto", tt.img.SaveAuto, tt.ascii}, {"SaveAutoBinary", tt.img.SaveAutoBinary, tt.binary}} {
+			filename := filepath.Join(dir, "image"+tt.ext)
+			if err := save.fn(filename); err != nil {
+				t.Fatalf("%s %s: %v", save.name, tt.ext, err)
+			}
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(save.magic+"\n")) {
+				t.Errorf("%s %s: got a file starting with %q, want %s", save.name, tt.ext, data[:min(len(data), 2)], save.magic)
+			}
+
+			// PNG, whatever the case of the extension.
+			filename = filepath.Join(dir, "image.PNG")
+			if err := save.fn(filename); err != nil {
+				t.Fatalf("%s .PNG for %s: %v", save.name, tt.ext, err)
+			}
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(file)
+			file.Close()
+			if err != nil {
+				t.Errorf("%s .PNG for %s: %v", save.name, tt.ext, err)
+			} else if got := img.Bounds().Size(); got != image.Pt(3, 2) {
+				t.Errorf("%s .PNG for %s: got size %v, want (3,2)", save.name, tt.ext, got)
+			}
+
+			for _, ext := range []string{tt.wrongExt, ".jpg", ""} {
+				if err := save.fn(filepath.Join(dir, "image"+ext)); err == nil {
+					t.Errorf("%s: extension %q accepted for a %s image", save.name, ext, tt.ext)
+				}
+			}
+		}
+	}
+}
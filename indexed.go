@@ -0,0 +1,235 @@
+package Netpbm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Indexed represents an image whose pixels are indexes into a palette of at
+// most 256 colors, as used for palette effects such as color cycling.
+type Indexed struct {
+	data          [][]uint8 // Palette index of each pixel.
+	width, height int       // Width and height of the image.
+	palette       []Pixel   // Colors referenced by the pixel indexes.
+	magicNumber   string    // Magic number of the PPM the image was built from.
+	max           uint8     // Max value of the palette colors.
+}
+
+// ToIndexed converts the PPM image to an indexed image. An image of at most
+// 256 colors is converted exactly, with a palette listing its distinct colors
+// in order of first appearance, row by row. A photo with more colors is
+// reduced to 256 of them with Quantize.
+func (ppm *PPM) ToIndexed() (*Indexed, error) {
+	colors := ppm.colorCounts()
+	if len(colors) > 256 {
+		return ppm.Quantize(256)
+	}
+
+	palette := make([]Pixel, len(colors))
+	for i, c := range colors {
+		palette[i] = c.color
+	}
+	return ppm.indexWith(palette), nil
+}
+
+// Quantize converts the PPM image to an indexed image of at most the given
+// number of colors, between 1 and 256, chosen by median cut: the colors of the
+// image are split in two at the median of their widest channel until there
+// are enough groups, and each group is replaced by its average color. An
+// image with few enough colors keeps them exactly.
+func (ppm *PPM) Quantize(colors int) (*Indexed, error) {
+	if colors < 1 || colors > 256 {
+		return nil, fmt.Errorf("invalid number of colors: %d, must be between 1 and 256", colors)
+	}
+
+	counts := ppm.colorCounts()
+	if len(counts) <= colors {
+		palette := make([]Pixel, len(counts))
+		for i, c := range counts {
+			palette[i] = c.color
+		}
+		return ppm.indexWith(palette), nil
+	}
+
+	boxes := [][]colorCount{counts}
+	for len(boxes) < colors {
+		// Split the box with the widest channel range.
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if channel, r := widestChannel(box); r > bestRange {
+				best, bestChannel, bestRange = i, channel, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return channelOf(box[i].color, bestChannel) < channelOf(box[j].color, bestChannel)
+		})
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		// Cut at the weighted median, keeping both halves non-empty.
+		cut, sum := 1, box[0].count
+		for cut < len(box)-1 && 2*sum < total {
+			sum += box[cut].count
+			cut++
+		}
+		boxes[best] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	palette := make([]Pixel, len(boxes))
+	for i, box := range boxes {
+		var r, g, b, n int
+		for _, c := range box {
+			r += int(c.color.R) * c.count
+			g += int(c.color.G) * c.count
+			b += int(c.color.B) * c.count
+			n += c.count
+		}
+		palette[i] = Pixel{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n)}
+	}
+	return ppm.indexWith(palette), nil
+}
+
+// colorCount is a color of an image with its number of pixels.
+type colorCount struct {
+	color Pixel
+	count int
+}
+
+// colorCounts returns the distinct colors of the image in order of first
+// appearance, row by row, with their number of pixels.
+func (ppm *PPM) colorCounts() []colorCount {
+	var counts []colorCount
+	indexes := make(map[Pixel]int)
+	for y := 0; y < ppm.height; y++ {
+		for _, pixel := range ppm.data[y] {
+			i, ok := indexes[pixel]
+			if !ok {
+				i = len(counts)
+				indexes[pixel] = i
+				counts = append(counts, colorCount{pixel, 0})
+			}
+			counts[i].count++
+		}
+	}
+	return counts
+}
+
+// widestChannel returns the channel (0 for R, 1 for G, 2 for B) whose values
+// spread the most among the colors, and that spread.
+func widestChannel(colors []colorCount) (channel, spread int) {
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range colors {
+			v := int(channelOf(c.color, ch))
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		if hi-lo > spread {
+			channel, spread = ch, hi-lo
+		}
+	}
+	return channel, spread
+}
+
+// channelOf returns the given channel of a pixel, 0 for R, 1 for G, 2 for B.
+func channelOf(p Pixel, channel int) uint8 {
+	switch channel {
+	case 0:
+		return p.R
+	case 1:
+		return p.G
+	default:
+		return p.B
+	}
+}
+
+// indexWith converts the PPM image to an indexed image with the given palette
+// of at most 256 colors, mapping each pixel to the closest palette color.
+func (ppm *PPM) indexWith(palette []Pixel) *Indexed {
+	indexed := &Indexed{
+		data:        make([][]uint8, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		palette:     palette,
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+	indexes := make(map[Pixel]uint8)
+	for y := 0; y < ppm.height; y++ {
+		indexed.data[y] = make([]uint8, ppm.width)
+		for x, pixel := range ppm.data[y] {
+			index, ok := indexes[pixel]
+			if !ok {
+				index = closestColor(palette, pixel)
+				indexes[pixel] = index
+			}
+			indexed.data[y][x] = index
+		}
+	}
+	return indexed
+}
+
+// closestColor returns the index of the palette color nearest to p, by
+// squared RGB distance, the first one among equally close colors.
+func closestColor(palette []Pixel, p Pixel) uint8 {
+	best, bestDist := 0, -1
+	for i, c := range palette {
+		dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+		if d := dr*dr + dg*dg + db*db; bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return uint8(best)
+}
+
+// Size returns the width and height of the indexed image.
+func (img *Indexed) Size() (int, int) {
+	return img.width, img.height
+}
+
+// Palette returns a copy of the palette of the indexed image.
+func (img *Indexed) Palette() []Pixel {
+	return append([]Pixel(nil), img.palette...)
+}
+
+// CyclePalette rotates the palette by shift entries, so that the color at
+// index i moves to index i+shift, wrapping around; negative shifts rotate the
+// other way. Rendering the image after each step of 1 gives the classic color
+// cycling animation. Cycling by the palette length restores the original.
+func (img *Indexed) CyclePalette(shift int) {
+	n := len(img.palette)
+	if n == 0 {
+		return
+	}
+	shift = ((shift % n) + n) % n
+	cycled := make([]Pixel, n)
+	for i, color := range img.palette {
+		cycled[(i+shift)%n] = color
+	}
+	img.palette = cycled
+}
+
+// ToPPM renders the indexed image to a PPM image with the palette colors,
+// keeping the magic number and max value of the original PPM.
+func (img *Indexed) ToPPM() *PPM {
+	ppm := &PPM{
+		data:        make([][]Pixel, img.height),
+		width:       img.width,
+		height:      img.height,
+		magicNumber: img.magicNumber,
+		max:         img.max,
+	}
+	for y := 0; y < img.height; y++ {
+		ppm.data[y] = make([]Pixel, img.width)
+		for x, index := range img.data[y] {
+			ppm.data[y][x] = img.palette[index]
+		}
+	}
+	return ppm
+}
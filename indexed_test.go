@@ -0,0 +1,93 @@
+package Netpbm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCyclePalette(t *testing.T) {
+	ppm, err := NewPPM(4, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := []Pixel{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}}
+	for x := 0; x < 4; x++ {
+		ppm.Set(x, 0, colors[x%3])
+		ppm.Set(x, 1, colors[(x+1)%3])
+	}
+	indexed, err := ppm.ToIndexed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := indexed.Palette(); !slices.Equal(got, colors) {
+		t.Fatalf("got palette %v, want %v", got, colors)
+	}
+	if !indexed.ToPPM().Equal(ppm) {
+		t.Error("ToPPM does not restore the image")
+	}
+
+	// Each step of 1 moves every pixel to the next color of the palette.
+	indexed.CyclePalette(1)
+	if got, want := indexed.ToPPM().At(0, 0), colors[2]; got != want {
+		t.Errorf("after a step of 1: got %v, want %v", got, want)
+	}
+	indexed.CyclePalette(-1)
+	if !indexed.ToPPM().Equal(ppm) {
+		t.Error("a step of -1 does not undo a step of 1")
+	}
+
+	for _, shift := range []int{3, -3, 30} {
+		indexed.CyclePalette(shift)
+		if !indexed.ToPPM().Equal(ppm) {
+			t.Errorf("cycling by %d, a multiple of the palette length, changed the image", shift)
+		}
+	}
+}
+
+func TestToIndexedManyColors(t *testing.T) {
+	// A smooth photo-like gradient of 64*64 distinct colors.
+	ppm, err := NewPPM(64, 64, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			ppm.Set(x, y, Pixel{uint8(4 * x), uint8(4 * y), uint8(2*x + 2*y)})
+		}
+	}
+	if n := ppm.DistinctColors(); n <= 256 {
+		t.Fatalf("got %d distinct colors, want more than 256", n)
+	}
+
+	indexed, err := ppm.ToIndexed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(indexed.Palette()); n != 256 {
+		t.Errorf("got %d palette colors, want 256", n)
+	}
+	// Each pixel stays close to its original color.
+	rendered := indexed.ToPPM()
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			a, b := ppm.At(x, y), rendered.At(x, y)
+			dr, dg, db := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B)
+			if max(dr, -dr, dg, -dg, db, -db) > 24 {
+				t.Errorf("(%d, %d): %v became %v", x, y, a, b)
+			}
+		}
+	}
+
+	few, err := ppm.Quantize(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(few.Palette()); n != 4 {
+		t.Errorf("Quantize(4): got %d palette colors", n)
+	}
+	for _, colors := range []int{0, 257} {
+		if _, err := ppm.Quantize(colors); err == nil {
+			t.Errorf("Quantize(%d): got no error", colors)
+		}
+	}
+}
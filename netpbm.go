@@ -0,0 +1,608 @@
+package Netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// metadata is the JSON view of an image header. Pixel data is intentionally
+// left out so that catalogs of images stay small.
+type metadata struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Max    int    `json:"max"`
+}
+
+// maxJSONPixels bounds the blank image UnmarshalJSON allocates, since the
+// dimensions come from untrusted JSON: 1<<26 pixels is 8192x8192.
+const maxJSONPixels = 1 << 26
+
+// checkSize returns an error if the dimensions are negative or describe more
+// than maxJSONPixels pixels.
+func (m metadata) checkSize() error {
+	if m.Width < 0 || m.Height < 0 {
+		return fmt.Errorf("invalid dimensions: %dx%d", m.Width, m.Height)
+	}
+	if m.Width > 0 && m.Height > maxJSONPixels/m.Width {
+		return fmt.Errorf("image too large: %dx%d exceeds %d pixels", m.Width, m.Height, maxJSONPixels)
+	}
+	return nil
+}
+
+// readToken skips leading whitespace and comments, which run from a '#' to the
+// end of the line, and returns the next whitespace-delimited header token.
+// The single whitespace character ending the token is consumed, so for binary
+// formats the reader is left on the first byte of pixel data.
+func readToken(reader *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		if isSpace(b) {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+		if b == '#' && len(token) == 0 {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return "", err
+			}
+			continue
+		}
+		token = append(token, b)
+	}
+}
+
+// readMagic skips any leading whitespace, such as blank lines before the
+// header, and reads the two-character magic number: 'P' followed by a digit.
+// It must be followed by whitespace, which is left for the next token.
+func readMagic(reader *bufio.Reader) (string, error) {
+	b, err := reader.ReadByte()
+	for err == nil && isSpace(b) {
+		b, err = reader.ReadByte()
+	}
+	if err != nil {
+		return "", err
+	}
+	d, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	magicNumber := string([]byte{b, d})
+	if b != 'P' || d < '0' || d > '9' {
+		return "", fmt.Errorf("invalid magic number: %q", magicNumber)
+	}
+	if next, err := reader.Peek(1); err == nil && !isSpace(next[0]) && next[0] != '#' {
+		return "", fmt.Errorf("invalid magic number: %q is not followed by whitespace", magicNumber)
+	}
+	return magicNumber, nil
+}
+
+// readInt reads the next header token and parses it as a decimal integer.
+func readInt(reader *bufio.Reader) (int, error) {
+	token, err := readToken(reader)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(token)
+}
+
+// readSample reads the next token of ASCII (P2 or P3) pixel data and parses
+// it as a sample between 0 and max. Being token based, it accepts values
+// separated by any whitespace, including CRLF line endings and blank lines,
+// regardless of how they are split into lines.
+func readSample(reader *bufio.Reader, max uint8) (uint8, error) {
+	value, err := readInt(reader)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 || value > int(max) {
+		return 0, fmt.Errorf("value %d out of range [0, %d]", value, max)
+	}
+	return uint8(value), nil
+}
+
+// readMaxValue reads the max value header field, which must lie in [1, 65535].
+// Max values above 255 mean two bytes per sample in the binary formats.
+func readMaxValue(reader *bufio.Reader) (int, error) {
+	max, err := readInt(reader)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max value: %v", err)
+	}
+	if max <= 0 || max > 65535 {
+		return 0, fmt.Errorf("invalid max value: %d", max)
+	}
+	return max, nil
+}
+
+// Endianness selects the byte order of the 2-byte samples of binary images
+// with a max value above 255, which the format requires to be big-endian.
+type Endianness int
+
+const (
+	BigEndian    Endianness = iota // Most significant byte first, as the format specifies.
+	LittleEndian                   // Least significant byte first, as written by some nonconforming tools.
+	DetectEndian                   // Big-endian, unless that gives samples above the max value and little-endian does not.
+)
+
+// Options16 configures ReadPGM16Opts and ReadPPM16Opts. The zero value reads
+// standard files.
+type Options16 struct {
+	Endianness Endianness // Byte order of 2-byte samples, ignored for ASCII files and max values up to 255.
+}
+
+// readSamples16 fills samples with the next samples of pixel data: decimal
+// tokens for the plain (ASCII) formats, otherwise one byte per sample, or two
+// bytes in the given order when max is above 255.
+func readSamples16(reader *bufio.Reader, plain bool, max uint16, order Endianness, samples []uint16) error {
+	if plain {
+		for i := range samples {
+			value, err := readInt(reader)
+			if err != nil {
+				return err
+			}
+			if value < 0 || value > int(max) {
+				return fmt.Errorf("value %d out of range [0, %d]", value, max)
+			}
+			samples[i] = uint16(value)
+		}
+		return nil
+	}
+
+	size := 1
+	if max > 255 {
+		size = 2
+	}
+	buf := make([]byte, len(samples)*size)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return err
+	}
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	if order == LittleEndian {
+		byteOrder = binary.LittleEndian
+	}
+	for i := range samples {
+		if size == 2 {
+			samples[i] = byteOrder.Uint16(buf[i*2:])
+		} else {
+			samples[i] = uint16(buf[i])
+		}
+		if samples[i] > max {
+			return fmt.Errorf("value %d out of range [0, %d]", samples[i], max)
+		}
+	}
+	return nil
+}
+
+// resolveEndianness turns DetectEndian into the byte order of the count binary
+// 2-byte samples that follow, which it reads ahead and returns with a reader
+// over the same data. Big-endian is kept unless some big-endian samples are
+// above max while no little-endian sample is. Other orders, ASCII formats and
+// max values up to 255 are returned as they are.
+func resolveEndianness(reader *bufio.Reader, plain bool, max uint16, order Endianness, count int) (*bufio.Reader, Endianness, error) {
+	if order != DetectEndian {
+		return reader, order, nil
+	}
+	if plain || max <= 255 {
+		return reader, BigEndian, nil
+	}
+
+	buf := make([]byte, count*2)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, 0, fmt.Errorf("error reading pixel data: %v", err)
+	}
+	within := func(byteOrder binary.ByteOrder) bool {
+		for i := 0; i < len(buf); i += 2 {
+			if byteOrder.Uint16(buf[i:]) > max {
+				return false
+			}
+		}
+		return true
+	}
+	order = BigEndian
+	if !within(binary.BigEndian) && within(binary.LittleEndian) {
+		order = LittleEndian
+	}
+	return bufio.NewReader(bytes.NewReader(buf)), order, nil
+}
+
+// writeSamples16 writes one row of samples in the layout read by readSamples16,
+// ending plain rows with a newline.
+func writeSamples16(writer *bufio.Writer, plain bool, max uint16, samples []uint16) error {
+	if plain {
+		for i, v := range samples {
+			if i > 0 {
+				if err := writer.WriteByte(' '); err != nil {
+					return err
+				}
+			}
+			if _, err := writer.WriteString(strconv.Itoa(int(v))); err != nil {
+				return err
+			}
+		}
+		return writer.WriteByte('\n')
+	}
+
+	for _, v := range samples {
+		if max > 255 {
+			if err := writer.WriteByte(byte(v >> 8)); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteByte(byte(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleTo8 maps a sample from [0, max] to [0, 255], rounding to the nearest
+// value. Samples above max are treated as max.
+func scaleTo8(v, max uint16) uint8 {
+	v = min(v, max)
+	return uint8((uint32(v)*255 + uint32(max)/2) / uint32(max))
+}
+
+// isSpace reports whether b is whitespace as defined by the netpbm formats.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// ResampleFilter selects the interpolation used when resizing an image.
+type ResampleFilter int
+
+const (
+	Nearest  ResampleFilter = iota // Nearest neighbor, fast and blocky.
+	Bilinear                       // Linear interpolation between the 2x2 closest pixels.
+	Bicubic                        // Catmull-Rom cubic interpolation over 4x4 pixels.
+	Lanczos                        // Lanczos-3 windowed sinc over 6x6 pixels, the sharpest.
+	Area                           // Box averaging, best suited for shrinking.
+)
+
+// Interpolation selects the sampling used by Resize. It is the same type as
+// ResampleFilter, so every ResampleFilter constant can be used as well.
+type Interpolation = ResampleFilter
+
+// NearestNeighbor is an alias of Nearest, for use with Resize.
+const NearestNeighbor = Nearest
+
+// contribution lists the source pixels, starting at start, and their weights
+// that make up one destination pixel along one axis.
+type contribution struct {
+	start   int
+	weights []float64
+}
+
+// resampleWeights computes, for each of the dstLen destination pixels, the
+// weighted source pixels of a srcLen axis. When shrinking, the filter is
+// stretched so that every source pixel contributes. Source indexes beyond
+// the edges are clamped to the border pixels.
+func resampleWeights(srcLen, dstLen int, filter ResampleFilter) []contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	contributions := make([]contribution, dstLen)
+
+	if filter == Nearest {
+		for i := range contributions {
+			src := int((float64(i) + 0.5) * scale)
+			if src >= srcLen {
+				src = srcLen - 1
+			}
+			contributions[i] = contribution{src, []float64{1}}
+		}
+		return contributions
+	}
+
+	var kernel func(float64) float64
+	var support float64
+	switch filter {
+	case Bicubic:
+		kernel, support = catmullRom, 2
+	case Lanczos:
+		kernel, support = lanczos3, 3
+	case Area:
+		kernel, support = box, 0.5
+	default:
+		kernel, support = triangle, 1
+	}
+
+	filterScale := math.Max(scale, 1)
+	for i := range contributions {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support*filterScale))
+		last := int(math.Floor(center + support*filterScale))
+
+		// Weights are accumulated on clamped indexes, so the span is clamped too.
+		start, end := max(first, 0), min(last, srcLen-1)
+		weights := make([]float64, end-start+1)
+		sum := 0.0
+		for j := first; j <= last; j++ {
+			w := kernel((float64(j) - center) / filterScale)
+			weights[min(max(j, start), end)-start] += w
+			sum += w
+		}
+		if sum != 0 {
+			for k := range weights {
+				weights[k] /= sum
+			}
+		}
+		contributions[i] = contribution{start, weights}
+	}
+	return contributions
+}
+
+func box(x float64) float64 {
+	if x >= -0.5 && x <= 0.5 {
+		return 1
+	}
+	return 0
+}
+
+func triangle(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1 - x
+	}
+	return 0
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+	return 0
+}
+
+func lanczos3(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if x <= -3 || x >= 3 {
+		return 0
+	}
+	px := math.Pi * x
+	return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+}
+
+// clampSample rounds v and clamps it to the range [0, max].
+func clampSample(v float64, max uint8) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= float64(max) {
+		return max
+	}
+	return uint8(v + 0.5)
+}
+
+// clampCount is like clampSample, but also counts in low and high the values
+// that fell below 0 or above max, so that callers can report saturation.
+func clampCount(v float64, max uint8, low, high *int) uint8 {
+	if v < 0 {
+		*low++
+	} else if v > float64(max) {
+		*high++
+	}
+	return clampSample(v, max)
+}
+
+// toLinear converts a normalized sRGB value in [0, 1] to linear light.
+func toLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// toSRGB converts a linear light value in [0, 1] back to normalized sRGB.
+func toSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// motionKernel returns the pixel offsets of a line-shaped kernel of the given
+// length, centered on the origin and oriented at angleDegrees counter-clockwise
+// from the x-axis. All offsets have the same weight.
+func motionKernel(length int, angleDegrees float64) []Point {
+	sin, cos := math.Sincos(angleDegrees * math.Pi / 180)
+	offsets := make([]Point, length)
+	for i := range offsets {
+		t := float64(i) - float64(length-1)/2
+		// The y-axis points down in images, hence the minus sign.
+		offsets[i] = Point{int(math.Round(t * cos)), int(math.Round(-t * sin))}
+	}
+	return offsets
+}
+
+// Image is the behavior shared by the PBM, PGM and PPM types.
+type Image interface {
+	Size() (int, int)
+	Save(filename string) error
+}
+
+// LoadOptimal reads a PBM, PGM or PPM file and returns it in the most compact
+// type that holds its pixels without loss: a PPM whose pixels are all gray
+// becomes a PGM, and a PGM whose only values are black (0) and white (max)
+// becomes a PBM. The concrete type of the returned Image therefore varies
+// and should be inspected with a type switch.
+func LoadOptimal(filename string) (Image, error) {
+	magicNumber, err := readMagicNumber(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var pgm *PGM
+	switch magicNumber {
+	case "P1", "P4":
+		return ReadPBM(filename)
+	case "P2", "P5":
+		pgm, err = ReadPGM(filename)
+		if err != nil {
+			return nil, err
+		}
+	case "P3", "P6":
+		ppm, err := ReadPPM(filename)
+		if err != nil {
+			return nil, err
+		}
+		if !ppm.IsGrayscale() {
+			return ppm, nil
+		}
+		pgm = ppm.grayChannel()
+	default:
+		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
+	}
+
+	if pgm.DistinctColors() > 2 {
+		return pgm, nil
+	}
+	for y := range pgm.data {
+		for _, v := range pgm.data[y] {
+			if v != 0 && v != pgm.max {
+				return pgm, nil
+			}
+		}
+	}
+	pbm := &PBM{data: make([][]bool, pgm.height), width: pgm.width, height: pgm.height, magicNumber: "P1"}
+	if pgm.magicNumber == "P5" {
+		pbm.magicNumber = "P4"
+	}
+	for y := range pbm.data {
+		pbm.data[y] = make([]bool, pgm.width)
+		for x, v := range pgm.data[y] {
+			pbm.data[y][x] = v == 0
+		}
+	}
+	return pbm, nil
+}
+
+// readMagicNumber returns the magic number at the start of a file.
+func readMagicNumber(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magicNumber, err := readMagic(bufio.NewReader(file))
+	if err != nil {
+		return "", fmt.Errorf("error reading magic number: %v", err)
+	}
+	return magicNumber, nil
+}
+
+// tokenWriter writes space-separated ASCII tokens, starting a new line before
+// a token that would make the line longer than maxLineLen. A maxLineLen of 0
+// disables wrapping.
+type tokenWriter struct {
+	writer     *bufio.Writer
+	maxLineLen int
+	lineLen    int
+}
+
+// write writes one token, preceded by a space or a newline as needed.
+func (tw *tokenWriter) write(token string) error {
+	if tw.lineLen > 0 {
+		separator := " "
+		if tw.maxLineLen > 0 && tw.lineLen+1+len(token) > tw.maxLineLen {
+			separator = "\n"
+			tw.lineLen = 0
+		} else {
+			tw.lineLen++
+		}
+		if _, err := tw.writer.WriteString(separator); err != nil {
+			return err
+		}
+	}
+	tw.lineLen += len(token)
+	_, err := tw.writer.WriteString(token)
+	return err
+}
+
+// end terminates the last line.
+func (tw *tokenWriter) end() error {
+	if tw.lineLen == 0 {
+		return nil
+	}
+	tw.lineLen = 0
+	return tw.writer.WriteByte('\n')
+}
+
+// copyRect returns a copy of the width x height rectangle of data whose
+// top-left corner is (x, y), which must lie within data.
+func copyRect[T any](data [][]T, x, y, width, height int) [][]T {
+	out := make([][]T, height)
+	for i := range out {
+		out[i] = append([]T(nil), data[y+i][x:x+width]...)
+	}
+	return out
+}
+
+// lineProfile returns the values of data along the Bresenham line between two
+// points, in order from p1 to p2, skipping the points outside the image.
+func lineProfile[T any](data [][]T, width, height int, p1, p2 Point) []T {
+	var profile []T
+	bresenham(p1, p2, func(p Point) {
+		if p.X >= 0 && p.X < width && p.Y >= 0 && p.Y < height {
+			profile = append(profile, data[p.Y][p.X])
+		}
+	})
+	return profile
+}
+
+// rotateExpanded returns the pixel grid rotated by the given angle in degrees
+// (positive is counter-clockwise on screen) around its center, on a canvas
+// enlarged to fit the whole rotated image, with its new width and height.
+// Each pixel takes the value of the nearest source pixel, or fill if its
+// source falls outside the original image.
+func rotateExpanded[T any](data [][]T, width, height int, degrees float64, fill T) ([][]T, int, int) {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	newWidth := int(math.Ceil(math.Abs(float64(width)*cos) + math.Abs(float64(height)*sin) - 1e-9))
+	newHeight := int(math.Ceil(math.Abs(float64(width)*sin) + math.Abs(float64(height)*cos) - 1e-9))
+	return rotateNearest(data, width, height, newWidth, newHeight, degrees, fill), newWidth, newHeight
+}
+
+// rotateNearest returns a newWidth x newHeight grid holding the pixel grid
+// rotated by the given angle in degrees (positive is counter-clockwise on
+// screen), with the centers of both grids aligned. Each pixel takes the value
+// of the nearest source pixel, or fill if its source falls outside the
+// original image.
+func rotateNearest[T any](data [][]T, width, height, newWidth, newHeight int, degrees float64, fill T) [][]T {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(width-1)/2, float64(height-1)/2
+	ncx, ncy := float64(newWidth-1)/2, float64(newHeight-1)/2
+
+	newData := make([][]T, newHeight)
+	for y := range newData {
+		newData[y] = make([]T, newWidth)
+		for x := range newData[y] {
+			dx, dy := float64(x)-ncx, float64(y)-ncy
+			sx := int(math.Round(cx + dx*cos - dy*sin))
+			sy := int(math.Round(cy + dx*sin + dy*cos))
+			if sx >= 0 && sx < width && sy >= 0 && sy < height {
+				newData[y][x] = data[sy][sx]
+			} else {
+				newData[y][x] = fill
+			}
+		}
+	}
+	return newData
+}
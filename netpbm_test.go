@@ -0,0 +1,440 @@
+package Netpbm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveNilImage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "nil")
+	saves := map[string]func() error{
+		"PBM.SaveBinary": func() error { return (*PBM)(nil).SaveBinary(filename) },
+		"PBM.SaveASCII":  func() error { return (*PBM)(nil).SaveASCII(filename) },
+		"PGM.SaveBinary": func() error { return (*PGM)(nil).SaveBinary(filename) },
+		"PGM.SaveASCII":  func() error { return (*PGM)(nil).SaveASCII(filename) },
+		"PPM.SaveBinary": func() error { return (*PPM)(nil).SaveBinary(filename) },
+		"PPM.SaveASCII":  func() error { return (*PPM)(nil).SaveASCII(filename) },
+	}
+	for name, save := range saves {
+		if err := save(); err == nil {
+			t.Errorf("%s on a nil image: got no error", name)
+		}
+	}
+}
+
+func TestDecodeHeaderOnOneLine(t *testing.T) {
+	pbm, err := DecodePBM(strings.NewReader("P1 2 1 1 0"))
+	if err != nil {
+		t.Fatalf("DecodePBM: %v", err)
+	}
+	if w, h := pbm.Size(); w != 2 || h != 1 || !pbm.At(0, 0) || pbm.At(1, 0) {
+		t.Errorf("DecodePBM: got %dx%d %v, want 2x1 [true false]", w, h, pbm.data)
+	}
+
+	pgm, err := DecodePGM(strings.NewReader("P5 2 1 255 \x07\xff"))
+	if err != nil {
+		t.Fatalf("DecodePGM: %v", err)
+	}
+	if w, h := pgm.Size(); w != 2 || h != 1 || pgm.At(0, 0) != 7 || pgm.At(1, 0) != 255 {
+		t.Errorf("DecodePGM: got %dx%d %v, want 2x1 [7 255]", w, h, pgm.data)
+	}
+
+	ppm, err := DecodePPM(strings.NewReader("P6\t1 1\t255\t\x01\x02\x03"))
+	if err != nil {
+		t.Fatalf("DecodePPM: %v", err)
+	}
+	if got := ppm.At(0, 0); got != (Pixel{1, 2, 3}) {
+		t.Errorf("DecodePPM: got %v, want {1 2 3}", got)
+	}
+}
+
+func TestDecodeLeadingWhitespace(t *testing.T) {
+	for _, prefix := range []string{"\n", "\r\n", "  \n\t\n "} {
+		pbm, err := DecodePBM(strings.NewReader(prefix + "P1\n2 1\n0 1\n"))
+		if err != nil {
+			t.Fatalf("DecodePBM(%q): %v", prefix, err)
+		}
+		if pbm.magicNumber != "P1" || pbm.At(0, 0) || !pbm.At(1, 0) {
+			t.Errorf("DecodePBM(%q): got %s %v, want P1 [false true]", prefix, pbm.magicNumber, pbm.data)
+		}
+
+		pgm, err := DecodePGM(strings.NewReader(prefix + "P5\n2 1\n255\n\x07\xff"))
+		if err != nil {
+			t.Fatalf("DecodePGM(%q): %v", prefix, err)
+		}
+		if pgm.magicNumber != "P5" || pgm.At(0, 0) != 7 || pgm.At(1, 0) != 255 {
+			t.Errorf("DecodePGM(%q): got %s %v, want P5 [7 255]", prefix, pgm.magicNumber, pgm.data)
+		}
+
+		ppm, err := DecodePPM(strings.NewReader(prefix + "P6\n1 1\n255\n\x01\x02\x03"))
+		if err != nil {
+			t.Fatalf("DecodePPM(%q): %v", prefix, err)
+		}
+		if ppm.magicNumber != "P6" || ppm.At(0, 0) != (Pixel{1, 2, 3}) {
+			t.Errorf("DecodePPM(%q): got %s %v, want P6 {1 2 3}", prefix, ppm.magicNumber, ppm.At(0, 0))
+		}
+
+		img, err := LoadOptimal(writeFile(t, []byte(prefix+"P2\n1 1\n255\n9\n")))
+		if err != nil {
+			t.Fatalf("LoadOptimal(%q): %v", prefix, err)
+		}
+		if _, ok := img.(*PGM); !ok {
+			t.Errorf("LoadOptimal(%q): got %T, want *PGM", prefix, img)
+		}
+	}
+
+	// Only whitespace is skipped, and the magic number is exactly two
+	// characters.
+	for _, data := range []string{"\n\n", "x\nP1\n1 1\n0\n", "\nP12 1 1\n0\n"} {
+		if _, err := DecodePBM(strings.NewReader(data)); err == nil {
+			t.Errorf("DecodePBM(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestSaveASCIIWrapped(t *testing.T) {
+	pgm := testPGM(t, 7, 5)
+	ppm := testPPM(t, 6, 4)
+	type image interface {
+		SaveASCIIWrapped(filename string, maxLineLen int) error
+	}
+	images := map[string]struct {
+		image
+		read func(filename string) (bool, error)
+	}{
+		"PGM": {pgm, func(filename string) (bool, error) {
+			got, err := ReadPGM(filename)
+			return err == nil && got.EqualPixels(pgm), err
+		}},
+		"PPM": {ppm, func(filename string) (bool, error) {
+			got, err := ReadPPM(filename)
+			return err == nil && got.EqualPixels(ppm), err
+		}},
+	}
+
+	for name, img := range images {
+		for _, maxLineLen := range []int{0, 1, 3, 10, 70} {
+			filename := filepath.Join(t.TempDir(), "image")
+			if err := img.SaveASCIIWrapped(filename, maxLineLen); err != nil {
+				t.Fatalf("%s, %d: %v", name, maxLineLen, err)
+			}
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The header takes three lines, the samples follow.
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[3:]
+			if maxLineLen == 0 && len(lines) != 1 {
+				t.Errorf("%s, 0: got the samples on %d lines, want 1", name, len(lines))
+			}
+			for i, line := range lines {
+				if maxLineLen > 0 && len(line) > maxLineLen && strings.Contains(line, " ") {
+					t.Errorf("%s, %d: line %d is %d characters long: %q", name, maxLineLen, i, len(line), line)
+				}
+				if line == "" || strings.HasPrefix(line, " ") || strings.HasSuffix(line, " ") {
+					t.Errorf("%s, %d: line %d is badly separated: %q", name, maxLineLen, i, line)
+				}
+			}
+
+			if same, err := img.read(filename); !same {
+				t.Errorf("%s, %d: the saved file does not read back as the image: %v", name, maxLineLen, err)
+			}
+		}
+	}
+
+	if err := pgm.SaveASCIIWrapped(filepath.Join(t.TempDir(), "image"), -1); err == nil {
+		t.Error("a negative max line length was accepted")
+	}
+}
+
+func TestRotations(t *testing.T) {
+	// Each image reports whether the rotations of a 4x3 image give the
+	// expected corner pixel and size, and whether rotating back restores it.
+	type rotatable struct {
+		rotate90CW, rotate90CCW, rotate180 func()
+		size                               func() (int, int)
+		topLeft                            func() string
+		original                           func() bool
+	}
+	pbm := pbmFromRows("....", "...#", "#.##")
+	pbmOrig := pbm.Clone()
+	pgm := testPGM(t, 4, 3)
+	ppm := testPPM(t, 4, 3)
+	images := map[string]rotatable{
+		"PBM": {pbm.Rotate90CW, pbm.Rotate90CCW, pbm.Rotate180, pbm.Size,
+			func() string { return fmt.Sprint(pbm.At(0, 0), pbm.At(1, 0)) },
+			func() bool { return pbm.Equal(pbmOrig) }},
+		"PGM": {pgm.Rotate90CW, pgm.Rotate90CCW, pgm.Rotate180, pgm.Size,
+			func() string { return fmt.Sprint(pgm.At(0, 0)) },
+			func() bool { return pgm.Equal(testPGM(t, 4, 3)) }},
+		"PPM": {ppm.Rotate90CW, ppm.Rotate90CCW, ppm.Rotate180, ppm.Size,
+			func() string { return fmt.Sprint(ppm.At(0, 0)) },
+			func() bool { return ppm.Equal(testPPM(t, 4, 3)) }},
+	}
+	// The pixel that ends up top-left: bottom-left after 90° CW, top-right
+	// after 90° CCW and bottom-right after 180°.
+	corners := map[string][3]string{
+		"PBM": {"true false", "false true", "true true"},
+		"PGM": {"8", "3", "11"},
+		"PPM": {"{0 2 26}", "{3 0 21}", "{3 2 47}"},
+	}
+
+	for name, img := range images {
+		img.rotate90CW()
+		if w, h := img.size(); w != 3 || h != 4 {
+			t.Errorf("%s Rotate90CW: got size %dx%d, want 3x4", name, w, h)
+		}
+		if got := img.topLeft(); got != corners[name][0] {
+			t.Errorf("%s Rotate90CW: got top-left %s, want %s", name, got, corners[name][0])
+		}
+		img.rotate90CCW()
+		if !img.original() {
+			t.Errorf("%s: Rotate90CW then Rotate90CCW does not restore the image", name)
+		}
+
+		img.rotate90CCW()
+		if w, h := img.size(); w != 3 || h != 4 {
+			t.Errorf("%s Rotate90CCW: got size %dx%d, want 3x4", name, w, h)
+		}
+		if got := img.topLeft(); got != corners[name][1] {
+			t.Errorf("%s Rotate90CCW: got top-left %s, want %s", name, got, corners[name][1])
+		}
+		img.rotate90CW()
+
+		img.rotate180()
+		if w, h := img.size(); w != 4 || h != 3 {
+			t.Errorf("%s Rotate180: got size %dx%d, want 4x3", name, w, h)
+		}
+		if got := img.topLeft(); got != corners[name][2] {
+			t.Errorf("%s Rotate180: got top-left %s, want %s", name, got, corners[name][2])
+		}
+		img.rotate180()
+		if !img.original() {
+			t.Errorf("%s: Rotate180 twice does not restore the image", name)
+		}
+	}
+}
+
+func TestDecodeFromReader(t *testing.T) {
+	files := map[string]string{
+		"PBM": "P4\n10 2\n\xa5\x40\x0f\xc0",
+		"PGM": "P2\n3 1\n15\n0 7 15\n",
+		"PPM": "P6\n1 2\n255\n\x01\x02\x03\xfd\xfe\xff",
+	}
+	decode := map[string]func(r *bytes.Reader) (any, error){
+		"PBM": func(r *bytes.Reader) (any, error) { return DecodePBM(r) },
+		"PGM": func(r *bytes.Reader) (any, error) { return DecodePGM(r) },
+		"PPM": func(r *bytes.Reader) (any, error) { return DecodePPM(r) },
+	}
+	read := map[string]func(filename string) (any, error){
+		"PBM": func(filename string) (any, error) { return ReadPBM(filename) },
+		"PGM": func(filename string) (any, error) { return ReadPGM(filename) },
+		"PPM": func(filename string) (any, error) { return ReadPPM(filename) },
+	}
+
+	for name, data := range files {
+		decoded, err := decode[name](bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatalf("Decode%s: %v", name, err)
+		}
+		filename := filepath.Join(t.TempDir(), "image")
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fromFile, err := read[name](filename)
+		if err != nil {
+			t.Fatalf("Read%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, fromFile) {
+			t.Errorf("Decode%s: got %+v, want %+v as read from a file", name, decoded, fromFile)
+		}
+
+		// A truncated image gives the same error both ways.
+		truncated := data[:len(data)-4]
+		_, decodeErr := decode[name](bytes.NewReader([]byte(truncated)))
+		if err := os.WriteFile(filename, []byte(truncated), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, readErr := read[name](filename)
+		if decodeErr == nil || readErr == nil || decodeErr.Error() != readErr.Error() {
+			t.Errorf("%s truncated: Decode error %v, Read error %v, want the same error", name, decodeErr, readErr)
+		}
+	}
+
+	ppm, err := DecodePPM(bytes.NewReader([]byte(files["PPM"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ppm.At(0, 1) != (Pixel{253, 254, 255}) {
+		t.Errorf("DecodePPM: got %v at (0, 1), want {253 254 255}", ppm.At(0, 1))
+	}
+}
+
+func TestEncode(t *testing.T) {
+	type encoder interface {
+		Encode(w io.Writer) error
+		Save(filename string) error
+	}
+	pbm := pbmFromRows("#.#.#.#.##", ".#.#.#.#..")
+	pgm := testPGM(t, 5, 3)
+	ppm := testPPM(t, 4, 3)
+
+	for _, tt := range []struct {
+		magicNumber string
+		img         encoder
+		decode      func(r io.Reader) (bool, error)
+	}{
+		{"P1", pbm, func(r io.Reader) (bool, error) { got, err := DecodePBM(r); return err == nil && got.Equal(pbm), err }},
+		{"P4", pbm, func(r io.Reader) (bool, error) { got, err := DecodePBM(r); return err == nil && got.Equal(pbm), err }},
+		{"P2", pgm, func(r io.Reader) (bool, error) { got, err := DecodePGM(r); return err == nil && got.Equal(pgm), err }},
+		{"P5", pgm, func(r io.Reader) (bool, error) { got, err := DecodePGM(r); return err == nil && got.Equal(pgm), err }},
+		{"P3", ppm, func(r io.Reader) (bool, error) { got, err := DecodePPM(r); return err == nil && got.Equal(ppm), err }},
+		{"P6", ppm, func(r io.Reader) (bool, error) { got, err := DecodePPM(r); return err == nil && got.Equal(ppm), err }},
+	} {
+		pbm.SetMagicNumber(tt.magicNumber)
+		pgm.SetMagicNumber(tt.magicNumber)
+		ppm.SetMagicNumber(tt.magicNumber)
+
+		var buf bytes.Buffer
+		if err := tt.img.Encode(&buf); err != nil {
+			t.Fatalf("%s: %v", tt.magicNumber, err)
+		}
+		filename := filepath.Join(t.TempDir(), "image")
+		if err := tt.img.Save(filename); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), saved) {
+			t.Errorf("%s: Encode wrote %q, Save wrote %q", tt.magicNumber, buf.Bytes(), saved)
+		}
+		if same, err := tt.decode(&buf); !same {
+			t.Errorf("%s: the encoded image does not decode back: %v", tt.magicNumber, err)
+		}
+
+		// Streaming through a gzip.Writer.
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if err := tt.img.Encode(zw); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same, err := tt.decode(zr); !same {
+			t.Errorf("%s: the gzipped image does not decode back: %v", tt.magicNumber, err)
+		}
+	}
+}
+
+func TestIsUniform(t *testing.T) {
+	gray := Pixel{40, 40, 40}
+	ppm, err := NewPPM(6, 4, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawFilledRectangle(Point{0, 0}, 6, 4, gray)
+	if ok, c := ppm.IsUniform(); !ok || c != gray {
+		t.Errorf("PPM uniform: got %v, %v, want true, %v", ok, c, gray)
+	}
+	// A single pixel off by one in the last channel, at the very end.
+	ppm.Set(5, 3, Pixel{40, 40, 41})
+	if ok, c := ppm.IsUniform(); ok || c != (Pixel{}) {
+		t.Errorf("PPM near-uniform: got %v, %v, want false and the zero color", ok, c)
+	}
+
+	pgm, err := NewPGM(3, 3, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, v := pgm.IsUniform(); !ok || v != 0 {
+		t.Errorf("PGM all black: got %v, %d, want true, 0", ok, v)
+	}
+	pgm.Set(1, 1, 1)
+	if ok, _ := pgm.IsUniform(); ok {
+		t.Error("PGM near-uniform: got true")
+	}
+
+	if ok, v := pbmFromRows("###", "###").IsUniform(); !ok || !v {
+		t.Errorf("PBM all set: got %v, %v, want true, true", ok, v)
+	}
+	if ok, _ := pbmFromRows("###", "##.").IsUniform(); ok {
+		t.Error("PBM near-uniform: got true")
+	}
+
+	if ok, _ := (&PPM{}).IsUniform(); ok {
+		t.Error("empty image: got true")
+	}
+}
+
+func TestCloneIndependence(t *testing.T) {
+	ppm := testPPM(t, 4, 3)
+	ppmClone := ppm.Clone()
+	if !ppmClone.Equal(ppm) {
+		t.Fatal("PPM clone differs from the original")
+	}
+	ppmClone.Invert()
+	ppmClone.SetMagicNumber("P3")
+	if !ppm.Equal(testPPM(t, 4, 3)) {
+		t.Error("inverting the PPM clone changed the original")
+	}
+
+	pgm := testPGM(t, 4, 3)
+	pgmClone := pgm.Clone()
+	if !pgmClone.Equal(pgm) {
+		t.Fatal("PGM clone differs from the original")
+	}
+	pgmClone.Invert()
+	if !pgm.Equal(testPGM(t, 4, 3)) {
+		t.Error("inverting the PGM clone changed the original")
+	}
+
+	pbm := pbmFromRows("#..", ".#.")
+	pbmClone := pbm.Clone()
+	if !pbmClone.Equal(pbm) {
+		t.Fatal("PBM clone differs from the original")
+	}
+	pbmClone.Invert()
+	if !pbm.Equal(pbmFromRows("#..", ".#.")) {
+		t.Error("inverting the PBM clone changed the original")
+	}
+}
+
+func TestUnmarshalJSONSize(t *testing.T) {
+	images := []interface {
+		UnmarshalJSON([]byte) error
+		Size() (int, int)
+	}{&PBM{}, &PGM{}, &PPM{}}
+	for _, img := range images {
+		if err := img.UnmarshalJSON([]byte(`{"format":"P1","width":640,"height":480,"max":255}`)); err != nil {
+			t.Fatalf("%T: %v", img, err)
+		}
+		if w, h := img.Size(); w != 640 || h != 480 {
+			t.Errorf("%T: got %dx%d, want 640x480", img, w, h)
+		}
+		for _, data := range []string{
+			`{"width":100000,"height":100000}`,
+			`{"width":8193,"height":8192}`,
+			`{"width":9223372036854775807,"height":2}`,
+			`{"width":-1,"height":2}`,
+		} {
+			if err := img.UnmarshalJSON([]byte(data)); err == nil {
+				t.Errorf("%T: UnmarshalJSON(%s) succeeded, want an error", img, data)
+			}
+		}
+	}
+}
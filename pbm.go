@@ -2,10 +2,21 @@ package Netpbm
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"image"
 	"io"
+	"iter"
+	"math"
+	"math/bits"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -24,25 +35,60 @@ func ReadPBM(filename string) (*PBM, error) {
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	return DecodePBM(file)
+}
+
+// PBMBitPacking selects how the bits of P4 pixel data are laid out.
+type PBMBitPacking int
+
+const (
+	RowAligned PBMBitPacking = iota // Each row starts on a new byte, as the format specifies.
+	Continuous                      // Bits run on across rows, as written by some scanners and older tools.
+)
+
+// PBMOptions configures ReadPBMOpts. The zero value reads standard files.
+type PBMOptions struct {
+	BitPacking PBMBitPacking // Layout of P4 pixel data, ignored for P1.
+}
+
+// ReadPBMOpts reads a PBM file like ReadPBM, with options to decode
+// non-standard files. With Continuous packing, the P4 pixel data is read as
+// ceil(width*height/8) bytes whose bits follow each other across rows.
+func ReadPBMOpts(filename string, opts PBMOptions) (*PBM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return decodePBM(file, opts)
+}
+
+// DecodePBM reads a PBM image from r, such as an HTTP body or an in-memory
+// buffer, and returns a PBM struct and an error if any.
+func DecodePBM(r io.Reader) (*PBM, error) {
+	return decodePBM(r, PBMOptions{})
+}
+
+// decodePBM implements DecodePBM and ReadPBMOpts.
+func decodePBM(r io.Reader, opts PBMOptions) (*PBM, error) {
+	reader := bufio.NewReader(r)
 
 	// Read and validate the magic number.
-	magicNumber, err := reader.ReadString('\n')
+	magicNumber, err := readMagic(reader)
 	if err != nil {
 		return nil, fmt.Errorf("error reading magic number: %v", err)
 	}
-	magicNumber = strings.TrimSpace(magicNumber)
 	if magicNumber != "P1" && magicNumber != "P4" {
 		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
 	}
 
 	// Read and parse image dimensions.
-	dimensions, err := reader.ReadString('\n')
+	width, err := readInt(reader)
 	if err != nil {
-		return nil, fmt.Errorf("error reading dimensions: %v", err)
+		return nil, fmt.Errorf("invalid dimensions: %v", err)
 	}
-	var width, height int
-	_, err = fmt.Sscanf(strings.TrimSpace(dimensions), "%d %d", &width, &height)
+	height, err := readInt(reader)
 	if err != nil {
 		return nil, fmt.Errorf("invalid dimensions: %v", err)
 	}
@@ -56,37 +102,49 @@ func ReadPBM(filename string) (*PBM, error) {
 	// Handle P1 format (ASCII).
 	if magicNumber == "P1" {
 		for y := 0; y < height; y++ {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				return nil, fmt.Errorf("error reading data at row %d: %v", y, err)
-			}
-			fields := strings.Fields(line)
-			for x, field := range fields {
-				if x >= width {
-					return nil, fmt.Errorf("index out of range at row %d", y)
+			for x := 0; x < width; x++ {
+				data[y][x], err = readBit(reader)
+				if err != nil {
+					return nil, fmt.Errorf("error reading data at row %d, column %d: %v", y, x, err)
 				}
-				data[y][x] = field == "1"
 			}
 		}
 
+	} else if magicNumber == "P4" && opts.BitPacking == Continuous {
+		// Handle P4 format (binary) with bits running on across rows.
+		expectedBytes := (width*height + 7) / 8
+		packed := make([]byte, expectedBytes)
+		n, err := io.ReadFull(reader, packed)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("unexpected end of file, expected %d bytes, got %d", expectedBytes, n)
+			}
+			return nil, fmt.Errorf("error reading pixel data: %v", err)
+		}
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				data[y][x] = (packed[i/8]>>(7-i%8))&1 != 0
+			}
+		}
 	} else if magicNumber == "P4" {
 		// Handle P4 format (binary).
 		expectedBytesPerRow := (width + 7) / 8
 
 		for y := 0; y < height; y++ {
 			row := make([]byte, expectedBytesPerRow)
-			n, err := reader.Read(row)
+			n, err := io.ReadFull(reader, row)
 			if err != nil {
 				if err == io.EOF {
 					return nil, fmt.Errorf("unexpected end of file at row %d", y)
 				}
+				if err == io.ErrUnexpectedEOF {
+					return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, expectedBytesPerRow, n)
+				}
 				return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
 			}
 
-			if n < expectedBytesPerRow {
-				return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, expectedBytesPerRow, n)
-			}
-
 			for x := 0; x < width; x++ {
 				byteIndex := x / 8
 				bitIndex := 7 - (x % 8)
@@ -99,6 +157,48 @@ func ReadPBM(filename string) (*PBM, error) {
 	return &PBM{data, width, height, magicNumber}, nil
 }
 
+// NewPBM creates a blank (all false) P4 image of the given size.
+// It returns an error if the width or height is not positive.
+func NewPBM(width, height int) (*PBM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", width, height)
+	}
+	pbm := &PBM{
+		data:        make([][]bool, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P4",
+	}
+	for i := range pbm.data {
+		pbm.data[i] = make([]bool, width)
+	}
+	return pbm, nil
+}
+
+// readBit reads the next pixel of P1 data. Pixels are single '0' or '1'
+// characters that may be separated by any whitespace, including CRLF line
+// endings and blank lines, or not separated at all.
+func readBit(reader *bufio.Reader) (bool, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case isSpace(b):
+			continue
+		case b == '#':
+			if _, err := reader.ReadString('\n'); err != nil {
+				return false, err
+			}
+		case b == '0' || b == '1':
+			return b == '1', nil
+		default:
+			return false, fmt.Errorf("invalid pixel value: %q", b)
+		}
+	}
+}
+
 // Size returns the width and height of the PBM image.
 func (pbm *PBM) Size() (int, int) {
 	return pbm.width, pbm.height
@@ -126,50 +226,62 @@ func (pbm *PBM) Save(filename string) error {
 	}
 	defer file.Close()
 
+	return pbm.Encode(file)
+}
+
+// Encode writes the PBM image to w in the specified format (P1 or P4), so it
+// can be streamed to a gzip.Writer or an HTTP response without touching disk.
+func (pbm *PBM) Encode(w io.Writer) error {
+	if pbm == nil {
+		return errors.New("cannot save a nil PBM")
+	}
+
+	writer := bufio.NewWriter(w)
+
 	// Write magic number, width, and height.
-	fmt.Fprintf(file, "%s\n%d %d\n", pbm.magicNumber, pbm.width, pbm.height)
+	_, err := fmt.Fprintf(writer, "%s\n%d %d\n", pbm.magicNumber, pbm.width, pbm.height)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
 
 	// Save in the appropriate format based on the magic number.
 	if pbm.magicNumber == "P1" {
-		return pbm.saveP1(file)
+		err = pbm.saveP1(writer)
 	} else if pbm.magicNumber == "P4" {
-		return pbm.saveP4(file)
+		err = pbm.saveP4(writer)
 	} else {
 		return fmt.Errorf("unsupported magic number: %s", pbm.magicNumber)
 	}
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
 }
 
 // saveP1 saves the PBM image in P1 format (ASCII).
-func (pbm *PBM) saveP1(file *os.File) error {
+func (pbm *PBM) saveP1(writer io.Writer) error {
 	for y := 0; y < pbm.height; y++ {
 		for x := 0; x < pbm.width; x++ {
 			if pbm.data[y][x] {
-				fmt.Fprint(file, "1")
+				fmt.Fprint(writer, "1")
 			} else {
-				fmt.Fprint(file, "0")
+				fmt.Fprint(writer, "0")
 			}
 			if x < pbm.width-1 {
-				fmt.Fprint(file, " ")
+				fmt.Fprint(writer, " ")
 			}
 		}
-		fmt.Fprintln(file)
+		fmt.Fprintln(writer)
 	}
 	return nil
 }
 
 // saveP4 saves the PBM image in P4 format (binary).
-func (pbm *PBM) saveP4(file *os.File) error {
-	expectedBytesPerRow := (pbm.width + 7) / 8
+func (pbm *PBM) saveP4(writer io.Writer) error {
+	row := make([]byte, (pbm.width+7)/8)
 	for y := 0; y < pbm.height; y++ {
-		row := make([]byte, expectedBytesPerRow)
-		for x := 0; x < pbm.width; x++ {
-			byteIndex := x / 8
-			bitIndex := 7 - (x % 8)
-			if pbm.data[y][x] {
-				row[byteIndex] |= 1 << bitIndex
-			}
-		}
-		_, err := file.Write(row)
+		packRow(row, pbm.data[y])
+		_, err := writer.Write(row)
 		if err != nil {
 			return fmt.Errorf("error writing pixel data at row %d: %v", y, err)
 		}
@@ -177,6 +289,18 @@ func (pbm *PBM) saveP4(file *os.File) error {
 	return nil
 }
 
+// packRow packs the pixels of a row into packed as P4 stores them, 8 per byte
+// with the first pixel in the most significant bit. packed must hold
+// (len(row)+7)/8 bytes; the padding bits of its last byte are left clear.
+func packRow(packed []byte, row []bool) {
+	clear(packed)
+	for x, v := range row {
+		if v {
+			packed[x/8] |= 1 << (7 - x%8)
+		}
+	}
+}
+
 // Invert inverts the colors of the PBM image by flipping pixel values.
 func (pbm *PBM) Invert() {
 	for y := 0; y < pbm.height; y++ {
@@ -202,7 +326,668 @@ func (pbm *PBM) Flop() {
 	}
 }
 
+// Rotate90CW rotates the image 90 degrees clockwise.
+func (pbm *PBM) Rotate90CW() {
+	newData := make([][]bool, pbm.width)
+	for x := range newData {
+		newData[x] = make([]bool, pbm.height)
+		for y := 0; y < pbm.height; y++ {
+			newData[x][pbm.height-y-1] = pbm.data[y][x]
+		}
+	}
+	pbm.data = newData
+	pbm.width, pbm.height = pbm.height, pbm.width
+}
+
+// Rotate90CCW rotates the image 90 degrees counter-clockwise.
+func (pbm *PBM) Rotate90CCW() {
+	newData := make([][]bool, pbm.width)
+	for x := range newData {
+		newData[x] = make([]bool, pbm.height)
+		for y := 0; y < pbm.height; y++ {
+			newData[x][y] = pbm.data[y][pbm.width-x-1]
+		}
+	}
+	pbm.data = newData
+	pbm.width, pbm.height = pbm.height, pbm.width
+}
+
+// Rotate180 rotates the image by 180 degrees, reversing both the row and the column order.
+func (pbm *PBM) Rotate180() {
+	pbm.Flip()
+	pbm.Flop()
+}
+
 // SetMagicNumber updates the magic number of the PBM image (P1 or P4).
 func (pbm *PBM) SetMagicNumber(magicNumber string) {
 	pbm.magicNumber = magicNumber
 }
+
+// pbmGob mirrors PBM with exported fields so it can be handled by encoding/gob.
+type pbmGob struct {
+	Data          [][]bool
+	Width, Height int
+	MagicNumber   string
+}
+
+// GobEncode implements gob.GobEncoder, including the unexported fields of the PBM.
+func (pbm *PBM) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(pbmGob{pbm.data, pbm.width, pbm.height, pbm.magicNumber})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PBM: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, restoring a PBM written by GobEncode.
+func (pbm *PBM) GobDecode(b []byte) error {
+	var g pbmGob
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g)
+	if err != nil {
+		return fmt.Errorf("error decoding PBM: %v", err)
+	}
+	*pbm = PBM{g.Data, g.Width, g.Height, g.MagicNumber}
+	return nil
+}
+
+// MarshalJSON encodes the PBM metadata (format, width, height and max) as JSON.
+// Pixel data is not included.
+func (pbm *PBM) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metadata{pbm.magicNumber, pbm.width, pbm.height, 1})
+}
+
+// UnmarshalJSON restores the PBM metadata written by MarshalJSON.
+// Since pixel data is not part of the JSON, the image is left blank. Images
+// of more than 1<<26 pixels are rejected rather than allocated.
+func (pbm *PBM) UnmarshalJSON(b []byte) error {
+	var m metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if err := m.checkSize(); err != nil {
+		return err
+	}
+	data := make([][]bool, m.Height)
+	for i := range data {
+		data[i] = make([]bool, m.Width)
+	}
+	*pbm = PBM{data, m.Width, m.Height, m.Format}
+	return nil
+}
+
+// SaveBinary writes the PBM image to a file in binary format (P4),
+// regardless of its current magic number. The image itself is not modified.
+func (pbm *PBM) SaveBinary(filename string) error {
+	if pbm == nil {
+		return errors.New("cannot save a nil PBM")
+	}
+	binary := *pbm
+	binary.magicNumber = "P4"
+	return binary.Save(filename)
+}
+
+// SaveASCII writes the PBM image to a file in ASCII format (P1),
+// regardless of its current magic number. The image itself is not modified.
+func (pbm *PBM) SaveASCII(filename string) error {
+	if pbm == nil {
+		return errors.New("cannot save a nil PBM")
+	}
+	ascii := *pbm
+	ascii.magicNumber = "P1"
+	return ascii.Save(filename)
+}
+
+// rleMagicNumber identifies the run-length encoded PBM format written by SaveRLE.
+const rleMagicNumber = "PBMRLE"
+
+// SaveRLE writes the PBM image to a file using a simple run-length encoding
+// that is more compact than P4 for sparse or blocky images.
+//
+// The framing is the magic number "PBMRLE" followed by the width and height
+// as ASCII decimals, each separated by a single whitespace character, like a
+// netpbm header. Each row then follows as unsigned varints holding the lengths
+// of alternating runs of white (false) and black (true) pixels. Every row
+// starts with a white run, which may be zero, and its runs add up to the width.
+func (pbm *PBM) SaveRLE(filename string) error {
+	if pbm == nil {
+		return errors.New("cannot save a nil PBM")
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	_, err = fmt.Fprintf(writer, "%s\n%d %d\n", rleMagicNumber, pbm.width, pbm.height)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	for y := 0; y < pbm.height; y++ {
+		value := false
+		run := 0
+		for x := 0; x <= pbm.width; x++ {
+			if x < pbm.width && pbm.data[y][x] == value {
+				run++
+				continue
+			}
+			n := binary.PutUvarint(buf, uint64(run))
+			if _, err := writer.Write(buf[:n]); err != nil {
+				return fmt.Errorf("error writing pixel data at row %d: %v", y, err)
+			}
+			value = !value
+			run = 1
+		}
+	}
+
+	return writer.Flush()
+}
+
+// ReadPBMRLE reads a run-length encoded PBM file written by SaveRLE.
+// The returned image uses the P4 magic number.
+func ReadPBMRLE(filename string) (*PBM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	// Read and validate the magic number.
+	magicNumber, err := readToken(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading magic number: %v", err)
+	}
+	if magicNumber != rleMagicNumber {
+		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
+	}
+
+	// Read and parse image dimensions.
+	width, err := readInt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	height, err := readInt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid dimensions: width and height must not be negative")
+	}
+
+	data := make([][]bool, height)
+	for y := range data {
+		data[y] = make([]bool, width)
+		// Every row holds at least one run, even if the image has no columns.
+		value := false
+		for x := 0; ; value = !value {
+			run, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, fmt.Errorf("error reading run at row %d: %v", y, err)
+			}
+			if run > uint64(width-x) {
+				return nil, fmt.Errorf("run of %d pixels overflows row %d", run, y)
+			}
+			for end := x + int(run); x < end; x++ {
+				data[y][x] = value
+			}
+			if x == width {
+				break
+			}
+		}
+	}
+
+	return &PBM{data, width, height, "P4"}, nil
+}
+
+// SegmentComponents labels the 8-connected components of foreground (true)
+// pixels and returns the bounding boxes of those whose pixel count is larger
+// than minArea, which filters out specks. The boxes are sorted left-to-right,
+// then top-to-bottom, so a scanned line of text comes back in reading order.
+func (pbm *PBM) SegmentComponents(minArea int) []image.Rectangle {
+	var boxes []image.Rectangle
+	for _, component := range pbm.components() {
+		if len(component) <= minArea {
+			continue
+		}
+		box := image.Rect(component[0].X, component[0].Y, component[0].X+1, component[0].Y+1)
+		for _, p := range component[1:] {
+			box = box.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+		}
+		boxes = append(boxes, box)
+	}
+
+	sort.Slice(boxes, func(i, j int) bool {
+		if boxes[i].Min.X != boxes[j].Min.X {
+			return boxes[i].Min.X < boxes[j].Min.X
+		}
+		return boxes[i].Min.Y < boxes[j].Min.Y
+	})
+	return boxes
+}
+
+// All returns an iterator over the coordinates and values of every pixel of the
+// PBM image in row-major order, for use as: for p, v := range pbm.All().
+func (pbm *PBM) All() iter.Seq2[Point, bool] {
+	return func(yield func(Point, bool) bool) {
+		for y := 0; y < pbm.height; y++ {
+			for x := 0; x < pbm.width; x++ {
+				if !yield(Point{x, y}, pbm.data[y][x]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Hash returns a 64-bit FNV-1a hash of the PBM header and pixel data. Images with
+// the same format, dimensions and pixels hash equally, which makes the hash
+// usable as a deduplication or cache key.
+func (pbm *PBM) Hash() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s %d %d\n", pbm.magicNumber, pbm.width, pbm.height)
+	row := make([]byte, pbm.width)
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			row[x] = 0
+			if pbm.data[y][x] {
+				row[x] = 1
+			}
+		}
+		h.Write(row)
+	}
+	return h.Sum64()
+}
+
+// components returns the pixels of each 8-connected component of foreground
+// (true) pixels, found with an explicit stack rather than recursion.
+func (pbm *PBM) components() [][]Point {
+	visited := make([][]bool, pbm.height)
+	for y := range visited {
+		visited[y] = make([]bool, pbm.width)
+	}
+
+	var components [][]Point
+	var stack []Point
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if !pbm.data[y][x] || visited[y][x] {
+				continue
+			}
+
+			var component []Point
+			visited[y][x] = true
+			stack = append(stack[:0], Point{x, y})
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				component = append(component, p)
+
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := p.X+dx, p.Y+dy
+						if nx < 0 || nx >= pbm.width || ny < 0 || ny >= pbm.height {
+							continue
+						}
+						if pbm.data[ny][nx] && !visited[ny][nx] {
+							visited[ny][nx] = true
+							stack = append(stack, Point{nx, ny})
+						}
+					}
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// mooreNeighbors lists the 8 neighbor offsets in clockwise order, starting east.
+var mooreNeighbors = [8]Point{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+
+// TraceContours returns the outer boundary of each 8-connected foreground
+// (true) region, found with Moore-neighbor tracing. Each contour starts at
+// the top-left pixel of its region and lists the boundary pixels clockwise
+// without repeating the first one; a single-pixel region gives a single point.
+// Holes inside regions are not traced.
+func (pbm *PBM) TraceContours() [][]Point {
+	foreground := func(p Point) bool {
+		return p.X >= 0 && p.X < pbm.width && p.Y >= 0 && p.Y < pbm.height && pbm.data[p.Y][p.X]
+	}
+
+	labeled := make([][]bool, pbm.height)
+	for y := range labeled {
+		labeled[y] = make([]bool, pbm.width)
+	}
+
+	var contours [][]Point
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if !pbm.data[y][x] || labeled[y][x] {
+				continue
+			}
+
+			// Mark the whole region so that it is traced only once.
+			labeled[y][x] = true
+			stack := []Point{{x, y}}
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for _, d := range mooreNeighbors {
+					n := Point{p.X + d.X, p.Y + d.Y}
+					if foreground(n) && !labeled[n.Y][n.X] {
+						labeled[n.Y][n.X] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+
+			// The raster scan reaches the region from the west, so the west
+			// neighbor is background and serves as the initial backtrack.
+			start := Point{x, y}
+			contour := []Point{start}
+			current, backtrack, firstMove := start, 4, -1
+			for steps := 0; steps < 8*pbm.width*pbm.height; steps++ {
+				// Walk clockwise around the current pixel from the backtrack
+				// until the next foreground pixel.
+				next := -1
+				for k := 1; k < 8; k++ {
+					d := (backtrack + k) % 8
+					if foreground(Point{current.X + mooreNeighbors[d].X, current.Y + mooreNeighbors[d].Y}) {
+						next = d
+						break
+					}
+				}
+				if next < 0 {
+					break // Isolated pixel.
+				}
+
+				// The contour is complete when the start is left the same
+				// way as the first time.
+				if current == start {
+					if next == firstMove {
+						break
+					}
+					if firstMove < 0 {
+						firstMove = next
+					}
+				}
+
+				// The new backtrack is the background neighbor checked just
+				// before the next pixel, seen from the next pixel.
+				b := mooreNeighbors[(next+7)%8]
+				n := mooreNeighbors[next]
+				for i, m := range mooreNeighbors {
+					if m.X == b.X-n.X && m.Y == b.Y-n.Y {
+						backtrack = i
+					}
+				}
+				current = Point{current.X + n.X, current.Y + n.Y}
+				if current != start {
+					contour = append(contour, current)
+				}
+			}
+			contours = append(contours, contour)
+		}
+	}
+	return contours
+}
+
+// ToSVGPath traces the foreground contours and returns them as the d attribute
+// of an SVG <path> element, one closed subpath per region, in pixel-center
+// coordinates. Each contour is simplified with SimplifyPath using the given
+// tolerance in pixels; the default of 0 only drops collinear points, so a
+// filled rectangle becomes its four corners.
+// Only the path data is returned: the caller embeds it in an <svg> element,
+// e.g. <path d="..."/>.
+func (pbm *PBM) ToSVGPath(epsilon ...float64) string {
+	eps := 0.0
+	if len(epsilon) > 0 && epsilon[0] > 0 {
+		eps = epsilon[0]
+	}
+
+	var sb strings.Builder
+	for _, contour := range pbm.TraceContours() {
+		// Close the contour before simplifying so that the start is kept as
+		// an end point, then drop the duplicate again.
+		points := contour
+		if len(contour) > 1 {
+			points = SimplifyPath(append(contour, contour[0]), eps)
+			points = points[:len(points)-1]
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		for i, p := range points {
+			command := "L"
+			if i == 0 {
+				command = "M"
+			}
+			fmt.Fprintf(&sb, "%s%d %d", command, p.X, p.Y)
+		}
+		sb.WriteByte('Z')
+	}
+	return sb.String()
+}
+
+// ResizeToFill scales the image, keeping its aspect ratio, until it covers the
+// whole width x height box, then crops the overflow evenly on both sides so
+// the image ends up exactly width x height ("cover" behavior). Pixels are
+// sampled with nearest neighbor to keep the image binary.
+// Non-positive dimensions leave the image unchanged.
+func (pbm *PBM) ResizeToFill(width, height int) {
+	if width <= 0 || height <= 0 || pbm.width <= 0 || pbm.height <= 0 {
+		return
+	}
+
+	scale := math.Max(float64(width)/float64(pbm.width), float64(height)/float64(pbm.height))
+	scaledW := max(int(math.Ceil(float64(pbm.width)*scale-1e-9)), width)
+	scaledH := max(int(math.Ceil(float64(pbm.height)*scale-1e-9)), height)
+
+	// Nearest neighbor is the only resampling that keeps the image binary.
+	columns := resampleWeights(pbm.width, scaledW, Nearest)
+	rows := resampleWeights(pbm.height, scaledH, Nearest)
+	x0, y0 := (scaledW-width)/2, (scaledH-height)/2
+	newData := make([][]bool, height)
+	for y := range newData {
+		newData[y] = make([]bool, width)
+		for x := range newData[y] {
+			newData[y][x] = pbm.data[rows[y0+y].start][columns[x0+x].start]
+		}
+	}
+	pbm.data = newData
+	pbm.width, pbm.height = width, height
+}
+
+// CleanMask removes noise from a binary mask with a morphological opening,
+// which drops isolated true pixels, followed by a closing, which fills small
+// holes, both using a (2*radius+1) square structuring element.
+func (pbm *PBM) CleanMask(radius int) {
+	if radius <= 0 {
+		return
+	}
+	pbm.data = pbm.morph(pbm.morph(pbm.data, radius, false), radius, true)
+	pbm.data = pbm.morph(pbm.morph(pbm.data, radius, true), radius, false)
+}
+
+// morph returns data dilated (true) or eroded (false) by a (2*radius+1) square.
+// Pixels beyond the borders do not take part.
+func (pbm *PBM) morph(data [][]bool, radius int, dilate bool) [][]bool {
+	result := make([][]bool, pbm.height)
+	for y := 0; y < pbm.height; y++ {
+		result[y] = make([]bool, pbm.width)
+		for x := 0; x < pbm.width; x++ {
+			// A dilated pixel is set if any neighbor is, an eroded one only if all are.
+			value := !dilate
+			for sy := max(y-radius, 0); sy <= min(y+radius, pbm.height-1) && value != dilate; sy++ {
+				for sx := max(x-radius, 0); sx <= min(x+radius, pbm.width-1); sx++ {
+					if data[sy][sx] == dilate {
+						value = dilate
+						break
+					}
+				}
+			}
+			result[y][x] = value
+		}
+	}
+	return result
+}
+
+// Count returns the number of pixels for which pred returns true.
+func (pbm *PBM) Count(pred func(bool) bool) int {
+	count := 0
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if pred(pbm.data[y][x]) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Density returns the fraction of foreground (true) pixels in the image, 0 for
+// an empty image. Each row is packed as in P4 and counted a byte at a time
+// with bits.OnesCount8; the padding bits of the last byte of a row are masked
+// out so they never inflate the count.
+func (pbm *PBM) Density() float64 {
+	if pbm.width <= 0 || pbm.height <= 0 {
+		return 0
+	}
+
+	packed := make([]byte, (pbm.width+7)/8)
+	padding := len(packed)*8 - pbm.width
+	count := 0
+	for y := 0; y < pbm.height; y++ {
+		packRow(packed, pbm.data[y])
+		packed[len(packed)-1] &= 0xff << padding
+		for _, b := range packed {
+			count += bits.OnesCount8(b)
+		}
+	}
+	return float64(count) / float64(pbm.width*pbm.height)
+}
+
+// Decimate keeps every factorX-th column and every factorY-th row, starting
+// with the first ones, without any averaging. This is a fast, crude downscale
+// for previews or point sampling of sparse data. Factors below 1 leave the
+// image unchanged.
+func (pbm *PBM) Decimate(factorX, factorY int) {
+	if factorX < 1 || factorY < 1 {
+		return
+	}
+
+	width := (pbm.width + factorX - 1) / factorX
+	height := (pbm.height + factorY - 1) / factorY
+	data := make([][]bool, height)
+	for y := range data {
+		data[y] = make([]bool, width)
+		for x := range data[y] {
+			data[y][x] = pbm.data[y*factorY][x*factorX]
+		}
+	}
+	pbm.data, pbm.width, pbm.height = data, width, height
+}
+
+// RotateAngle rotates the image by the given angle in degrees around its
+// center, positive angles turning counter-clockwise on screen. The canvas is
+// enlarged to fit the whole rotated image and the newly exposed corners are
+// set to fill. Pixels are resampled with nearest neighbor, which is fast and
+// keeps the original values, at the cost of jagged edges.
+func (pbm *PBM) RotateAngle(degrees float64, fill bool) {
+	pbm.data, pbm.width, pbm.height = rotateExpanded(pbm.data, pbm.width, pbm.height, degrees, fill)
+}
+
+// Crop returns a new image holding a copy of the width x height rectangle whose
+// top-left corner is (x, y), with the same magic number. It returns an error
+// if the rectangle is empty or extends beyond the image.
+func (pbm *PBM) Crop(x, y, width, height int) (*PBM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid crop dimensions: %dx%d", width, height)
+	}
+	if x < 0 || y < 0 || x+width > pbm.width || y+height > pbm.height {
+		return nil, fmt.Errorf("crop rectangle (%d, %d) %dx%d out of bounds of %dx%d image", x, y, width, height, pbm.width, pbm.height)
+	}
+
+	return &PBM{
+		data:        copyRect(pbm.data, x, y, width, height),
+		width:       width,
+		height:      height,
+		magicNumber: pbm.magicNumber,
+	}, nil
+}
+
+// CropTo crops the image in place to the rectangle between two corners, both
+// included, which may be given in any order. It returns an error, leaving the
+// image unchanged, if the rectangle extends beyond the image.
+func (pbm *PBM) CropTo(topLeft, bottomRight Point) error {
+	x0, x1 := min(topLeft.X, bottomRight.X), max(topLeft.X, bottomRight.X)
+	y0, y1 := min(topLeft.Y, bottomRight.Y), max(topLeft.Y, bottomRight.Y)
+	cropped, err := pbm.Crop(x0, y0, x1-x0+1, y1-y0+1)
+	if err != nil {
+		return err
+	}
+	*pbm = *cropped
+	return nil
+}
+
+// IsUniform reports whether every pixel of the image has the same value, and
+// returns that value, or the zero value otherwise. It stops at the first differing
+// pixel, which makes it a cheap check for blank frames. An empty image is not
+// uniform.
+func (pbm *PBM) IsUniform() (bool, bool) {
+	var zero bool
+	if pbm.width <= 0 || pbm.height <= 0 {
+		return false, zero
+	}
+
+	first := pbm.data[0][0]
+	for y := 0; y < pbm.height; y++ {
+		for x := 0; x < pbm.width; x++ {
+			if pbm.data[y][x] != first {
+				return false, zero
+			}
+		}
+	}
+	return true, first
+}
+
+// Clone returns a deep copy of the image: modifying the copy does not affect
+// the original, unlike assigning the struct, which shares the pixel rows.
+func (pbm *PBM) Clone() *PBM {
+	clone := *pbm
+	clone.data = make([][]bool, len(pbm.data))
+	for y, row := range pbm.data {
+		clone.data[y] = append([]bool(nil), row...)
+	}
+	return &clone
+}
+
+// Equal reports whether both images have the same magic number, dimensions
+// and pixels. It returns false if either image is nil.
+func (pbm *PBM) Equal(other *PBM) bool {
+	return pbm.EqualPixels(other) && pbm.magicNumber == other.magicNumber
+}
+
+// EqualPixels is like Equal but ignores the magic number, so that the ASCII
+// and binary forms of the same image compare equal.
+func (pbm *PBM) EqualPixels(other *PBM) bool {
+	if pbm == nil || other == nil {
+		return false
+	}
+	if pbm.width != other.width || pbm.height != other.height {
+		return false
+	}
+	for y := 0; y < pbm.height; y++ {
+		if !slices.Equal(pbm.data[y], other.data[y]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package Netpbm
+
+import (
+	"image"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// pbmFromRows builds a P1 PBM from rows of '#' (true) and '.' (false).
+func pbmFromRows(rows ...string) *PBM {
+	pbm := &PBM{data: make([][]bool, len(rows)), height: len(rows), magicNumber: "P1"}
+	for y, row := range rows {
+		pbm.width = len(row)
+		pbm.data[y] = make([]bool, len(row))
+		for x, c := range row {
+			pbm.data[y][x] = c == '#'
+		}
+	}
+	return pbm
+}
+
+func TestRLERoundTrip(t *testing.T) {
+	pbm := pbmFromRows(
+		"##########........##",
+		"....................",
+		"#.#.#.#.#.#.#.#.#.#.",
+		"###################.",
+	)
+	filename := filepath.Join(t.TempDir(), "image.pbmrle")
+	if err := pbm.SaveRLE(filename); err != nil {
+		t.Fatalf("SaveRLE: %v", err)
+	}
+	got, err := ReadPBMRLE(filename)
+	if err != nil {
+		t.Fatalf("ReadPBMRLE: %v", err)
+	}
+	if !got.EqualPixels(pbm) {
+		t.Errorf("round trip: got %v, want %v", got.data, pbm.data)
+	}
+}
+
+func TestSegmentComponents(t *testing.T) {
+	pbm := pbmFromRows(
+		"..........#...",
+		".##..#....##..",
+		".##.###...#...",
+		"......#.......",
+		"#.........##..",
+	)
+	// The lone pixel at the bottom left is a speck below minArea.
+	got := pbm.SegmentComponents(1)
+	want := []image.Rectangle{
+		image.Rect(1, 1, 3, 3),
+		image.Rect(4, 1, 7, 4),
+		image.Rect(10, 0, 12, 3),
+		image.Rect(10, 4, 12, 5),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTraceContoursRectangle(t *testing.T) {
+	pbm := pbmFromRows(
+		"........",
+		".#####..",
+		".#####..",
+		".#####..",
+		"........",
+	)
+	contours := pbm.TraceContours()
+	if len(contours) != 1 {
+		t.Fatalf("got %d contours, want 1", len(contours))
+	}
+	contour := contours[0]
+
+	// The boundary of a filled 5x3 rectangle has 2*5 + 2*3 - 4 pixels.
+	if len(contour) != 12 {
+		t.Errorf("got %d points %v, want 12", len(contour), contour)
+	}
+	if contour[0] != (Point{1, 1}) {
+		t.Errorf("got start %v, want the top-left pixel {1 1}", contour[0])
+	}
+	seen := map[Point]bool{}
+	for i, p := range contour {
+		// Each point is a neighbor of the next, and the last of the first.
+		q := contour[(i+1)%len(contour)]
+		if abs(p.X-q.X) > 1 || abs(p.Y-q.Y) > 1 || p == q {
+			t.Errorf("points %v and %v are not neighbors", p, q)
+		}
+		if seen[p] {
+			t.Errorf("point %v repeated", p)
+		}
+		seen[p] = true
+		if p.X != 1 && p.X != 5 && p.Y != 1 && p.Y != 3 {
+			t.Errorf("point %v is inside the rectangle", p)
+		}
+	}
+}
+
+func TestTraceContoursSmallShapes(t *testing.T) {
+	tests := []struct {
+		name string
+		pbm  *PBM
+		want [][]Point
+	}{
+		{"single pixel", pbmFromRows("...", ".#.", "..."), [][]Point{{{1, 1}}}},
+		{"horizontal pair", pbmFromRows("....", ".##.", "...."), [][]Point{{{1, 1}, {2, 1}}}},
+		{"diagonal pair", pbmFromRows("#.", ".#"), [][]Point{{{0, 0}, {1, 1}}}},
+		{"two regions", pbmFromRows("#..#", "#..#"), [][]Point{{{0, 0}, {0, 1}}, {{3, 0}, {3, 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.pbm.TraceContours()
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToSVGPath(t *testing.T) {
+	tests := []struct {
+		name string
+		pbm  *PBM
+		want string
+	}{
+		{"filled rectangle", pbmFromRows("......", ".####.", ".####.", ".####.", "......"), "M1 1L4 1L4 3L1 3Z"},
+		{"two regions", pbmFromRows("##..#", "##..#"), "M0 0L1 0L1 1L0 1Z M4 0L4 1Z"},
+		{"empty", pbmFromRows("...", "..."), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pbm.ToSVGPath(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDensity(t *testing.T) {
+	tests := []struct {
+		name string
+		pbm  *PBM
+		want float64
+	}{
+		{"empty", &PBM{}, 0},
+		{"all false", pbmFromRows("...", "..."), 0},
+		{"all true, width 9", pbmFromRows("#########", "#########"), 1},
+		{"width 3", pbmFromRows("#..", "##."), 0.5},
+		{"width 10", pbmFromRows("#.#.#.#.##"), 0.6},
+	}
+	for _, tt := range tests {
+		if got := tt.pbm.Density(); got != tt.want {
+			t.Errorf("%s: got %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeASCIIWhitespace(t *testing.T) {
+	want := pbmFromRows("#.#", ".#.")
+	for _, input := range []string{
+		"P1\r\n3 2\r\n1 0 1\r\n0 1 0\r\n",
+		"P1\n3 2\n1\t0\t1\n\n\n0 1 0",
+		"P1\r\n3 2\r\n101\r\n010\r\n",
+		"P1 3 2 1 0\r\n1 0\r\n\r\n1 0\r\n",
+		"P1\n# comment\r\n3 2\n1 0 1 # end of row\r\n0 1 0\r\n",
+	} {
+		pbm, err := DecodePBM(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if w, h := pbm.Size(); w != 3 || h != 2 || !slices.EqualFunc(pbm.data, want.data, slices.Equal) {
+			t.Errorf("%q: got %dx%d %v, want 3x2 %v", input, w, h, pbm.data, want.data)
+		}
+	}
+
+	pgm, err := DecodePGM(strings.NewReader("P2\r\n2 2\r\n15\r\n1\t2\r\n\r\n3 15\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Concat(pgm.data...); !slices.Equal(got, []uint8{1, 2, 3, 15}) {
+		t.Errorf("P2 with CRLF: got %v, want [1 2 3 15]", got)
+	}
+
+	ppm, err := DecodePPM(strings.NewReader("P3\r\n1 2\r\n255\r\n1 2\t3\r\n\r\n4\r\n5 6\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Concat(ppm.data...); !slices.Equal(got, []Pixel{{1, 2, 3}, {4, 5, 6}}) {
+		t.Errorf("P3 with CRLF: got %v, want [{1 2 3} {4 5 6}]", got)
+	}
+}
+
+func TestRotateAngleFillBool(t *testing.T) {
+	pbm := pbmFromRows("...", "...", "...")
+	pbm.RotateAngle(45, true)
+	if w, h := pbm.Size(); w != 5 || h != 5 {
+		t.Fatalf("got size %dx%d, want 5x5", w, h)
+	}
+	if !pbm.At(0, 0) || pbm.At(2, 2) {
+		t.Errorf("got corner %v and center %v, want the fill in the corner only", pbm.At(0, 0), pbm.At(2, 2))
+	}
+}
+
+func TestPBMCrop(t *testing.T) {
+	pbm := pbmFromRows("#..#", ".##.", "#..#")
+	got, err := pbm.Crop(1, 1, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := pbmFromRows("##.", "..#"); !slices.EqualFunc(got.data, want.data, slices.Equal) || got.magicNumber != pbm.magicNumber {
+		t.Errorf("got %s %v, want %s %v", got.magicNumber, got.data, pbm.magicNumber, want.data)
+	}
+	if _, err := pbm.Crop(0, 0, 4, 4); err == nil {
+		t.Error("a rectangle out of bounds was accepted")
+	}
+}
+
+func TestReadPBMOptsBitPacking(t *testing.T) {
+	want := pbmFromRows("#.#", ".#.", "##.")
+	header := "P4\n3 3\n"
+	// Row-aligned: 101 00000, 010 00000, 110 00000.
+	aligned := writeFile(t, append([]byte(header), 0xA0, 0x40, 0xC0))
+	// Continuous: 10101011, 0 0000000.
+	continuous := writeFile(t, append([]byte(header), 0xAB, 0x00))
+
+	tests := []struct {
+		name     string
+		filename string
+		packing  PBMBitPacking
+	}{
+		{"row-aligned", aligned, RowAligned},
+		{"continuous", continuous, Continuous},
+	}
+	for _, tt := range tests {
+		pbm, err := ReadPBMOpts(tt.filename, PBMOptions{BitPacking: tt.packing})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.EqualFunc(pbm.data, want.data, slices.Equal) {
+			t.Errorf("%s: got %v, want %v", tt.name, pbm.data, want.data)
+		}
+	}
+
+	// The default reader expects one byte per row and runs out of data.
+	if _, err := ReadPBM(continuous); err == nil {
+		t.Error("ReadPBM accepted continuous data as row-aligned")
+	}
+	// Continuous packing of row-aligned data shifts the rows.
+	pbm, err := ReadPBMOpts(aligned, PBMOptions{BitPacking: Continuous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.EqualFunc(pbm.data, want.data, slices.Equal) {
+		t.Error("continuous packing decoded row-aligned data unchanged")
+	}
+}
@@ -0,0 +1,283 @@
+package Netpbm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// PFM represents a structure to hold Portable FloatMap (HDR) image data and attributes.
+type PFM struct {
+	data          [][]float32 // Samples from top to bottom, RGB interleaved for PF.
+	width, height int         // Width and height of the image.
+	magicNumber   string      // Magic number indicating PFM format (PF for color, Pf for grayscale).
+	scale         float32     // Absolute value of the scale factor from the header.
+	littleEndian  bool        // Byte order of the samples, given by the sign of the scale factor.
+}
+
+// ReadPFM reads a PFM file and returns a PFM struct and an error if any.
+func ReadPFM(filename string) (*PFM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	// Read and validate the magic number.
+	magicNumber, err := readToken(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading magic number: %v", err)
+	}
+	if magicNumber != "PF" && magicNumber != "Pf" {
+		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
+	}
+
+	// Read and parse image dimensions.
+	width, err := readInt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	height, err := readInt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width and height must be positive")
+	}
+
+	// Read the scale factor, whose sign gives the byte order.
+	scaleValue, err := readToken(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading scale: %v", err)
+	}
+	scale, err := strconv.ParseFloat(scaleValue, 32)
+	if err != nil || scale == 0 {
+		return nil, fmt.Errorf("invalid scale: %s", scaleValue)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	// Rows are stored from bottom to top.
+	channels := pfmChannels(magicNumber)
+	data := make([][]float32, height)
+	row := make([]byte, width*channels*4)
+	for y := height - 1; y >= 0; y-- {
+		_, err := io.ReadFull(reader, row)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
+		}
+		data[y] = make([]float32, width*channels)
+		for i := range data[y] {
+			data[y][i] = math.Float32frombits(order.Uint32(row[i*4:]))
+		}
+	}
+
+	return &PFM{data, width, height, magicNumber, float32(math.Abs(scale)), scale < 0}, nil
+}
+
+// pfmChannels returns the number of samples per pixel for a PFM magic number.
+func pfmChannels(magicNumber string) int {
+	if magicNumber == "PF" {
+		return 3
+	}
+	return 1
+}
+
+// Size returns the width and height of the PFM image.
+func (pfm *PFM) Size() (int, int) {
+	return pfm.width, pfm.height
+}
+
+// At returns the RGB samples of the pixel at the given coordinates.
+// For grayscale (Pf) images the three samples are equal.
+func (pfm *PFM) At(x, y int) [3]float32 {
+	if pfm.magicNumber == "PF" {
+		return [3]float32{pfm.data[y][x*3], pfm.data[y][x*3+1], pfm.data[y][x*3+2]}
+	}
+	v := pfm.data[y][x]
+	return [3]float32{v, v, v}
+}
+
+// Set sets the RGB samples of the pixel at the given coordinates.
+// For grayscale (Pf) images only the first sample is used.
+func (pfm *PFM) Set(x, y int, value [3]float32) {
+	if pfm.magicNumber == "PF" {
+		copy(pfm.data[y][x*3:x*3+3], value[:])
+		return
+	}
+	pfm.data[y][x] = value[0]
+}
+
+// Save writes the PFM image to a file, keeping the byte order it was read with.
+func (pfm *PFM) Save(filename string) error {
+	if pfm.magicNumber != "PF" && pfm.magicNumber != "Pf" {
+		return fmt.Errorf("unsupported magic number: %s", pfm.magicNumber)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	scale := pfm.scale
+	if scale == 0 {
+		scale = 1
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if pfm.littleEndian {
+		scale = -scale
+		order = binary.LittleEndian
+	}
+	_, err = fmt.Fprintf(writer, "%s\n%d %d\n%s\n", pfm.magicNumber, pfm.width, pfm.height, strconv.FormatFloat(float64(scale), 'f', -1, 32))
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+
+	// Rows are stored from bottom to top.
+	row := make([]byte, pfm.width*pfmChannels(pfm.magicNumber)*4)
+	for y := pfm.height - 1; y >= 0; y-- {
+		for i, v := range pfm.data[y] {
+			order.PutUint32(row[i*4:], math.Float32bits(v))
+		}
+		_, err := writer.Write(row)
+		if err != nil {
+			return fmt.Errorf("error writing pixel data at row %d: %v", y, err)
+		}
+	}
+
+	return writer.Flush()
+}
+
+// ToneMapping selects how ToPPM brings high dynamic range samples into the
+// integer range.
+type ToneMapping int
+
+const (
+	Clip     ToneMapping = iota // Clamp samples to [0, 1] and scale them linearly, clipping HDR values above 1 to white.
+	Reinhard                    // Compress the luminance like ReinhardToneMapColor, with its default key.
+)
+
+// ToPPM converts the PFM image to a P6 PPM image with the given max value.
+// By default samples are clamped to [0, 1] and scaled linearly, so HDR values
+// above 1 are clipped to white; pass Reinhard to tone map them instead.
+func (pfm *PFM) ToPPM(maxval uint8, mapping ...ToneMapping) *PPM {
+	if len(mapping) > 0 && mapping[0] == Reinhard {
+		return pfm.reinhardToneMapColor(maxval, nil)
+	}
+
+	ppm := &PPM{
+		data:        make([][]Pixel, pfm.height),
+		width:       pfm.width,
+		height:      pfm.height,
+		magicNumber: "P6",
+		max:         maxval,
+	}
+	for y := 0; y < pfm.height; y++ {
+		ppm.data[y] = make([]Pixel, pfm.width)
+		for x := 0; x < pfm.width; x++ {
+			rgb := pfm.At(x, y)
+			ppm.data[y][x] = Pixel{
+				R: clampSample(float64(rgb[0])*float64(maxval), maxval),
+				G: clampSample(float64(rgb[1])*float64(maxval), maxval),
+				B: clampSample(float64(rgb[2])*float64(maxval), maxval),
+			}
+		}
+	}
+	return ppm
+}
+
+// ReinhardToneMap maps the high dynamic range luminance of the image to an
+// 8-bit P5 PGM image with the Reinhard operator L/(1+L). The luminance is
+// first scaled so that its log-average equals the key, 0.18 by default,
+// which acts as the exposure: higher keys give brighter results.
+func (pfm *PFM) ReinhardToneMap(key ...float64) *PGM {
+	scaled := pfm.reinhardScale(key)
+	pgm := &PGM{
+		data:        make([][]uint8, pfm.height),
+		width:       pfm.width,
+		height:      pfm.height,
+		magicNumber: "P5",
+		max:         255,
+	}
+	for y := 0; y < pfm.height; y++ {
+		pgm.data[y] = make([]uint8, pfm.width)
+		for x := 0; x < pfm.width; x++ {
+			l := scaled * pfm.luminance(x, y)
+			pgm.data[y][x] = clampSample(255*l/(1+l), 255)
+		}
+	}
+	return pgm
+}
+
+// ReinhardToneMapColor is the color variant of ReinhardToneMap, returning an
+// 8-bit P6 PPM image. Each pixel keeps its hue: its channels are scaled by the
+// ratio between the tone-mapped and the original luminance.
+func (pfm *PFM) ReinhardToneMapColor(key ...float64) *PPM {
+	return pfm.reinhardToneMapColor(255, key)
+}
+
+// reinhardToneMapColor implements ReinhardToneMapColor, with the given max value.
+func (pfm *PFM) reinhardToneMapColor(maxval uint8, key []float64) *PPM {
+	scaled := pfm.reinhardScale(key)
+	ppm := &PPM{
+		data:        make([][]Pixel, pfm.height),
+		width:       pfm.width,
+		height:      pfm.height,
+		magicNumber: "P6",
+		max:         maxval,
+	}
+	for y := 0; y < pfm.height; y++ {
+		ppm.data[y] = make([]Pixel, pfm.width)
+		for x := 0; x < pfm.width; x++ {
+			l := scaled * pfm.luminance(x, y)
+			ratio := 0.0
+			if l > 0 {
+				ratio = scaled / (1 + l)
+			}
+			rgb := pfm.At(x, y)
+			ppm.data[y][x] = Pixel{
+				R: clampSample(float64(maxval)*ratio*float64(rgb[0]), maxval),
+				G: clampSample(float64(maxval)*ratio*float64(rgb[1]), maxval),
+				B: clampSample(float64(maxval)*ratio*float64(rgb[2]), maxval),
+			}
+		}
+	}
+	return ppm
+}
+
+// reinhardScale returns the factor mapping the log-average luminance of the
+// image to the key, which defaults to 0.18 when not given.
+func (pfm *PFM) reinhardScale(key []float64) float64 {
+	const delta = 1e-6
+
+	k := 0.18
+	if len(key) > 0 && key[0] > 0 {
+		k = key[0]
+	}
+
+	sum := 0.0
+	for y := 0; y < pfm.height; y++ {
+		for x := 0; x < pfm.width; x++ {
+			sum += math.Log(delta + pfm.luminance(x, y))
+		}
+	}
+	logAverage := math.Exp(sum / float64(pfm.width*pfm.height))
+	return k / logAverage
+}
+
+// luminance returns the non-negative luminance of the pixel at the given coordinates.
+func (pfm *PFM) luminance(x, y int) float64 {
+	rgb := pfm.At(x, y)
+	l := 0.2126*float64(rgb[0]) + 0.7152*float64(rgb[1]) + 0.0722*float64(rgb[2])
+	return math.Max(l, 0)
+}
@@ -0,0 +1,78 @@
+package Netpbm
+
+import "testing"
+
+// hdrPFM returns a PF image with one row of gray pixels of the given values.
+func hdrPFM(values ...float32) *PFM {
+	pfm := &PFM{data: [][]float32{make([]float32, 3*len(values))}, width: len(values), height: 1, magicNumber: "PF", scale: 1}
+	for x, v := range values {
+		pfm.Set(x, 0, [3]float32{v, v, v})
+	}
+	return pfm
+}
+
+func TestPFMToPPMToneMapping(t *testing.T) {
+	pfm := hdrPFM(2, 20, 200, 2000)
+
+	clipped := pfm.ToPPM(100)
+	if n := clipped.Count(func(p Pixel) bool { return p == Pixel{100, 100, 100} }); n != 4 {
+		t.Errorf("Clip: got %d white pixels, want all 4 clipped to white", n)
+	}
+	if got := pfm.ToPPM(100, Clip); !got.Equal(clipped) {
+		t.Errorf("explicit Clip: got %v, want %v", got.data, clipped.data)
+	}
+
+	mapped := pfm.ToPPM(100, Reinhard)
+	if max := mapped.max; max != 100 {
+		t.Errorf("Reinhard: got max value %d, want 100", max)
+	}
+	prev := -1
+	for x := 0; x < 4; x++ {
+		v := int(mapped.At(x, 0).G)
+		if v <= prev || v >= 100 {
+			t.Errorf("Reinhard: got %v, want values increasing strictly below 100", mapped.data[0])
+			break
+		}
+		prev = v
+	}
+}
+
+func TestReinhardToneMapExtremeValues(t *testing.T) {
+	pfm := hdrPFM(0, 1e-2, 1, 1e2, 1e4, 1e6)
+
+	pgm := pfm.ReinhardToneMap()
+	white := 0
+	for x := 0; x < 6; x++ {
+		v := pgm.At(x, 0)
+		if x > 0 && v < pgm.At(x-1, 0) {
+			t.Errorf("gray: got %v, want non-decreasing values", pgm.data[0])
+		}
+		if v == 255 {
+			white++
+		}
+	}
+	if pgm.At(0, 0) != 0 {
+		t.Errorf("gray: black mapped to %d, want 0", pgm.At(0, 0))
+	}
+	if white > 2 {
+		t.Errorf("gray: got %d white pixels of 6, want the mid tones kept", white)
+	}
+	if v := pgm.At(2, 0); v == 0 || v == 255 {
+		t.Errorf("gray: luminance 1 mapped to %d, want a mid tone", v)
+	}
+
+	// A higher key gives a brighter result.
+	if bright := pfm.ReinhardToneMap(0.5); bright.At(2, 0) <= pgm.At(2, 0) {
+		t.Errorf("key 0.5: got %d, want brighter than %d with the default key", bright.At(2, 0), pgm.At(2, 0))
+	}
+
+	ppm := pfm.ReinhardToneMapColor()
+	for x := 0; x < 6; x++ {
+		if p := ppm.At(x, 0); p.R != p.G || p.G != p.B {
+			t.Errorf("color: gray input gave %v", p)
+		}
+	}
+	if ppm.At(2, 0).R == 255 {
+		t.Error("color: luminance 1 clipped to white")
+	}
+}
@@ -2,10 +2,20 @@ package Netpbm
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"iter"
+	"math"
+	"math/bits"
 	"os"
-	"strings"
+	"slices"
+	"sort"
+	"strconv"
 )
 
 // PGM represents a structure to hold PGM image data and attributes.
@@ -14,54 +24,42 @@ type PGM struct {
 	width, height int       // Width and height of the image.
 	magicNumber   string    // Magic number indicating PGM format (P2 for ASCII, P5 for Binary).
 	max           uint8     // Maximum grayscale value.
+	raw           []byte    // Original file bytes kept by ReadPGMRaw, nil otherwise.
 }
 
 // ReadPGM reads a PGM file and returns a PGM struct and an error if any.
+// Samples of files with a max value above 255 are scaled down to 8 bits, which
+// loses precision; use ReadPGM16 to keep them.
 func ReadPGM(filename string) (*PGM, error) {
-	// Open the file for reading.
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	return DecodePGM(file)
+}
 
-	// Read and validate the magic number.
-	magicNumber, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading magic number: %v", err)
-	}
-	magicNumber = strings.TrimSpace(magicNumber)
-	if magicNumber != "P2" && magicNumber != "P5" {
-		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
-	}
+// DecodePGM reads a PGM image from r, such as an HTTP body or an in-memory
+// buffer, and returns a PGM struct and an error if any. Like ReadPGM, it
+// scales samples above 8 bits down to 8 bits; use DecodePGM16 to keep them.
+func DecodePGM(r io.Reader) (*PGM, error) {
+	reader := bufio.NewReader(r)
 
-	// Read and parse image dimensions.
-	dimensions, err := reader.ReadString('\n')
+	magicNumber, width, height, maxValue, err := readPGMHeader(reader)
 	if err != nil {
-		return nil, fmt.Errorf("error reading dimensions: %v", err)
-	}
-	var width, height int
-	_, err = fmt.Sscanf(strings.TrimSpace(dimensions), "%d %d", &width, &height)
-	if err != nil {
-		return nil, fmt.Errorf("invalid dimensions: %v", err)
-	}
-	if width <= 0 || height <= 0 {
-		return nil, fmt.Errorf("invalid dimensions: width and height must be positive")
+		return nil, err
 	}
 
-	// Read and validate max grayscale value.
-	maxValue, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading max value: %v", err)
-	}
-	maxValue = strings.TrimSpace(maxValue)
-	var max uint8
-	_, err = fmt.Sscanf(maxValue, "%d", &max)
-	if err != nil {
-		return nil, fmt.Errorf("invalid max value: %v", err)
+	// Samples wider than 8 bits are read at full depth, then scaled down.
+	if maxValue > 255 {
+		pgm16, err := readPGM16Data(reader, magicNumber, width, height, uint16(maxValue), BigEndian)
+		if err != nil {
+			return nil, err
+		}
+		return pgm16.ToPGM(), nil
 	}
+	max := uint8(maxValue)
 
 	// Read and store image data based on PGM format.
 	data := make([][]uint8, height)
@@ -70,18 +68,9 @@ func ReadPGM(filename string) (*PGM, error) {
 	// Handle P2 format (ASCII).
 	if magicNumber == "P2" {
 		for y := 0; y < height; y++ {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				return nil, fmt.Errorf("error reading data at row %d: %v", y, err)
-			}
-			fields := strings.Fields(line)
 			rowData := make([]uint8, width)
-			for x, field := range fields {
-				if x >= width {
-					return nil, fmt.Errorf("index out of range at row %d", y)
-				}
-				var pixelValue uint8
-				_, err := fmt.Sscanf(field, "%d", &pixelValue)
+			for x := range rowData {
+				pixelValue, err := readSample(reader, max)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing pixel value at row %d, column %d: %v", y, x, err)
 				}
@@ -93,16 +82,16 @@ func ReadPGM(filename string) (*PGM, error) {
 		// Handle P5 format (binary).
 		for y := 0; y < height; y++ {
 			row := make([]byte, width*expectedBytesPerPixel)
-			n, err := reader.Read(row)
+			n, err := io.ReadFull(reader, row)
 			if err != nil {
 				if err == io.EOF {
 					return nil, fmt.Errorf("unexpected end of file at row %d", y)
 				}
+				if err == io.ErrUnexpectedEOF {
+					return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, width*expectedBytesPerPixel, n)
+				}
 				return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
 			}
-			if n < width*expectedBytesPerPixel {
-				return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, width*expectedBytesPerPixel, n)
-			}
 
 			rowData := make([]uint8, width)
 			for x := 0; x < width; x++ {
@@ -114,7 +103,156 @@ func ReadPGM(filename string) (*PGM, error) {
 	}
 
 	// Construct and return the PGM struct.
-	return &PGM{data, width, height, magicNumber, max}, nil
+	return &PGM{data: data, width: width, height: height, magicNumber: magicNumber, max: max}, nil
+}
+
+// ReadPGMNormalized reads a PGM file like ReadPGM, then rescales its samples
+// to a max value of 255, rounding to the nearest value, so that callers always
+// get 8-bit data whatever the max value of the file. 16-bit files are scaled
+// down directly from their full precision.
+func ReadPGMNormalized(filename string) (*PGM, error) {
+	pgm, err := ReadPGM(filename)
+	if err != nil {
+		return nil, err
+	}
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			pgm.data[y][x] = scaleTo255(pgm.data[y][x], pgm.max)
+		}
+	}
+	pgm.max = 255
+	return pgm, nil
+}
+
+// ReadPGMRaw reads a PGM file like ReadPGM, but also keeps the original bytes
+// of the file, so that SaveRaw can write them back verbatim as long as the
+// image is not modified. This gives a byte-identical round trip, even for
+// 16-bit files or formatting that Save would not reproduce.
+func ReadPGMRaw(filename string) (*PGM, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pgm, err := DecodePGM(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	pgm.raw = raw
+	return pgm, nil
+}
+
+// readPGMHeader reads and validates the magic number, dimensions and max value
+// of a PGM image, leaving the reader on the first pixel. The max value may be
+// up to 65535, for 16-bit images.
+func readPGMHeader(reader *bufio.Reader) (magicNumber string, width, height, max int, err error) {
+	// Read and validate the magic number.
+	magicNumber, err = readMagic(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("error reading magic number: %v", err)
+	}
+	if magicNumber != "P2" && magicNumber != "P5" {
+		return "", 0, 0, 0, fmt.Errorf("invalid magic number: %s", magicNumber)
+	}
+
+	// Read and parse image dimensions.
+	width, err = readInt(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	height, err = readInt(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	if width <= 0 || height <= 0 {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: width and height must be positive")
+	}
+
+	// Read and validate max grayscale value.
+	max, err = readMaxValue(reader)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+
+	return magicNumber, width, height, max, nil
+}
+
+// ReadPGMRegion reads only the w x h rectangle at (x, y) of a PGM file. For
+// binary P5 files the header is parsed and only the bytes of the requested
+// rows are read, seeking over the rest, so crops can be taken from huge scans.
+// ASCII P2 files have no fixed layout and are fully decoded before cropping.
+func ReadPGMRegion(filename string, x, y, w, h int) (*PGM, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magicNumber, width, height, max, err := readPGMHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if w <= 0 || h <= 0 || x < 0 || y < 0 || x+w > width || y+h > height {
+		return nil, fmt.Errorf("region %dx%d at (%d, %d) does not fit in a %dx%d image", w, h, x, y, width, height)
+	}
+
+	region := &PGM{
+		data:        make([][]uint8, h),
+		width:       w,
+		height:      h,
+		magicNumber: magicNumber,
+		max:         uint8(min(max, 255)),
+	}
+
+	// 16-bit samples are scaled down by ReadPGM, so they take the slow path too.
+	if magicNumber == "P2" || max > 255 {
+		pgm, err := ReadPGM(filename)
+		if err != nil {
+			return nil, err
+		}
+		region.max = pgm.max
+		for row := range region.data {
+			region.data[row] = make([]uint8, w)
+			copy(region.data[row], pgm.data[y+row][x:x+w])
+		}
+		return region, nil
+	}
+
+	// The buffered reader has read past the header, so locate the pixel data
+	// from the file position minus what is still buffered.
+	position, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error locating pixel data: %v", err)
+	}
+	dataStart := position - int64(reader.Buffered())
+	for row := range region.data {
+		region.data[row] = make([]uint8, w)
+		offset := dataStart + int64(y+row)*int64(width) + int64(x)
+		_, err := file.ReadAt(region.data[row], offset)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pixel data at row %d: %v", y+row, err)
+		}
+	}
+	return region, nil
+}
+
+// NewPGM creates a black P5 image of the given size and max value.
+// It returns an error if the width or height is not positive.
+func NewPGM(width, height int, max uint8) (*PGM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", width, height)
+	}
+	pgm := &PGM{
+		data:        make([][]uint8, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P5",
+		max:         max,
+	}
+	for i := range pgm.data {
+		pgm.data[i] = make([]uint8, width)
+	}
+	return pgm, nil
 }
 
 // Size returns the width and height of the PGM image.
@@ -145,8 +283,14 @@ func (pgm *PGM) Save(filename string) error {
 	}
 	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	_, err = fmt.Fprintln(writer, pgm.magicNumber)
+	return pgm.Encode(file)
+}
+
+// Encode writes the PGM image to w in the format given by its magic number (P2
+// or P5), so it can be streamed without touching disk.
+func (pgm *PGM) Encode(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	_, err := fmt.Fprintln(writer, pgm.magicNumber)
 	if err != nil {
 		return fmt.Errorf("error writing magic number: %v", err)
 	}
@@ -184,6 +328,22 @@ func (pgm *PGM) Save(filename string) error {
 	return writer.Flush()
 }
 
+// SaveRaw writes the original bytes kept by ReadPGMRaw to a file when the
+// image is unchanged since it was read, which is checked by decoding them
+// again and comparing the result with the image exactly. Once the magic
+// number, size, max value or any pixel differs, and for images not read with
+// ReadPGMRaw, it encodes the image with Save.
+func (pgm *PGM) SaveRaw(filename string) error {
+	if pgm.raw == nil {
+		return pgm.Save(filename)
+	}
+	original, err := DecodePGM(bytes.NewReader(pgm.raw))
+	if err != nil || !pgm.Equal(original) {
+		return pgm.Save(filename)
+	}
+	return os.WriteFile(filename, pgm.raw, 0644)
+}
+
 // saveP2PGM saves the image in P2 format (ASCII) to the provided writer.
 func saveP2PGM(file *bufio.Writer, pgm *PGM) error {
 	for y := 0; y < pgm.height; y++ {
@@ -281,6 +441,33 @@ func (pgm *PGM) Rotate90CW() {
 	pgm.width, pgm.height = pgm.height, pgm.width
 }
 
+// Rotate90CCW rotates the image 90 degrees counter-clockwise.
+func (pgm *PGM) Rotate90CCW() {
+	newData := make([][]uint8, pgm.width)
+	for x := range newData {
+		newData[x] = make([]uint8, pgm.height)
+		for y := 0; y < pgm.height; y++ {
+			newData[x][y] = pgm.data[y][pgm.width-x-1]
+		}
+	}
+	pgm.data = newData
+	pgm.width, pgm.height = pgm.height, pgm.width
+}
+
+// Rotate180 rotates the image by 180 degrees, reversing both the row and the column order.
+func (pgm *PGM) Rotate180() {
+	pgm.Flip()
+	pgm.Flop()
+}
+
+// Transpose mirrors the image along its main diagonal, swapping rows and
+// columns, so that the pixel at (x, y) moves to (y, x).
+func (pgm *PGM) Transpose() {
+	transposed := &PGM{}
+	pgm.TransposeInto(transposed)
+	pgm.data, pgm.width, pgm.height = transposed.data, transposed.width, transposed.height
+}
+
 // ToPBM converts the PGM image to a PBM (Portable Bitmap) image.
 func (pgm *PGM) ToPBM() *PBM {
 	pbm := &PBM{
@@ -297,3 +484,1187 @@ func (pgm *PGM) ToPBM() *PBM {
 	}
 	return pbm
 }
+
+// pgmGob mirrors PGM with exported fields so it can be handled by encoding/gob.
+type pgmGob struct {
+	Data          [][]uint8
+	Width, Height int
+	MagicNumber   string
+	Max           uint8
+}
+
+// GobEncode implements gob.GobEncoder, including the unexported fields of the PGM.
+func (pgm *PGM) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(pgmGob{pgm.data, pgm.width, pgm.height, pgm.magicNumber, pgm.max})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PGM: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, restoring a PGM written by GobEncode.
+func (pgm *PGM) GobDecode(b []byte) error {
+	var g pgmGob
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g)
+	if err != nil {
+		return fmt.Errorf("error decoding PGM: %v", err)
+	}
+	*pgm = PGM{data: g.Data, width: g.Width, height: g.Height, magicNumber: g.MagicNumber, max: g.Max}
+	return nil
+}
+
+// MarshalJSON encodes the PGM metadata (format, width, height and max) as JSON.
+// Pixel data is not included.
+func (pgm *PGM) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metadata{pgm.magicNumber, pgm.width, pgm.height, int(pgm.max)})
+}
+
+// UnmarshalJSON restores the PGM metadata written by MarshalJSON.
+// Since pixel data is not part of the JSON, the image is left blank. Images
+// of more than 1<<26 pixels are rejected rather than allocated.
+func (pgm *PGM) UnmarshalJSON(b []byte) error {
+	var m metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if err := m.checkSize(); err != nil {
+		return err
+	}
+	if m.Max < 0 || m.Max > 255 {
+		return fmt.Errorf("invalid max value: %d", m.Max)
+	}
+	data := make([][]uint8, m.Height)
+	for i := range data {
+		data[i] = make([]uint8, m.Width)
+	}
+	*pgm = PGM{data: data, width: m.Width, height: m.Height, magicNumber: m.Format, max: uint8(m.Max)}
+	return nil
+}
+
+// SaveBinary writes the PGM image to a file in binary format (P5),
+// regardless of its current magic number. The image itself is not modified.
+func (pgm *PGM) SaveBinary(filename string) error {
+	if pgm == nil {
+		return errors.New("cannot save a nil PGM")
+	}
+	binary := *pgm
+	binary.magicNumber = "P5"
+	return binary.Save(filename)
+}
+
+// SaveASCII writes the PGM image to a file in ASCII format (P2),
+// regardless of its current magic number. The image itself is not modified.
+func (pgm *PGM) SaveASCII(filename string) error {
+	if pgm == nil {
+		return errors.New("cannot save a nil PGM")
+	}
+	ascii := *pgm
+	ascii.magicNumber = "P2"
+	return ascii.Save(filename)
+}
+
+// Duotone maps the gray values of the PGM image onto a linear gradient between
+// the shadow color (for black) and the highlight color (for white), returning a PPM image.
+func (pgm *PGM) Duotone(shadow, highlight Pixel) *PPM {
+	ppm := &PPM{
+		data:        make([][]Pixel, pgm.height),
+		width:       pgm.width,
+		height:      pgm.height,
+		magicNumber: "P3",
+		max:         255,
+	}
+	for y := 0; y < pgm.height; y++ {
+		ppm.data[y] = make([]Pixel, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			t := 0.0
+			if pgm.max > 0 {
+				t = float64(pgm.data[y][x]) / float64(pgm.max)
+			}
+			ppm.data[y][x] = Pixel{
+				R: lerp(shadow.R, highlight.R, t),
+				G: lerp(shadow.G, highlight.G, t),
+				B: lerp(shadow.B, highlight.B, t),
+			}
+		}
+	}
+	return ppm
+}
+
+// lerp linearly interpolates between a and b, t being in the range [0, 1].
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+}
+
+// Deskew estimates the skew angle of a scanned document, rotates the image to
+// straighten it and returns the detected angle in degrees. A positive angle
+// means the text lines were sloping down to the right.
+//
+// Dark pixels (below half the max value) are projected onto rows for every
+// candidate angle between -15 and 15 degrees, and the angle whose projection
+// profile has the highest variance is kept. Pixels exposed by the rotation
+// are filled with white.
+func (pgm *PGM) Deskew() float64 {
+	const maxAngle, step = 15.0, 0.25
+
+	var ink []Point
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if pgm.data[y][x] < pgm.max/2 {
+				ink = append(ink, Point{x, y})
+			}
+		}
+	}
+	if len(ink) == 0 {
+		return 0
+	}
+
+	// The projected row of a pixel may go beyond the image by up to its width.
+	profile := make([]int, pgm.height+2*pgm.width+2)
+	bestAngle, bestScore := 0.0, -1
+	for angle := -maxAngle; angle <= maxAngle; angle += step {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		for i := range profile {
+			profile[i] = 0
+		}
+		for _, p := range ink {
+			row := int(math.Round(float64(p.Y)*cos-float64(p.X)*sin)) + pgm.width + 1
+			profile[row]++
+		}
+
+		// The total is constant, so the sum of squares orders the angles like the variance.
+		score := 0
+		for _, count := range profile {
+			score += count * count
+		}
+		if score > bestScore || (score == bestScore && math.Abs(angle) < math.Abs(bestAngle)) {
+			bestAngle, bestScore = angle, score
+		}
+	}
+
+	if bestAngle != 0 {
+		pgm.rotateInPlace(bestAngle, pgm.max)
+	}
+	return bestAngle
+}
+
+// rotateInPlace rotates the content of the image around its center by the given
+// angle in degrees (positive is counter-clockwise on screen) without changing
+// its size, using nearest-neighbor sampling. Pixels whose source falls outside
+// the image are set to fill.
+func (pgm *PGM) rotateInPlace(degrees float64, fill uint8) {
+	pgm.data = rotateNearest(pgm.data, pgm.width, pgm.height, pgm.width, pgm.height, degrees, fill)
+}
+
+// Rotate90CWInto writes the image rotated 90 degrees clockwise into dst, which
+// must be a different PGM. The pixel rows of dst are reused when they are large
+// enough, so repeated rotations of same-sized images do not allocate.
+func (pgm *PGM) Rotate90CWInto(dst *PGM) error {
+	if err := pgm.prepareInto(dst, pgm.height, pgm.width); err != nil {
+		return err
+	}
+	for i := 0; i < pgm.width; i++ {
+		for j := 0; j < pgm.height; j++ {
+			dst.data[i][j] = pgm.data[pgm.height-j-1][i]
+		}
+	}
+	return nil
+}
+
+// Rotate90CCWInto is like Rotate90CWInto, but rotates counter-clockwise.
+func (pgm *PGM) Rotate90CCWInto(dst *PGM) error {
+	if err := pgm.prepareInto(dst, pgm.height, pgm.width); err != nil {
+		return err
+	}
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			dst.data[pgm.width-x-1][y] = pgm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// Rotate180Into is like Rotate90CWInto, but rotates by 180 degrees.
+func (pgm *PGM) Rotate180Into(dst *PGM) error {
+	if err := pgm.prepareInto(dst, pgm.width, pgm.height); err != nil {
+		return err
+	}
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			dst.data[pgm.height-y-1][pgm.width-x-1] = pgm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// TransposeInto is like Rotate90CWInto, but writes the transpose of the image,
+// mirrored along its main diagonal, into dst.
+func (pgm *PGM) TransposeInto(dst *PGM) error {
+	if err := pgm.prepareInto(dst, pgm.height, pgm.width); err != nil {
+		return err
+	}
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			dst.data[x][y] = pgm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// prepareInto checks that dst can receive a transform of the image, then
+// reshapes it to width x height and copies the header fields over.
+func (pgm *PGM) prepareInto(dst *PGM, width, height int) error {
+	if dst == nil {
+		return errors.New("destination PGM is nil")
+	}
+	if dst == pgm {
+		return errors.New("destination PGM must differ from the source")
+	}
+
+	dst.reshape(width, height)
+	dst.magicNumber = pgm.magicNumber
+	dst.max = pgm.max
+	return nil
+}
+
+// reshape sets the dimensions of the image, reusing the existing pixel rows
+// when their capacity allows it. Pixel values are left unspecified.
+func (pgm *PGM) reshape(width, height int) {
+	if cap(pgm.data) < height {
+		pgm.data = make([][]uint8, height)
+	}
+	pgm.data = pgm.data[:height]
+	for y := range pgm.data {
+		if cap(pgm.data[y]) < width {
+			pgm.data[y] = make([]uint8, width)
+		}
+		pgm.data[y] = pgm.data[y][:width]
+	}
+	pgm.width, pgm.height = width, height
+}
+
+// All returns an iterator over the coordinates and values of every pixel of the
+// PGM image in row-major order, for use as: for p, v := range pgm.All().
+func (pgm *PGM) All() iter.Seq2[Point, uint8] {
+	return func(yield func(Point, uint8) bool) {
+		for y := 0; y < pgm.height; y++ {
+			for x := 0; x < pgm.width; x++ {
+				if !yield(Point{x, y}, pgm.data[y][x]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Hash returns a 64-bit FNV-1a hash of the PGM header and pixel data. Images with
+// the same format, dimensions and pixels hash equally, which makes the hash
+// usable as a deduplication or cache key.
+func (pgm *PGM) Hash() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s %d %d %d\n", pgm.magicNumber, pgm.width, pgm.height, pgm.max)
+	for y := 0; y < pgm.height; y++ {
+		h.Write(pgm.data[y])
+	}
+	return h.Sum64()
+}
+
+// PerceptualHash computes a 64-bit perceptual hash (pHash) of the image. The
+// image is shrunk to 32x32, transformed with a DCT, and each of the 8x8
+// lowest-frequency coefficients sets a bit when it is above their median.
+// Visually similar images have hashes with a small HammingDistance.
+func (pgm *PGM) PerceptualHash() uint64 {
+	const size, low = 32, 8
+
+	if pgm.width <= 0 || pgm.height <= 0 {
+		return 0
+	}
+
+	// Shrink the image by averaging the source pixels covering each cell.
+	var small [size][size]float64
+	for j := 0; j < size; j++ {
+		y0, y1 := j*pgm.height/size, (j+1)*pgm.height/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for i := 0; i < size; i++ {
+			x0, x1 := i*pgm.width/size, (i+1)*pgm.width/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			sum := 0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += int(pgm.data[y][x])
+				}
+			}
+			small[j][i] = float64(sum) / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	// Only the low frequencies of the 2-D DCT are needed.
+	var cosines [low][size]float64
+	for u := 0; u < low; u++ {
+		for x := 0; x < size; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	coefficients := make([]float64, 0, low*low)
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += small[y][x] * cosines[u][x] * cosines[v][y]
+				}
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := append([]float64(nil), coefficients...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of bits that differ between two hashes,
+// such as those returned by PerceptualHash.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Resize resizes the image in place to newWidth x newHeight, keeping its
+// magic number and max value. NearestNeighbor copies the closest source pixel,
+// while Bilinear interpolates between the 2x2 closest ones, clamping at the
+// edges rather than wrapping around. Non-positive dimensions leave the image
+// unchanged.
+func (pgm *PGM) Resize(newWidth, newHeight int, interp Interpolation) {
+	pgm.ResizeWith(newWidth, newHeight, interp)
+}
+
+// ResizeWith resizes the image in place to newW x newH using the given
+// resampling filter. Non-positive dimensions leave the image unchanged.
+func (pgm *PGM) ResizeWith(newW, newH int, filter ResampleFilter) {
+	if newW <= 0 || newH <= 0 || pgm.width <= 0 || pgm.height <= 0 {
+		return
+	}
+
+	// Resample the rows horizontally first, then the columns vertically.
+	columns := resampleWeights(pgm.width, newW, filter)
+	rows := resampleWeights(pgm.height, newH, filter)
+
+	horizontal := make([][]float64, pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		horizontal[y] = make([]float64, newW)
+		for x, c := range columns {
+			sum := 0.0
+			for k, w := range c.weights {
+				sum += w * float64(pgm.data[y][c.start+k])
+			}
+			horizontal[y][x] = sum
+		}
+	}
+
+	newData := make([][]uint8, newH)
+	for y, c := range rows {
+		newData[y] = make([]uint8, newW)
+		for x := 0; x < newW; x++ {
+			sum := 0.0
+			for k, w := range c.weights {
+				sum += w * horizontal[c.start+k][x]
+			}
+			newData[y][x] = clampSample(sum, pgm.max)
+		}
+	}
+
+	pgm.data = newData
+	pgm.width, pgm.height = newW, newH
+}
+
+// MotionBlur simulates camera motion by averaging each pixel with its
+// neighbors along a line of the given length, oriented at angleDegrees
+// counter-clockwise from the horizontal. Pixels beyond the borders are
+// clamped to the edges. Lengths below 2 leave the image unchanged.
+func (pgm *PGM) MotionBlur(length int, angleDegrees float64) {
+	if length < 2 {
+		return
+	}
+
+	kernel := motionKernel(length, angleDegrees)
+	newData := make([][]uint8, pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		newData[y] = make([]uint8, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			sum := 0
+			for _, k := range kernel {
+				sx := min(max(x+k.X, 0), pgm.width-1)
+				sy := min(max(y+k.Y, 0), pgm.height-1)
+				sum += int(pgm.data[sy][sx])
+			}
+			newData[y][x] = uint8((sum + len(kernel)/2) / len(kernel))
+		}
+	}
+	pgm.data = newData
+}
+
+// Solarize inverts the pixels brighter than threshold, leaving the darker
+// ones unchanged, for the classic solarization look.
+func (pgm *PGM) Solarize(threshold uint8) {
+	for y := range pgm.data {
+		for x, v := range pgm.data[y] {
+			if v > threshold {
+				pgm.data[y][x] = pgm.max - min(v, pgm.max)
+			}
+		}
+	}
+}
+
+// ToNormalMap treats the gray values as a height field and returns its
+// tangent-space normal map, with the x, y and z components of each normal
+// encoded from [-1, 1] to [0, max] in the R, G and B channels. The slopes
+// come from Sobel gradients multiplied by strength; borders are clamped.
+// A flat heightmap gives the uniform color (128, 128, 255) for a max of 255.
+func (pgm *PGM) ToNormalMap(strength float64) *PPM {
+	ppm := &PPM{
+		data:        make([][]Pixel, pgm.height),
+		width:       pgm.width,
+		height:      pgm.height,
+		magicNumber: "P3",
+		max:         pgm.max,
+	}
+
+	scale := 1.0
+	if pgm.max > 0 {
+		scale = 1 / float64(pgm.max)
+	}
+	h := func(x, y int) float64 {
+		x = min(max(x, 0), pgm.width-1)
+		y = min(max(y, 0), pgm.height-1)
+		return float64(pgm.data[y][x]) * scale
+	}
+	encode := func(v float64) uint8 {
+		return clampSample((v+1)/2*float64(pgm.max), pgm.max)
+	}
+
+	for y := 0; y < pgm.height; y++ {
+		ppm.data[y] = make([]Pixel, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			gx := (h(x+1, y-1) + 2*h(x+1, y) + h(x+1, y+1)) - (h(x-1, y-1) + 2*h(x-1, y) + h(x-1, y+1))
+			gy := (h(x-1, y+1) + 2*h(x, y+1) + h(x+1, y+1)) - (h(x-1, y-1) + 2*h(x, y-1) + h(x+1, y-1))
+			nx, ny, nz := -gx*strength, -gy*strength, 1.0
+			length := math.Sqrt(nx*nx + ny*ny + nz*nz)
+			ppm.data[y][x] = Pixel{encode(nx / length), encode(ny / length), encode(nz / length)}
+		}
+	}
+	return ppm
+}
+
+// OCROptions tunes the steps of PrepareForOCR. Zero fields take their default.
+type OCROptions struct {
+	MedianRadius int // Radius of the median denoise window, 1 (3x3) by default.
+	WindowSize   int // Side of the adaptive threshold window, 15 by default.
+	Offset       int // How much darker than its local mean a pixel must be to be ink, 10 by default.
+	MinSpeckArea int // Ink components with fewer pixels are removed, 4 by default.
+}
+
+// PrepareForOCR turns a scanned document into a clean binary image for OCR.
+// The image is first scaled by targetDPIScale (1 keeps its size), then
+// denoised with a median filter, binarized with an adaptive threshold against
+// the local mean, and cleared of the small specks left by noise. Ink becomes
+// true in the returned P1 PBM. The PGM itself is not modified.
+func (pgm *PGM) PrepareForOCR(targetDPIScale float64, opts ...OCROptions) *PBM {
+	opt := OCROptions{MedianRadius: 1, WindowSize: 15, Offset: 10, MinSpeckArea: 4}
+	if len(opts) > 0 {
+		if opts[0].MedianRadius > 0 {
+			opt.MedianRadius = opts[0].MedianRadius
+		}
+		if opts[0].WindowSize > 0 {
+			opt.WindowSize = opts[0].WindowSize
+		}
+		if opts[0].Offset != 0 {
+			opt.Offset = opts[0].Offset
+		}
+		if opts[0].MinSpeckArea > 0 {
+			opt.MinSpeckArea = opts[0].MinSpeckArea
+		}
+	}
+
+	work := &PGM{data: pgm.data, width: pgm.width, height: pgm.height, magicNumber: pgm.magicNumber, max: pgm.max}
+	if targetDPIScale > 0 && targetDPIScale != 1 {
+		filter := Bilinear
+		if targetDPIScale < 1 {
+			filter = Area
+		}
+		// ResizeWith replaces the data slice, so the original pixels are left untouched.
+		work.ResizeWith(int(math.Round(float64(pgm.width)*targetDPIScale)), int(math.Round(float64(pgm.height)*targetDPIScale)), filter)
+	}
+	work.data = work.medianFiltered(opt.MedianRadius)
+
+	pbm := work.adaptiveThreshold(opt.WindowSize, opt.Offset)
+	for _, component := range pbm.components() {
+		if len(component) < opt.MinSpeckArea {
+			for _, p := range component {
+				pbm.data[p.Y][p.X] = false
+			}
+		}
+	}
+	return pbm
+}
+
+// medianFiltered returns a copy of the pixel data in which every pixel is the
+// median of its (2*radius+1)^2 neighborhood, borders being clamped.
+func (pgm *PGM) medianFiltered(radius int) [][]uint8 {
+	window := make([]uint8, 0, (2*radius+1)*(2*radius+1))
+	newData := make([][]uint8, pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		newData[y] = make([]uint8, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			window = window[:0]
+			for dy := -radius; dy <= radius; dy++ {
+				sy := min(max(y+dy, 0), pgm.height-1)
+				for dx := -radius; dx <= radius; dx++ {
+					sx := min(max(x+dx, 0), pgm.width-1)
+					window = append(window, pgm.data[sy][sx])
+				}
+			}
+			sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+			newData[y][x] = window[len(window)/2]
+		}
+	}
+	return newData
+}
+
+// adaptiveThreshold returns a PBM in which a pixel is true when it is darker
+// than the mean of the size x size window around it by more than offset.
+// The window means come from an integral image.
+func (pgm *PGM) adaptiveThreshold(size, offset int) *PBM {
+	integral := make([][]int, pgm.height+1)
+	integral[0] = make([]int, pgm.width+1)
+	for y := 0; y < pgm.height; y++ {
+		integral[y+1] = make([]int, pgm.width+1)
+		rowSum := 0
+		for x := 0; x < pgm.width; x++ {
+			rowSum += int(pgm.data[y][x])
+			integral[y+1][x+1] = integral[y][x+1] + rowSum
+		}
+	}
+
+	pbm := &PBM{data: make([][]bool, pgm.height), width: pgm.width, height: pgm.height, magicNumber: "P1"}
+	half := size / 2
+	for y := 0; y < pgm.height; y++ {
+		pbm.data[y] = make([]bool, pgm.width)
+		y0, y1 := max(y-half, 0), min(y+half+1, pgm.height)
+		for x := 0; x < pgm.width; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, pgm.width)
+			sum := integral[y1][x1] - integral[y0][x1] - integral[y1][x0] + integral[y0][x0]
+			mean := sum / ((x1 - x0) * (y1 - y0))
+			pbm.data[y][x] = int(pgm.data[y][x]) < mean-offset
+		}
+	}
+	return pbm
+}
+
+// ResizeToFill scales the image, keeping its aspect ratio, until it covers the
+// whole width x height box, then crops the overflow evenly on both sides so
+// the image ends up exactly width x height ("cover" behavior). Shrinking
+// uses area averaging and enlarging uses bilinear interpolation.
+// Non-positive dimensions leave the image unchanged.
+func (pgm *PGM) ResizeToFill(width, height int) {
+	if width <= 0 || height <= 0 || pgm.width <= 0 || pgm.height <= 0 {
+		return
+	}
+
+	scale := math.Max(float64(width)/float64(pgm.width), float64(height)/float64(pgm.height))
+	scaledW := max(int(math.Ceil(float64(pgm.width)*scale-1e-9)), width)
+	scaledH := max(int(math.Ceil(float64(pgm.height)*scale-1e-9)), height)
+	filter := Bilinear
+	if scale < 1 {
+		filter = Area
+	}
+	pgm.ResizeWith(scaledW, scaledH, filter)
+	pgm.crop((scaledW-width)/2, (scaledH-height)/2, width, height)
+}
+
+// crop keeps only the width x height rectangle at (x, y), which must lie
+// within the image, sharing the existing rows.
+func (pgm *PGM) crop(x, y, width, height int) {
+	pgm.data = pgm.data[y : y+height]
+	for i := range pgm.data {
+		pgm.data[i] = pgm.data[i][x : x+width]
+	}
+	pgm.width, pgm.height = width, height
+}
+
+// DistinctColors returns the number of different gray values in the image.
+func (pgm *PGM) DistinctColors() int {
+	var seen [256]bool
+	count := 0
+	for y := range pgm.data {
+		for _, v := range pgm.data[y] {
+			if !seen[v] {
+				seen[v] = true
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// DifferenceOfGaussians replaces the image with the difference between two
+// Gaussian blurs of it, the first with sigma1 and the second with the larger
+// sigma2, offset to mid-gray. Flat regions become mid-gray while edges and
+// details in the band between both scales deviate from it. Sigmas given in
+// the wrong order are swapped; non-positive or equal sigmas, which select no
+// band, leave the image unchanged.
+func (pgm *PGM) DifferenceOfGaussians(sigma1, sigma2 float64) {
+	if sigma1 > sigma2 {
+		sigma1, sigma2 = sigma2, sigma1
+	}
+	if !(sigma1 > 0 && sigma2 > sigma1) {
+		return
+	}
+
+	fine := pgm.gaussianBlurred(sigma1)
+	coarse := pgm.gaussianBlurred(sigma2)
+	mid := (float64(pgm.max) + 1) / 2
+	for y := range pgm.data {
+		for x := range pgm.data[y] {
+			pgm.data[y][x] = clampSample(mid+fine[y][x]-coarse[y][x], pgm.max)
+		}
+	}
+}
+
+// gaussianBlurred returns the image blurred by a Gaussian of the given sigma,
+// unrounded. The kernel spans 3 sigmas and borders are clamped.
+func (pgm *PGM) gaussianBlurred(sigma float64) [][]float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	// The Gaussian is separable: blur the rows, then the columns.
+	horizontal := make([][]float64, pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		horizontal[y] = make([]float64, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			v := 0.0
+			for i, w := range kernel {
+				sx := min(max(x+i-radius, 0), pgm.width-1)
+				v += w * float64(pgm.data[y][sx])
+			}
+			horizontal[y][x] = v
+		}
+	}
+	blurred := make([][]float64, pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		blurred[y] = make([]float64, pgm.width)
+		for x := 0; x < pgm.width; x++ {
+			v := 0.0
+			for i, w := range kernel {
+				sy := min(max(y+i-radius, 0), pgm.height-1)
+				v += w * horizontal[sy][x]
+			}
+			blurred[y][x] = v
+		}
+	}
+	return blurred
+}
+
+// MotionMask compares two frames of a video and returns a P1 PBM mask in which
+// the pixels whose absolute difference exceeds threshold are true. Both
+// frames must have the same dimensions. The mask can be cleaned from noise
+// with CleanMask.
+func MotionMask(prev, curr *PGM, threshold uint8) (*PBM, error) {
+	if prev == nil || curr == nil {
+		return nil, errors.New("frames must not be nil")
+	}
+	if prev.width != curr.width || prev.height != curr.height {
+		return nil, fmt.Errorf("frame sizes differ: %dx%d and %dx%d", prev.width, prev.height, curr.width, curr.height)
+	}
+
+	mask := &PBM{data: make([][]bool, curr.height), width: curr.width, height: curr.height, magicNumber: "P1"}
+	for y := range mask.data {
+		mask.data[y] = make([]bool, curr.width)
+		for x := range mask.data[y] {
+			mask.data[y][x] = abs(int(curr.data[y][x])-int(prev.data[y][x])) > int(threshold)
+		}
+	}
+	return mask, nil
+}
+
+// SaveASCIIWrapped writes the image to a file in P2 format with the pixel
+// values wrapped so that no line is longer than maxLineLen characters. A
+// maxLineLen of 0 writes all the values on a single line. A value is never
+// split, so a maxLineLen shorter than a value gives one value per line.
+func (pgm *PGM) SaveASCIIWrapped(filename string, maxLineLen int) error {
+	if maxLineLen < 0 {
+		return fmt.Errorf("invalid max line length: %d", maxLineLen)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	_, err = fmt.Fprintf(writer, "P2\n%d %d\n%d\n", pgm.width, pgm.height, pgm.max)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+
+	tw := &tokenWriter{writer: writer, maxLineLen: maxLineLen}
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if err := tw.write(strconv.Itoa(int(pgm.data[y][x]))); err != nil {
+				return fmt.Errorf("error writing pixel data at row %d, column %d: %v", y, x, err)
+			}
+		}
+	}
+	if err := tw.end(); err != nil {
+		return fmt.Errorf("error writing pixel data: %v", err)
+	}
+
+	return writer.Flush()
+}
+
+// Count returns the number of pixels for which pred returns true, e.g. the
+// number of pixels brighter than a threshold.
+func (pgm *PGM) Count(pred func(uint8) bool) int {
+	count := 0
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if pred(pgm.data[y][x]) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// AtBilinearGray samples the image at fractional coordinates by interpolating
+// between the four closest pixels; integer coordinates return the stored value
+// exactly. Coordinates outside the image are clamped to the nearest edge, so
+// the border pixels extend indefinitely. An empty image samples as 0.
+func (pgm *PGM) AtBilinearGray(x, y float64) uint8 {
+	if pgm.width <= 0 || pgm.height <= 0 {
+		return 0
+	}
+
+	x = math.Max(0, math.Min(x, float64(pgm.width-1)))
+	y = math.Max(0, math.Min(y, float64(pgm.height-1)))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, pgm.width-1), min(y0+1, pgm.height-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	top := float64(pgm.data[y0][x0]) + (float64(pgm.data[y0][x1])-float64(pgm.data[y0][x0]))*fx
+	bottom := float64(pgm.data[y1][x0]) + (float64(pgm.data[y1][x1])-float64(pgm.data[y1][x0]))*fx
+	return clampSample(top+(bottom-top)*fy, 255)
+}
+
+// Decimate keeps every factorX-th column and every factorY-th row, starting
+// with the first ones, without any averaging. This is a fast, crude downscale
+// for previews or point sampling of sparse data. Factors below 1 leave the
+// image unchanged.
+func (pgm *PGM) Decimate(factorX, factorY int) {
+	if factorX < 1 || factorY < 1 {
+		return
+	}
+
+	width := (pgm.width + factorX - 1) / factorX
+	height := (pgm.height + factorY - 1) / factorY
+	data := make([][]uint8, height)
+	for y := range data {
+		data[y] = make([]uint8, width)
+		for x := range data[y] {
+			data[y][x] = pgm.data[y*factorY][x*factorX]
+		}
+	}
+	pgm.data, pgm.width, pgm.height = data, width, height
+}
+
+// Reducer selects how ReducePGM combines the values of a pixel across images.
+type Reducer int
+
+const (
+	Max    Reducer = iota // Brightest value, e.g. for focus stacking.
+	Min                   // Darkest value.
+	Mean                  // Rounded average, e.g. to reduce noise over several captures.
+	Median                // Middle value, robust to outliers; the rounded mean of the two middle values for an even count.
+)
+
+// ReducePGM combines a stack of images into one by applying the reducer to
+// each pixel across all of them. The images must share their dimensions and
+// max value; the result has the magic number of the first one.
+func ReducePGM(imgs []*PGM, reducer Reducer) (*PGM, error) {
+	if len(imgs) == 0 {
+		return nil, errors.New("no images to reduce")
+	}
+	first := imgs[0]
+	for i, img := range imgs {
+		if img == nil {
+			return nil, fmt.Errorf("image %d is nil", i)
+		}
+		if img.width != first.width || img.height != first.height {
+			return nil, fmt.Errorf("image %d size mismatch: %dx%d vs %dx%d", i, img.width, img.height, first.width, first.height)
+		}
+		if img.max != first.max {
+			return nil, fmt.Errorf("image %d max value mismatch: %d vs %d", i, img.max, first.max)
+		}
+	}
+	if reducer < Max || reducer > Median {
+		return nil, fmt.Errorf("unknown reducer: %d", reducer)
+	}
+
+	out := &PGM{
+		data:        make([][]uint8, first.height),
+		width:       first.width,
+		height:      first.height,
+		magicNumber: first.magicNumber,
+		max:         first.max,
+	}
+	values := make([]int, len(imgs))
+	for y := 0; y < first.height; y++ {
+		out.data[y] = make([]uint8, first.width)
+		for x := 0; x < first.width; x++ {
+			for i, img := range imgs {
+				values[i] = int(img.data[y][x])
+			}
+
+			var v int
+			switch reducer {
+			case Max:
+				v = slices.Max(values)
+			case Min:
+				v = slices.Min(values)
+			case Mean:
+				sum := 0
+				for _, value := range values {
+					sum += value
+				}
+				v = (sum + len(values)/2) / len(values)
+			case Median:
+				slices.Sort(values)
+				n := len(values)
+				v = values[n/2]
+				if n%2 == 0 {
+					v = (values[n/2-1] + values[n/2] + 1) / 2
+				}
+			}
+			out.data[y][x] = uint8(v)
+		}
+	}
+	return out, nil
+}
+
+// Threshold sets every pixel below value to 0 and every other pixel to the max
+// value, keeping the image a PGM.
+func (pgm *PGM) Threshold(value uint8) {
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if pgm.data[y][x] < value {
+				pgm.data[y][x] = 0
+			} else {
+				pgm.data[y][x] = pgm.max
+			}
+		}
+	}
+}
+
+// ThresholdBand sets every pixel within [lo, hi] to the max value and every
+// other pixel to 0, keeping the image a PGM.
+func (pgm *PGM) ThresholdBand(lo, hi uint8) {
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if v := pgm.data[y][x]; v >= lo && v <= hi {
+				pgm.data[y][x] = pgm.max
+			} else {
+				pgm.data[y][x] = 0
+			}
+		}
+	}
+}
+
+// RotateAngle rotates the image by the given angle in degrees around its
+// center, positive angles turning counter-clockwise on screen. The canvas is
+// enlarged to fit the whole rotated image and the newly exposed corners are
+// set to fill. Pixels are resampled with nearest neighbor, which is fast and
+// keeps the original values, at the cost of jagged edges.
+func (pgm *PGM) RotateAngle(degrees float64, fill uint8) {
+	pgm.data, pgm.width, pgm.height = rotateExpanded(pgm.data, pgm.width, pgm.height, degrees, fill)
+}
+
+// Crop returns a new image holding a copy of the width x height rectangle whose
+// top-left corner is (x, y), with the same magic number and max value. It returns
+// an error if the rectangle is empty or extends beyond the image.
+func (pgm *PGM) Crop(x, y, width, height int) (*PGM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid crop dimensions: %dx%d", width, height)
+	}
+	if x < 0 || y < 0 || x+width > pgm.width || y+height > pgm.height {
+		return nil, fmt.Errorf("crop rectangle (%d, %d) %dx%d out of bounds of %dx%d image", x, y, width, height, pgm.width, pgm.height)
+	}
+
+	return &PGM{
+		data:        copyRect(pgm.data, x, y, width, height),
+		width:       width,
+		height:      height,
+		magicNumber: pgm.magicNumber,
+		max:         pgm.max,
+	}, nil
+}
+
+// LineProfile returns the gray values along the line between two points, in
+// order from p1 to p2, following the same Bresenham line as PPM.DrawLine. This
+// is the classic line scan for measuring intensity across a feature. Points
+// outside the image are skipped rather than clamped, as in PPM.LineProfile.
+func (pgm *PGM) LineProfile(p1, p2 Point) []uint8 {
+	return lineProfile(pgm.data, pgm.width, pgm.height, p1, p2)
+}
+
+// FindPeaks returns the local maxima of the image, such as stars or particles,
+// sorted by brightness in descending order (raster order among equal values).
+// A peak is at least threshold bright and no darker than its 8 neighbors.
+// Peaks closer than minDistance pixels, measured along x or y, to a brighter
+// accepted peak are suppressed, so that each bright spot is reported once.
+// A minDistance below 1 is treated as 1.
+func (pgm *PGM) FindPeaks(minDistance int, threshold uint8) []Point {
+	minDistance = max(minDistance, 1)
+
+	var candidates []Point
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			v := pgm.data[y][x]
+			if v < threshold {
+				continue
+			}
+			isPeak := true
+			for dy := -1; dy <= 1 && isPeak; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx >= 0 && nx < pgm.width && ny >= 0 && ny < pgm.height && pgm.data[ny][nx] > v {
+						isPeak = false
+						break
+					}
+				}
+			}
+			if isPeak {
+				candidates = append(candidates, Point{x, y})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return pgm.data[candidates[i].Y][candidates[i].X] > pgm.data[candidates[j].Y][candidates[j].X]
+	})
+
+	var peaks []Point
+	for _, c := range candidates {
+		suppressed := false
+		for _, p := range peaks {
+			if max(abs(c.X-p.X), abs(c.Y-p.Y)) < minDistance {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			peaks = append(peaks, c)
+		}
+	}
+	return peaks
+}
+
+// CropTo crops the image in place to the rectangle between two corners, both
+// included, which may be given in any order. It returns an error, leaving the
+// image unchanged, if the rectangle extends beyond the image.
+func (pgm *PGM) CropTo(topLeft, bottomRight Point) error {
+	x0, x1 := min(topLeft.X, bottomRight.X), max(topLeft.X, bottomRight.X)
+	y0, y1 := min(topLeft.Y, bottomRight.Y), max(topLeft.Y, bottomRight.Y)
+	cropped, err := pgm.Crop(x0, y0, x1-x0+1, y1-y0+1)
+	if err != nil {
+		return err
+	}
+	*pgm = *cropped
+	return nil
+}
+
+// IsUniform reports whether every pixel of the image has the same value, and
+// returns that value, or the zero value otherwise. It stops at the first differing
+// pixel, which makes it a cheap check for blank frames. An empty image is not
+// uniform.
+func (pgm *PGM) IsUniform() (bool, uint8) {
+	var zero uint8
+	if pgm.width <= 0 || pgm.height <= 0 {
+		return false, zero
+	}
+
+	first := pgm.data[0][0]
+	for y := 0; y < pgm.height; y++ {
+		for x := 0; x < pgm.width; x++ {
+			if pgm.data[y][x] != first {
+				return false, zero
+			}
+		}
+	}
+	return true, first
+}
+
+// Clone returns a deep copy of the image: modifying the copy does not affect
+// the original, unlike assigning the struct, which shares the pixel rows.
+func (pgm *PGM) Clone() *PGM {
+	clone := *pgm
+	clone.data = make([][]uint8, len(pgm.data))
+	for y, row := range pgm.data {
+		clone.data[y] = append([]uint8(nil), row...)
+	}
+	return &clone
+}
+
+// Equal reports whether both images have the same magic number, dimensions, max value
+// and pixels. It returns false if either image is nil.
+func (pgm *PGM) Equal(other *PGM) bool {
+	return pgm.EqualPixels(other) && pgm.magicNumber == other.magicNumber
+}
+
+// EqualPixels is like Equal but ignores the magic number, so that the ASCII
+// and binary forms of the same image compare equal.
+func (pgm *PGM) EqualPixels(other *PGM) bool {
+	if pgm == nil || other == nil {
+		return false
+	}
+	if pgm.width != other.width || pgm.height != other.height || pgm.max != other.max {
+		return false
+	}
+	for y := 0; y < pgm.height; y++ {
+		if !slices.Equal(pgm.data[y], other.data[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flatten returns a copy of the samples in a single row-major slice of
+// width*height values, the layout expected by C libraries, ML tensors or GPU
+// buffers.
+func (pgm *PGM) Flatten() []uint8 {
+	flat := make([]uint8, 0, pgm.width*pgm.height)
+	for y := 0; y < pgm.height; y++ {
+		flat = append(flat, pgm.data[y]...)
+	}
+	return flat
+}
+
+// UnflattenPGM creates a P5 PGM with a max value of 255 from row-major samples,
+// as returned by Flatten. The data is copied. It returns an error if the
+// dimensions are not positive or do not match the length of data.
+func UnflattenPGM(width, height int, data []uint8) (*PGM, error) {
+	pgm, err := NewPGM(width, height, 255)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != width*height {
+		return nil, fmt.Errorf("data length %d does not match %dx%d image", len(data), width, height)
+	}
+	for y := range pgm.data {
+		copy(pgm.data[y], data[y*width:])
+	}
+	return pgm, nil
+}
+
+// AdjustBrightness adds delta to every pixel, saturating at 0 and the max
+// value instead of wrapping around, so a negative delta darkens the image.
+// Use AdjustBrightnessReport to learn how many pixels were clamped.
+func (pgm *PGM) AdjustBrightness(delta int) {
+	pgm.AdjustBrightnessReport(delta)
+}
+
+// AdjustBrightnessReport adds delta to every pixel, saturating at 0 and the
+// max value, and returns how many pixels were clamped at each end. A large
+// count tells that the adjustment crushed the shadows or blew out the
+// highlights and should be backed off.
+func (pgm *PGM) AdjustBrightnessReport(delta int) (clampedLow, clampedHigh int) {
+	return pgm.adjustReport(func(v float64) float64 { return v + float64(delta) })
+}
+
+// AdjustContrast scales the distance of every pixel from the middle of the
+// range [0, max] by factor, saturating at 0 and the max value, so that factors
+// above 1 increase the contrast and factors below 1 reduce it. Use
+// AdjustContrastReport to learn how many pixels were clamped.
+func (pgm *PGM) AdjustContrast(factor float64) {
+	pgm.AdjustContrastReport(factor)
+}
+
+// AdjustContrastReport scales the distance of every pixel from the middle of
+// the range [0, max] by factor, so that factors above 1 increase the contrast,
+// and returns how many pixels were clamped at each end.
+func (pgm *PGM) AdjustContrastReport(factor float64) (clampedLow, clampedHigh int) {
+	mid := float64(pgm.max) / 2
+	return pgm.adjustReport(func(v float64) float64 { return (v-mid)*factor + mid })
+}
+
+// Multiply multiplies every pixel by factor, saturating at 0 and the max
+// value. Use MultiplyReport to learn how many pixels were clamped.
+func (pgm *PGM) Multiply(factor float64) {
+	pgm.MultiplyReport(factor)
+}
+
+// MultiplyReport multiplies every pixel by factor and returns how many pixels
+// were clamped at each end.
+func (pgm *PGM) MultiplyReport(factor float64) (clampedLow, clampedHigh int) {
+	return pgm.adjustReport(func(v float64) float64 { return v * factor })
+}
+
+// adjustReport replaces every pixel v by f(v), rounded and clamped to
+// [0, max], counting the clamped pixels.
+func (pgm *PGM) adjustReport(f func(float64) float64) (low, high int) {
+	for y := range pgm.data {
+		for x, v := range pgm.data[y] {
+			pgm.data[y][x] = clampCount(f(float64(v)), pgm.max, &low, &high)
+		}
+	}
+	return low, high
+}
+
+// FloodFill replaces with value the 4-connected region of pixels that have the
+// same value as the pixel at start, like a paint bucket. It uses an explicit
+// stack, so large regions cannot overflow the call stack. It does nothing if
+// start lies outside the image or its pixel already has value.
+func (pgm *PGM) FloodFill(start Point, value uint8) {
+	if start.X < 0 || start.X >= pgm.width || start.Y < 0 || start.Y >= pgm.height {
+		return
+	}
+	seed := pgm.data[start.Y][start.X]
+	if seed == value {
+		return
+	}
+
+	// Filled pixels no longer match seed, so they are never pushed again.
+	pgm.data[start.Y][start.X] = value
+	stack := []Point{start}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, n := range [4]Point{{p.X + 1, p.Y}, {p.X - 1, p.Y}, {p.X, p.Y + 1}, {p.X, p.Y - 1}} {
+			if n.X < 0 || n.X >= pgm.width || n.Y < 0 || n.Y >= pgm.height || pgm.data[n.Y][n.X] != seed {
+				continue
+			}
+			pgm.data[n.Y][n.X] = value
+			stack = append(stack, n)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PGM16 represents a PGM image with samples of up to 16 bits, for max values
+// up to 65535 as found in scientific imaging. Binary (P5) files store such
+// samples as two big-endian bytes when the max value is above 255.
+type PGM16 struct {
+	data          [][]uint16 // 2D slice to store the pixel values.
+	width, height int        // Width and height of the image.
+	magicNumber   string     // Magic number indicating PGM format (P2 for ASCII, P5 for Binary).
+	max           uint16     // Maximum grayscale value.
+}
+
+// ReadPGM16 reads a PGM file of any depth, keeping its samples at full
+// precision, and returns a PGM16 struct and an error if any.
+func ReadPGM16(filename string) (*PGM16, error) {
+	return ReadPGM16Opts(filename, Options16{})
+}
+
+// ReadPGM16Opts reads a PGM file like ReadPGM16, with options to decode
+// non-standard files, such as 16-bit samples written in little-endian order.
+func ReadPGM16Opts(filename string, opts Options16) (*PGM16, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return decodePGM16(file, opts)
+}
+
+// DecodePGM16 reads a PGM image of any depth from r, keeping its samples at
+// full precision, and returns a PGM16 struct and an error if any.
+func DecodePGM16(r io.Reader) (*PGM16, error) {
+	return decodePGM16(r, Options16{})
+}
+
+// decodePGM16 implements DecodePGM16 and ReadPGM16Opts.
+func decodePGM16(r io.Reader, opts Options16) (*PGM16, error) {
+	reader := bufio.NewReader(r)
+	magicNumber, width, height, max, err := readPGMHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return readPGM16Data(reader, magicNumber, width, height, uint16(max), opts.Endianness)
+}
+
+// NewPGM16 creates a black P5 image of the given size and max value.
+// It returns an error if the width or height is not positive.
+func NewPGM16(width, height int, max uint16) (*PGM16, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", width, height)
+	}
+	pgm := &PGM16{
+		data:        make([][]uint16, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P5",
+		max:         max,
+	}
+	for i := range pgm.data {
+		pgm.data[i] = make([]uint16, width)
+	}
+	return pgm, nil
+}
+
+// readPGM16Data reads the pixel data following a PGM header, with 2-byte
+// samples in the given byte order.
+func readPGM16Data(reader *bufio.Reader, magicNumber string, width, height int, max uint16, order Endianness) (*PGM16, error) {
+	plain := magicNumber == "P2"
+	reader, order, err := resolveEndianness(reader, plain, max, order, width*height)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]uint16, height)
+	for y := range data {
+		data[y] = make([]uint16, width)
+		err := readSamples16(reader, plain, max, order, data[y])
+		if err != nil {
+			return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
+		}
+	}
+	return &PGM16{data, width, height, magicNumber, max}, nil
+}
+
+// Size returns the width and height of the PGM16 image.
+func (pgm *PGM16) Size() (int, int) {
+	return pgm.width, pgm.height
+}
+
+// At returns the value of the pixel at (x, y).
+func (pgm *PGM16) At(x, y int) uint16 {
+	return pgm.data[y][x]
+}
+
+// Set sets the value of the pixel at (x, y).
+func (pgm *PGM16) Set(x, y int, value uint16) {
+	pgm.data[y][x] = value
+}
+
+// Save saves the PGM16 image to a file, with two bytes per sample in binary
+// (P5) form when the max value is above 255.
+func (pgm *PGM16) Save(filename string) error {
+	if pgm.magicNumber != "P2" && pgm.magicNumber != "P5" {
+		return fmt.Errorf("unsupported magic number: %s", pgm.magicNumber)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	_, err = fmt.Fprintf(writer, "%s\n%d %d\n%d\n", pgm.magicNumber, pgm.width, pgm.height, pgm.max)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+	for y, row := range pgm.data {
+		err := writeSamples16(writer, pgm.magicNumber == "P2", pgm.max, row)
+		if err != nil {
+			return fmt.Errorf("error writing pixel data at row %d: %v", y, err)
+		}
+	}
+	return writer.Flush()
+}
+
+// ToPGM converts the image to an 8-bit PGM with the same magic number. Samples
+// are scaled to a max value of 255 when the max value is above 255.
+func (pgm *PGM16) ToPGM() *PGM {
+	out := &PGM{
+		data:        make([][]uint8, pgm.height),
+		width:       pgm.width,
+		height:      pgm.height,
+		magicNumber: pgm.magicNumber,
+		max:         uint8(min(pgm.max, 255)),
+	}
+	for y := 0; y < pgm.height; y++ {
+		out.data[y] = make([]uint8, pgm.width)
+		for x, v := range pgm.data[y] {
+			if pgm.max > 255 {
+				out.data[y][x] = scaleTo8(v, pgm.max)
+			} else {
+				out.data[y][x] = uint8(v)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package Netpbm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFile writes data to a new file in a temporary directory and returns
+// its name.
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// p5File returns a P5 file of one row of 2-byte samples in the given byte order.
+func p5File(samples []uint16, max uint16, order binary.AppendByteOrder) []byte {
+	data := fmt.Appendf(nil, "P5\n%d 1\n%d\n", len(samples), max)
+	for _, v := range samples {
+		data = order.AppendUint16(data, v)
+	}
+	return data
+}
+
+func TestReadPGM16Endianness(t *testing.T) {
+	samples := []uint16{0, 1, 300, 999, 1000}
+	big := writeFile(t, p5File(samples, 1000, binary.BigEndian))
+	little := writeFile(t, p5File(samples, 1000, binary.LittleEndian))
+
+	tests := []struct {
+		name     string
+		filename string
+		order    Endianness
+	}{
+		{"big-endian file, default", big, BigEndian},
+		{"little-endian file, LittleEndian", little, LittleEndian},
+		{"big-endian file, DetectEndian", big, DetectEndian},
+		{"little-endian file, DetectEndian", little, DetectEndian},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pgm, err := ReadPGM16Opts(tt.filename, Options16{Endianness: tt.order})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(pgm.data[0], samples) {
+				t.Errorf("got %v, want %v", pgm.data[0], samples)
+			}
+		})
+	}
+
+	// The default stays strict: little-endian samples read as big-endian
+	// exceed the max value and are rejected.
+	if _, err := ReadPGM16(little); err == nil {
+		t.Error("ReadPGM16 accepted a little-endian file")
+	}
+}
+
+func TestReadPPM16DetectEndian(t *testing.T) {
+	want := []Pixel16{{1, 2, 3}, {500, 40000, 65535}}
+	data := []byte("P6\n2 1\n65535\n")
+	for _, p := range want {
+		data = binary.BigEndian.AppendUint16(data, p.R)
+		data = binary.BigEndian.AppendUint16(data, p.G)
+		data = binary.BigEndian.AppendUint16(data, p.B)
+	}
+
+	// With a max value of 65535 every sample is plausible in both byte
+	// orders, so detection keeps the standard big-endian order.
+	ppm, err := ReadPPM16Opts(writeFile(t, data), Options16{Endianness: DetectEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ppm.data[0], want) {
+		t.Errorf("got %v, want %v", ppm.data[0], want)
+	}
+}
+
+func TestReadPPM16LittleEndian(t *testing.T) {
+	want := []Pixel16{{1, 2, 3}, {500, 4000, 60000}}
+	data := []byte("P6\n2 1\n60000\n")
+	for _, p := range want {
+		data = binary.LittleEndian.AppendUint16(data, p.R)
+		data = binary.LittleEndian.AppendUint16(data, p.G)
+		data = binary.LittleEndian.AppendUint16(data, p.B)
+	}
+	filename := writeFile(t, data)
+
+	for _, order := range []Endianness{LittleEndian, DetectEndian} {
+		ppm, err := ReadPPM16Opts(filename, Options16{Endianness: order})
+		if err != nil {
+			t.Fatalf("order %d: %v", order, err)
+		}
+		if !slices.Equal(ppm.data[0], want) {
+			t.Errorf("order %d: got %v, want %v", order, ppm.data[0], want)
+		}
+	}
+}
+
+func TestPGM16RoundTrip(t *testing.T) {
+	values := []uint16{0, 1, 255, 256, 32768, 65534, 65535}
+	pgm, err := NewPGM16(len(values), 2, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, v := range values {
+		pgm.Set(x, 0, v)
+		pgm.Set(x, 1, 65535-v)
+	}
+
+	for _, magicNumber := range []string{"P5", "P2"} {
+		pgm.magicNumber = magicNumber
+		filename := filepath.Join(t.TempDir(), "image.pgm")
+		if err := pgm.Save(filename); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len("P5\n7 2\n65535\n") + 7*2*2; magicNumber == "P5" && len(data) != want {
+			t.Errorf("P5: got a %d-byte file, want %d with two bytes per sample", len(data), want)
+		}
+
+		read, err := ReadPGM16(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", magicNumber, err)
+		}
+		if read.max != 65535 || read.magicNumber != magicNumber || !slices.EqualFunc(read.data, pgm.data, slices.Equal) {
+			t.Errorf("%s: got %s max %d %v, want %v", magicNumber, read.magicNumber, read.max, read.data, pgm.data)
+		}
+
+		decoded, err := DecodePGM16(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: DecodePGM16: %v", magicNumber, err)
+		}
+		if !slices.EqualFunc(decoded.data, pgm.data, slices.Equal) {
+			t.Errorf("%s: DecodePGM16 got %v, want %v", magicNumber, decoded.data, pgm.data)
+		}
+
+		// The 8-bit reader scales the samples down.
+		scaled, err := ReadPGM(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := scaled.data[0], []uint8{0, 0, 1, 1, 128, 255, 255}; scaled.max != 255 || !slices.Equal(got, want) {
+			t.Errorf("%s: ReadPGM got max %d %v, want max 255 %v", magicNumber, scaled.max, got, want)
+		}
+	}
+
+	if _, err := NewPGM16(0, 2, 65535); err == nil {
+		t.Error("NewPGM16 accepted a zero width")
+	}
+}
+
+func TestReadPGM16OutOfRange(t *testing.T) {
+	filename := writeFile(t, p5File([]uint16{0, 1000, 1001}, 1000, binary.BigEndian))
+	if _, err := ReadPGM16(filename); err == nil {
+		t.Error("ReadPGM16 accepted a binary sample above the max value")
+	}
+	if _, err := ReadPGM(filename); err == nil {
+		t.Error("ReadPGM accepted a binary sample above the max value")
+	}
+}
+
+func TestPPM16RoundTrip(t *testing.T) {
+	want := []Pixel16{{0, 1, 65535}, {256, 32768, 65534}, {65535, 65535, 0}}
+	ppm, err := NewPPM16(len(want), 1, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, p := range want {
+		ppm.Set(x, 0, p)
+	}
+
+	for _, magicNumber := range []string{"P6", "P3"} {
+		ppm.magicNumber = magicNumber
+		filename := filepath.Join(t.TempDir(), "image.ppm")
+		if err := ppm.Save(filename); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := DecodePPM16(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", magicNumber, err)
+		}
+		if read.max != 65535 || read.magicNumber != magicNumber || !slices.Equal(read.data[0], want) {
+			t.Errorf("%s: got %s max %d %v, want %v", magicNumber, read.magicNumber, read.max, read.data[0], want)
+		}
+
+		scaled, err := DecodePPM(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := scaled.data[0], []Pixel{{0, 0, 255}, {1, 128, 255}, {255, 255, 0}}; !slices.Equal(got, want) {
+			t.Errorf("%s: DecodePPM got %v, want %v", magicNumber, got, want)
+		}
+	}
+}
+
+func TestReadPGMNormalized(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []uint8
+	}{
+		{"max 15", []byte("P2\n4 1\n15\n0 5 7 15\n"), []uint8{0, 85, 119, 255}},
+		{"max 255", []byte("P2\n3 1\n255\n0 100 255\n"), []uint8{0, 100, 255}},
+		{"max 1000", p5File([]uint16{0, 500, 1000}, 1000, binary.BigEndian), []uint8{0, 128, 255}},
+		{"max 65535", p5File([]uint16{0, 257, 32768, 65535}, 65535, binary.BigEndian), []uint8{0, 1, 128, 255}},
+	}
+	for _, tt := range tests {
+		pgm, err := ReadPGMNormalized(writeFile(t, tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if pgm.max != 255 || !slices.Equal(pgm.data[0], tt.want) {
+			t.Errorf("%s: got max %d %v, want max 255 %v", tt.name, pgm.max, pgm.data[0], tt.want)
+		}
+	}
+}
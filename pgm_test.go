@@ -0,0 +1,894 @@
+package Netpbm
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDuotoneEnds(t *testing.T) {
+	pgm, err := NewPGM(3, 1, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgm.Set(1, 0, 100)
+	pgm.Set(2, 0, 200)
+
+	shadow, highlight := Pixel{10, 20, 30}, Pixel{250, 200, 150}
+	ppm := pgm.Duotone(shadow, highlight)
+	if got := ppm.At(0, 0); got != shadow {
+		t.Errorf("gray 0: got %v, want shadow %v", got, shadow)
+	}
+	if got := ppm.At(2, 0); got != highlight {
+		t.Errorf("gray max: got %v, want highlight %v", got, highlight)
+	}
+	if got, want := ppm.At(1, 0), (Pixel{130, 110, 90}); got != want {
+		t.Errorf("gray max/2: got %v, want %v", got, want)
+	}
+}
+
+// testPGM returns a P5 PGM whose pixels all differ.
+func testPGM(t testing.TB, width, height int) *PGM {
+	t.Helper()
+	pgm, err := NewPGM(width, height, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pgm.Set(x, y, uint8(y*width+x))
+		}
+	}
+	return pgm
+}
+
+func TestPGMTransformsInto(t *testing.T) {
+	tests := []struct {
+		name    string
+		into    func(src, dst *PGM) error
+		inPlace func(*PGM)
+	}{
+		{"Rotate90CW", (*PGM).Rotate90CWInto, (*PGM).Rotate90CW},
+		{"Rotate90CCW", (*PGM).Rotate90CCWInto, (*PGM).Rotate90CCW},
+		{"Rotate180", (*PGM).Rotate180Into, (*PGM).Rotate180},
+		{"Transpose", (*PGM).TransposeInto, (*PGM).Transpose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testPGM(t, 5, 3)
+			want := src.Clone()
+			tt.inPlace(want)
+
+			dst := &PGM{}
+			if err := tt.into(src, dst); err != nil {
+				t.Fatal(err)
+			}
+			if !dst.Equal(want) {
+				t.Errorf("got %v, want %v", dst.data, want.data)
+			}
+		})
+	}
+}
+
+func TestPerceptualHashBrightened(t *testing.T) {
+	pgm, err := NewPGM(64, 64, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			// A blob and a diagonal ramp give the hash some structure.
+			v := (x + y) * 3 / 2
+			if (x-20)*(x-20)+(y-40)*(y-40) < 150 {
+				v = 230
+			}
+			pgm.Set(x, y, uint8(min(v, 255)))
+		}
+	}
+	brightened := pgm.Clone()
+	brightened.AdjustBrightness(12)
+
+	distance := HammingDistance(pgm.PerceptualHash(), brightened.PerceptualHash())
+	if distance > 4 {
+		t.Errorf("brightened image: got Hamming distance %d, want at most 4", distance)
+	}
+
+	flipped := pgm.Clone()
+	flipped.Flip()
+	if d := HammingDistance(pgm.PerceptualHash(), flipped.PerceptualHash()); d <= distance {
+		t.Errorf("flipped image: got Hamming distance %d, want more than %d", d, distance)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	if d := HammingDistance(0, 0); d != 0 {
+		t.Errorf("HammingDistance(0, 0) = %d, want 0", d)
+	}
+	if d := HammingDistance(0b1011, 0b0110); d != 3 {
+		t.Errorf("HammingDistance(0b1011, 0b0110) = %d, want 3", d)
+	}
+}
+
+func TestResizeWithEdgeSharpness(t *testing.T) {
+	// steepest upscales a black to white step edge 4x and returns the largest
+	// difference between neighboring pixels.
+	steepest := func(filter ResampleFilter) int {
+		pgm, err := NewPGM(8, 1, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := 4; x < 8; x++ {
+			pgm.Set(x, 0, 255)
+		}
+		pgm.ResizeWith(32, 1, filter)
+		if w, h := pgm.Size(); w != 32 || h != 1 {
+			t.Fatalf("filter %d: got size %dx%d, want 32x1", filter, w, h)
+		}
+		best := 0
+		for x := 1; x < 32; x++ {
+			best = max(best, int(pgm.At(x, 0))-int(pgm.At(x-1, 0)))
+		}
+		return best
+	}
+
+	for _, filter := range []ResampleFilter{Nearest, Area} {
+		if got := steepest(filter); got != 255 {
+			t.Errorf("filter %d: got steepest step %d, want a hard edge of 255", filter, got)
+		}
+	}
+	bilinear := steepest(Bilinear)
+	if bilinear >= 255 {
+		t.Errorf("Bilinear: got a hard edge, want a smooth ramp")
+	}
+	for _, filter := range []ResampleFilter{Bicubic, Lanczos} {
+		if got := steepest(filter); got <= bilinear {
+			t.Errorf("filter %d: got steepest step %d, want sharper than Bilinear's %d", filter, got, bilinear)
+		}
+	}
+}
+
+func TestMotionBlurDirection(t *testing.T) {
+	edge := func(vertical bool) *PGM {
+		pgm, err := NewPGM(10, 10, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 10; y++ {
+			for x := 0; x < 10; x++ {
+				if (vertical && x >= 5) || (!vertical && y >= 5) {
+					pgm.Set(x, y, 255)
+				}
+			}
+		}
+		return pgm
+	}
+
+	vertical := edge(true)
+	vertical.MotionBlur(5, 0)
+	for y := 0; y < 10; y++ {
+		if v := vertical.At(4, y); v == 0 || v == 255 {
+			t.Errorf("vertical edge, row %d: got %v, want it smeared", y, vertical.data[y])
+			break
+		}
+	}
+
+	horizontal := edge(false)
+	horizontal.MotionBlur(5, 0)
+	if want := edge(false); !horizontal.Equal(want) {
+		t.Errorf("horizontal edge: got %v, want it unchanged", horizontal.data)
+	}
+
+	// Rotating the blur by 90 degrees swaps the roles of the edges.
+	horizontal.MotionBlur(5, 90)
+	if v := horizontal.At(0, 4); v == 0 || v == 255 {
+		t.Errorf("vertical blur of a horizontal edge: got %d, want it smeared", v)
+	}
+}
+
+func TestSolarize(t *testing.T) {
+	pgm, err := NewPGM(5, 1, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, v := range []uint8{0, 99, 100, 101, 200} {
+		pgm.Set(x, 0, v)
+	}
+	pgm.Solarize(100)
+	if want := []uint8{0, 99, 100, 99, 0}; !slices.Equal(pgm.data[0], want) {
+		t.Errorf("got %v, want %v", pgm.data[0], want)
+	}
+}
+
+func TestToNormalMapFlat(t *testing.T) {
+	pgm, err := NewPGM(6, 4, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			pgm.Set(x, y, 77)
+		}
+	}
+	normals := pgm.ToNormalMap(2)
+	want := Pixel{128, 128, 255}
+	if n := normals.Count(func(p Pixel) bool { return p == want }); n != 24 {
+		t.Errorf("got %d pixels of %v, want all 24", n, want)
+	}
+
+	// A ramp rising to the right tilts the normals to the left.
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			pgm.Set(x, y, uint8(40*x))
+		}
+	}
+	p := pgm.ToNormalMap(1).At(2, 2)
+	if p.R >= 128 || p.G != 128 || p.B >= 255 {
+		t.Errorf("ramp: got %v, want R below 128, G 128 and B below 255", p)
+	}
+}
+
+func TestPrepareForOCR(t *testing.T) {
+	// A page lit unevenly from left to right, with three dark strokes and
+	// scattered single-pixel noise.
+	pgm, err := NewPGM(60, 30, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ink := func(x, y int) bool {
+		return (x >= 8 && x < 11 && y >= 6 && y < 24) ||
+			(y >= 13 && y < 16 && x >= 20 && x < 38) ||
+			(x >= 45 && x < 48 && y >= 6 && y < 24)
+	}
+	seed := uint32(7)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 60; x++ {
+			v := 150 + x
+			if ink(x, y) {
+				v -= 110
+			}
+			seed = seed*1664525 + 1013904223
+			if seed>>24 < 8 {
+				v = 20 // Dark noise speck.
+			}
+			pgm.Set(x, y, uint8(v))
+		}
+	}
+	orig := pgm.Clone()
+
+	pbm := pgm.PrepareForOCR(1)
+	if !pgm.Equal(orig) {
+		t.Error("the PGM was modified")
+	}
+	if w, h := pbm.Size(); w != 60 || h != 30 {
+		t.Fatalf("got size %dx%d, want 60x30", w, h)
+	}
+	inkFound, noise := 0, 0
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 60; x++ {
+			switch {
+			case ink(x, y) && pbm.At(x, y):
+				inkFound++
+			case !ink(x, y) && pbm.At(x, y) && !ink(x-1, y) && !ink(x+1, y) && !ink(x, y-1) && !ink(x, y+1):
+				noise++
+			}
+		}
+	}
+	if inkFound < 3*18*3*9/10 {
+		t.Errorf("got %d ink pixels of the %d of the strokes", inkFound, 3*18*3)
+	}
+	if noise > 0 {
+		t.Errorf("got %d noise pixels away from the strokes, want none", noise)
+	}
+
+	if w, h := pgm.PrepareForOCR(2).Size(); w != 120 || h != 60 {
+		t.Errorf("scale 2: got size %dx%d, want 120x60", w, h)
+	}
+}
+
+func TestDifferenceOfGaussians(t *testing.T) {
+	// A dark left half and a bright right half.
+	step := func(t *testing.T) *PGM {
+		pgm, err := NewPGM(40, 10, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 10; y++ {
+			for x := 20; x < 40; x++ {
+				pgm.Set(x, y, 200)
+			}
+		}
+		return pgm
+	}
+
+	pgm := step(t)
+	pgm.DifferenceOfGaussians(1, 3)
+	for _, x := range []int{0, 2, 37, 39} {
+		if v := pgm.At(x, 5); v != 128 {
+			t.Errorf("flat region at x=%d: got %d, want mid-gray 128", x, v)
+		}
+	}
+	if v := pgm.At(19, 5); v >= 118 {
+		t.Errorf("dark side of the edge: got %d, want well below mid-gray", v)
+	}
+	if v := pgm.At(20, 5); v <= 138 {
+		t.Errorf("bright side of the edge: got %d, want well above mid-gray", v)
+	}
+
+	swapped := step(t)
+	swapped.DifferenceOfGaussians(3, 1)
+	if !swapped.Equal(pgm) {
+		t.Error("sigmas in the wrong order were not swapped")
+	}
+
+	for _, sigmas := range [][2]float64{{0, 2}, {-1, 2}, {2, 2}, {math.NaN(), 2}} {
+		pgm := step(t)
+		pgm.DifferenceOfGaussians(sigmas[0], sigmas[1])
+		if !pgm.Equal(step(t)) {
+			t.Errorf("sigmas %v changed the image", sigmas)
+		}
+	}
+}
+
+func TestAtBilinearGray(t *testing.T) {
+	pgm := testPGM(t, 5, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			if got, want := pgm.AtBilinearGray(float64(x), float64(y)), pgm.At(x, y); got != want {
+				t.Errorf("(%d, %d): got %d, want the stored %d", x, y, got, want)
+			}
+		}
+	}
+	// Values are y*5 + x.
+	if got := pgm.AtBilinearGray(1.5, 2.5); got != 14 {
+		t.Errorf("(1.5, 2.5): got %d, want 14", got)
+	}
+	if got := pgm.AtBilinearGray(-2, 9); got != 15 {
+		t.Errorf("(-2, 9): got %d, want the bottom-left corner 15", got)
+	}
+
+	for _, empty := range []*PGM{{}, {height: 2, data: [][]uint8{{}, {}}}} {
+		if got := empty.AtBilinearGray(0, 0); got != 0 {
+			t.Errorf("empty %dx%d image: got %d, want 0", empty.width, empty.height, got)
+		}
+	}
+}
+
+func TestReducePGM(t *testing.T) {
+	// Two captures of a flat gray scene with opposite noise.
+	noisy := func(t *testing.T, sign int) *PGM {
+		pgm, err := NewPGM(16, 16, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed := uint32(3)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				seed = seed*1664525 + 1013904223
+				pgm.Set(x, y, uint8(100+sign*int(seed>>24%41-20)))
+			}
+		}
+		return pgm
+	}
+	variance := func(pgm *PGM) float64 {
+		var sum, sumSq float64
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				v := float64(pgm.At(x, y))
+				sum += v
+				sumSq += v * v
+			}
+		}
+		mean := sum / 256
+		return sumSq/256 - mean*mean
+	}
+
+	a, b := noisy(t, 1), noisy(t, -1)
+	mean, err := ReducePGM([]*PGM{a, b}, Mean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if va, vm := variance(a), variance(mean); vm >= va/10 {
+		t.Errorf("averaging: got variance %g, want well below the %g of a capture", vm, va)
+	}
+
+	// Per-pixel reducers on a stack of three.
+	stack := make([]*PGM, 3)
+	for i, v := range []uint8{30, 200, 90} {
+		pgm, err := NewPGM(2, 1, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pgm.Set(0, 0, v)
+		pgm.Set(1, 0, 255-v)
+		stack[i] = pgm
+	}
+	for reducer, want := range map[Reducer][2]uint8{
+		Max:    {200, 225},
+		Min:    {30, 55},
+		Mean:   {107, 148},
+		Median: {90, 165},
+	} {
+		got, err := ReducePGM(stack, reducer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.At(0, 0) != want[0] || got.At(1, 0) != want[1] {
+			t.Errorf("reducer %d: got %v, want %v", reducer, got.data[0], want)
+		}
+	}
+
+	other, err := NewPGM(2, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherMax, err := NewPGM(2, 1, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, imgs := range map[string][]*PGM{
+		"no images":     nil,
+		"nil image":     {stack[0], nil},
+		"size mismatch": {stack[0], other},
+		"max mismatch":  {stack[0], otherMax},
+	} {
+		if _, err := ReducePGM(imgs, Mean); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+	if _, err := ReducePGM(stack, Median+1); err == nil {
+		t.Error("unknown reducer: got no error")
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	values := []uint8{0, 49, 50, 51, 100, 150, 200}
+	tests := []struct {
+		name  string
+		apply func(pgm *PGM)
+		want  []uint8
+	}{
+		{"Threshold(50)", func(pgm *PGM) { pgm.Threshold(50) }, []uint8{0, 0, 200, 200, 200, 200, 200}},
+		{"Threshold(0)", func(pgm *PGM) { pgm.Threshold(0) }, []uint8{200, 200, 200, 200, 200, 200, 200}},
+		{"ThresholdBand(50, 100)", func(pgm *PGM) { pgm.ThresholdBand(50, 100) }, []uint8{0, 0, 200, 200, 200, 0, 0}},
+		{"ThresholdBand(100, 50)", func(pgm *PGM) { pgm.ThresholdBand(100, 50) }, []uint8{0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		// The max value, not 255, marks the kept pixels.
+		pgm, err := NewPGM(len(values), 1, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(pgm.data[0], values)
+		tt.apply(pgm)
+		if !slices.Equal(pgm.data[0], tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, pgm.data[0], tt.want)
+		}
+		if pgm.magicNumber != "P5" || pgm.max != 200 {
+			t.Errorf("%s: got %s max %d, want it to stay a P5 with max 200", tt.name, pgm.magicNumber, pgm.max)
+		}
+	}
+}
+
+func TestRotateAngle(t *testing.T) {
+	pgm := testPGM(t, 4, 3)
+	pgm.RotateAngle(0, 255)
+	if !pgm.Equal(testPGM(t, 4, 3)) {
+		t.Error("a rotation by 0 changed the image")
+	}
+
+	// Right angles need no fill and match the exact rotations.
+	for _, tt := range []struct {
+		degrees float64
+		rotate  func(*PGM)
+	}{
+		{90, (*PGM).Rotate90CCW},
+		{-90, (*PGM).Rotate90CW},
+		{180, (*PGM).Rotate180},
+	} {
+		got, want := testPGM(t, 4, 3), testPGM(t, 4, 3)
+		got.RotateAngle(tt.degrees, 255)
+		tt.rotate(want)
+		if !got.Equal(want) {
+			t.Errorf("RotateAngle(%g): got %v, want %v", tt.degrees, got.data, want.data)
+		}
+	}
+
+	// A 45° rotation of a square grows the canvas to its diagonal and leaves
+	// the corners to the fill.
+	square, err := NewPGM(10, 10, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			square.Set(x, y, 100)
+		}
+	}
+	square.RotateAngle(45, 7)
+	if w, h := square.Size(); w != 15 || h != 15 {
+		t.Fatalf("RotateAngle(45): got size %dx%d, want 15x15", w, h)
+	}
+	for _, p := range []Point{{0, 0}, {14, 0}, {0, 14}, {14, 14}} {
+		if v := square.At(p.X, p.Y); v != 7 {
+			t.Errorf("RotateAngle(45): corner %v is %d, want the fill 7", p, v)
+		}
+	}
+	for _, p := range []Point{{7, 7}, {7, 1}, {1, 7}, {13, 7}, {7, 13}} {
+		if v := square.At(p.X, p.Y); v != 100 {
+			t.Errorf("RotateAngle(45): %v is %d, want the image 100", p, v)
+		}
+	}
+
+	// A slight deskew only grows the canvas a little.
+	page := testPGM(t, 100, 50)
+	page.RotateAngle(3, 255)
+	if w, h := page.Size(); w != 103 || h != 56 {
+		t.Errorf("RotateAngle(3): got size %dx%d, want 103x56", w, h)
+	}
+}
+
+func TestPGMResize(t *testing.T) {
+	src := func(t *testing.T) *PGM {
+		pgm, err := NewPGM(2, 2, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(pgm.data[0], []uint8{0, 80})
+		copy(pgm.data[1], []uint8{160, 240})
+		return pgm
+	}
+	tests := []struct {
+		name   string
+		interp Interpolation
+		want   [][]uint8
+	}{
+		{"nearest neighbor", NearestNeighbor, [][]uint8{
+			{0, 0, 80, 80},
+			{0, 0, 80, 80},
+			{160, 160, 240, 240},
+			{160, 160, 240, 240},
+		}},
+		// Output pixel centers fall at -0.25, 0.25, 0.75 and 1.25 in the
+		// source: the outer ones are clamped to the edge, the inner ones are
+		// 3:1 mixes.
+		{"bilinear", Bilinear, [][]uint8{
+			{0, 20, 60, 80},
+			{40, 60, 100, 120},
+			{120, 140, 180, 200},
+			{160, 180, 220, 240},
+		}},
+	}
+	for _, tt := range tests {
+		pgm := src(t)
+		pgm.Resize(4, 4, tt.interp)
+		if w, h := pgm.Size(); w != 4 || h != 4 || !slices.EqualFunc(pgm.data, tt.want, slices.Equal) {
+			t.Errorf("%s: got %dx%d %v, want 4x4 %v", tt.name, w, h, pgm.data, tt.want)
+		}
+		if pgm.magicNumber != "P5" || pgm.max != 255 {
+			t.Errorf("%s: got %s max %d, want P5 max 255", tt.name, pgm.magicNumber, pgm.max)
+		}
+	}
+
+	pgm := src(t)
+	pgm.Resize(0, 3, Bilinear)
+	if !pgm.Equal(src(t)) {
+		t.Error("a zero width changed the image")
+	}
+}
+
+func TestPGMCrop(t *testing.T) {
+	pgm := testPGM(t, 5, 4)
+	pgm.SetMagicNumber("P2")
+	pgm.max = 200
+
+	tests := []struct {
+		name                string
+		x, y, width, height int
+		want                [][]uint8
+	}{
+		{"top-left corner", 0, 0, 2, 2, [][]uint8{{0, 1}, {5, 6}}},
+		{"bottom-right corner", 3, 2, 2, 2, [][]uint8{{13, 14}, {18, 19}}},
+		{"full image", 0, 0, 5, 4, testPGM(t, 5, 4).data},
+	}
+	for _, tt := range tests {
+		got, err := pgm.Crop(tt.x, tt.y, tt.width, tt.height)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if w, h := got.Size(); w != tt.width || h != tt.height || !slices.EqualFunc(got.data, tt.want, slices.Equal) {
+			t.Errorf("%s: got %dx%d %v, want %v", tt.name, w, h, got.data, tt.want)
+		}
+		if got.magicNumber != "P2" || got.max != 200 {
+			t.Errorf("%s: got %s max %d, want P2 max 200", tt.name, got.magicNumber, got.max)
+		}
+
+		// The crop is a copy.
+		got.Set(0, 0, 99)
+		if pgm.At(tt.x, tt.y) == 99 {
+			t.Errorf("%s: changing the crop changed the original", tt.name)
+		}
+	}
+
+	for _, r := range [][4]int{{-1, 0, 2, 2}, {0, -1, 2, 2}, {4, 0, 2, 2}, {0, 3, 1, 2}, {0, 0, 6, 4}, {0, 0, 0, 2}, {0, 0, 2, -1}} {
+		if _, err := pgm.Crop(r[0], r[1], r[2], r[3]); err == nil {
+			t.Errorf("Crop%v: got no error", r)
+		}
+	}
+}
+
+func TestPGMLineProfile(t *testing.T) {
+	// A horizontal gradient, 20 levels per column.
+	pgm, err := NewPGM(10, 6, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 10; x++ {
+			pgm.Set(x, y, uint8(20*x))
+		}
+	}
+
+	tests := []struct {
+		name   string
+		p1, p2 Point
+		want   []uint8
+	}{
+		{"across", Point{0, 2}, Point{9, 2}, []uint8{0, 20, 40, 60, 80, 100, 120, 140, 160, 180}},
+		{"backwards", Point{4, 0}, Point{1, 0}, []uint8{80, 60, 40, 20}},
+		{"along", Point{3, 0}, Point{3, 5}, []uint8{60, 60, 60, 60, 60, 60}},
+		{"diagonal", Point{0, 0}, Point{5, 5}, []uint8{0, 20, 40, 60, 80, 100}},
+		{"steep", Point{2, 0}, Point{4, 5}, []uint8{40, 40, 60, 60, 80, 80}},
+		{"leaving the image", Point{-3, 1}, Point{2, 1}, []uint8{0, 20, 40}},
+		{"outside", Point{-3, 8}, Point{12, 8}, nil},
+	}
+	for _, tt := range tests {
+		if got := pgm.LineProfile(tt.p1, tt.p2); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaveRaw(t *testing.T) {
+	files := map[string][]byte{
+		"P2 with comments": []byte("P2\n# scanned\n3 2 15\n0  7\t15\n 1 2 3\n"),
+		"16-bit P5":        append([]byte("P5 2 1 65535\n"), 0x12, 0x34, 0xff, 0xfe),
+	}
+	for name, data := range files {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src.pgm")
+		if err := os.WriteFile(src, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pgm, err := ReadPGMRaw(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		raw := filepath.Join(dir, "raw.pgm")
+		encoded := filepath.Join(dir, "encoded.pgm")
+		saved := func() (rawData, encodedData []byte) {
+			t.Helper()
+			if err := pgm.SaveRaw(raw); err != nil {
+				t.Fatal(err)
+			}
+			if err := pgm.Save(encoded); err != nil {
+				t.Fatal(err)
+			}
+			rawData, _ = os.ReadFile(raw)
+			encodedData, _ = os.ReadFile(encoded)
+			return rawData, encodedData
+		}
+
+		if got, _ := saved(); !bytes.Equal(got, data) {
+			t.Errorf("%s unchanged: got %q, want the original %q", name, got, data)
+		}
+
+		// A change, even one a hash could miss, is encoded.
+		v := pgm.At(0, 0)
+		pgm.Set(0, 0, v+1)
+		if got, want := saved(); !bytes.Equal(got, want) {
+			t.Errorf("%s modified: got %q, want the encoding %q", name, got, want)
+		}
+		pgm.Set(0, 0, v)
+		if got, _ := saved(); !bytes.Equal(got, data) {
+			t.Errorf("%s restored: got %q, want the original %q", name, got, data)
+		}
+
+		pgm.SetMagicNumber(map[string]string{"P2": "P5", "P5": "P2"}[pgm.magicNumber])
+		if got, want := saved(); !bytes.Equal(got, want) {
+			t.Errorf("%s with a new magic number: got %q, want the encoding %q", name, got, want)
+		}
+	}
+
+	// An image not read with ReadPGMRaw is encoded.
+	pgm := testPGM(t, 3, 2)
+	dir := t.TempDir()
+	if err := pgm.SaveRaw(filepath.Join(dir, "raw.pgm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pgm.Save(filepath.Join(dir, "encoded.pgm")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "raw.pgm"))
+	want, _ := os.ReadFile(filepath.Join(dir, "encoded.pgm"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want the encoding %q", got, want)
+	}
+}
+
+func TestFindPeaks(t *testing.T) {
+	pgm, err := NewPGM(20, 12, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three dots with a dimmer halo, a dim dot below the threshold, and a
+	// plateau of two equal pixels.
+	dots := []struct {
+		p Point
+		v uint8
+	}{{Point{3, 3}, 200}, {Point{15, 2}, 250}, {Point{9, 9}, 120}, {Point{17, 10}, 40}}
+	for _, d := range dots {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				pgm.Set(d.p.X+dx, d.p.Y+dy, d.v/2)
+			}
+		}
+		pgm.Set(d.p.X, d.p.Y, d.v)
+	}
+	pgm.Set(10, 9, 120)
+
+	tests := []struct {
+		name        string
+		minDistance int
+		threshold   uint8
+		want        []Point
+	}{
+		{"brightest first", 3, 100, []Point{{15, 2}, {3, 3}, {9, 9}}},
+		{"threshold", 3, 150, []Point{{15, 2}, {3, 3}}},
+		{"plateau reported twice", 1, 100, []Point{{15, 2}, {3, 3}, {9, 9}, {10, 9}}},
+		{"dim dot included", 3, 30, []Point{{15, 2}, {3, 3}, {9, 9}, {17, 10}}},
+		// The third dot is 7 pixels away from the brightest one, the second 12.
+		{"suppression", 8, 100, []Point{{15, 2}, {3, 3}}},
+	}
+	for _, tt := range tests {
+		if got := pgm.FindPeaks(tt.minDistance, tt.threshold); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	pgm := testPGM(t, 4, 3)
+	flat := pgm.Flatten()
+	if len(flat) != 12 || flat[0] != 0 || flat[5] != 5 || flat[11] != 11 {
+		t.Fatalf("got %v, want 0..11 in row-major order", flat)
+	}
+	flat[0] = 99
+	if pgm.At(0, 0) != 0 {
+		t.Error("Flatten shares memory with the image")
+	}
+	flat[0] = 0
+
+	back, err := UnflattenPGM(4, 3, flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.EqualPixels(pgm) {
+		t.Errorf("round trip got %v, want %v", back.data, pgm.data)
+	}
+	flat[1] = 99
+	if back.At(1, 0) != 1 {
+		t.Error("UnflattenPGM shares memory with the data")
+	}
+
+	for _, size := range [][2]int{{4, 2}, {5, 3}, {0, 12}} {
+		if _, err := UnflattenPGM(size[0], size[1], flat); err == nil {
+			t.Errorf("UnflattenPGM(%d, %d) accepted %d samples", size[0], size[1], len(flat))
+		}
+	}
+}
+
+func TestAdjustReport(t *testing.T) {
+	// Pixels 0..11.
+	pgm := testPGM(t, 4, 3)
+	pgm.max = 15
+	low, high := pgm.AdjustBrightnessReport(10)
+	// 6..11 reach 16..21, above the max value of 15.
+	if low != 0 || high != 6 {
+		t.Errorf("AdjustBrightnessReport(10): got %d low, %d high, want 0 and 6", low, high)
+	}
+	if pgm.At(0, 0) != 10 || pgm.At(3, 2) != 15 {
+		t.Errorf("AdjustBrightnessReport(10): got %v", pgm.data)
+	}
+
+	low, high = pgm.AdjustBrightnessReport(-12)
+	// 10 and 11 go below 0; the pixels clamped to 15 before become 3.
+	if low != 2 || high != 0 || pgm.At(3, 2) != 3 {
+		t.Errorf("AdjustBrightnessReport(-12): got %d low, %d high %v, want 2 and 0", low, high, pgm.data)
+	}
+
+	tests := []struct {
+		name      string
+		width     int
+		adjust    func(*PGM) (int, int)
+		low, high int
+		want      []uint8
+	}{
+		// Around the middle 7.5, v becomes 3v-15.
+		{"AdjustContrastReport(3)", 16, func(p *PGM) (int, int) { return p.AdjustContrastReport(3) }, 5, 5,
+			[]uint8{0, 0, 0, 0, 0, 0, 3, 6, 9, 12, 15, 15, 15, 15, 15, 15}},
+		{"MultiplyReport(3)", 8, func(p *PGM) (int, int) { return p.MultiplyReport(3) }, 0, 2, []uint8{0, 3, 6, 9, 12, 15, 15, 15}},
+	}
+	for _, tt := range tests {
+		pgm := testPGM(t, tt.width, 1)
+		pgm.max = 15
+		low, high := tt.adjust(pgm)
+		if low != tt.low || high != tt.high || !slices.Equal(pgm.data[0], tt.want) {
+			t.Errorf("%s: got %d low, %d high %v, want %d, %d %v", tt.name, low, high, pgm.data[0], tt.low, tt.high, tt.want)
+		}
+	}
+
+	// The plain variants adjust the same way.
+	a, b := testPGM(t, 8, 1), testPGM(t, 8, 1)
+	a.AdjustContrast(0.5)
+	b.AdjustContrastReport(0.5)
+	a.Multiply(2)
+	b.MultiplyReport(2)
+	a.AdjustBrightness(-3)
+	b.AdjustBrightnessReport(-3)
+	if !a.Equal(b) {
+		t.Errorf("got %v, want %v", a.data, b.data)
+	}
+}
+
+func TestPGMFloodFill(t *testing.T) {
+	// A ring of 9s around a 0 region, closed at the corners only diagonally.
+	pgm, err := NewPGM(5, 5, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Point{{1, 2}, {2, 1}, {3, 2}, {2, 3}} {
+		pgm.Set(p.X, p.Y, 9)
+	}
+
+	pgm.FloodFill(Point{2, 2}, 200)
+	if pgm.At(2, 2) != 200 || pgm.At(1, 1) != 0 {
+		t.Errorf("got %v, want only the center filled", pgm.data)
+	}
+
+	pgm.FloodFill(Point{0, 0}, 100)
+	if n := pgm.Count(func(v uint8) bool { return v == 100 }); n != 25-5 {
+		t.Errorf("got %d pixels filled, want the %d outside the ring", n, 25-5)
+	}
+	if pgm.At(2, 2) != 200 || pgm.At(2, 1) != 9 {
+		t.Errorf("got %v, want the ring and its center untouched", pgm.data)
+	}
+}
+
+func TestAdjustBrightnessSaturates(t *testing.T) {
+	for _, max := range []uint8{255, 100} {
+		pgm := testPGM(t, 10, 10)
+		pgm.max = max
+		pgm.Set(9, 9, max)
+
+		pgm.AdjustBrightness(300)
+		if n := pgm.Count(func(v uint8) bool { return v == max }); n != 100 {
+			t.Errorf("max %d, +300: got %d pixels at the max value, want all 100", max, n)
+		}
+		pgm.AdjustBrightness(-300)
+		if n := pgm.Count(func(v uint8) bool { return v == 0 }); n != 100 {
+			t.Errorf("max %d, -300: got %d pixels at 0, want all 100", max, n)
+		}
+	}
+
+	// Small deltas shift without clamping.
+	pgm := testPGM(t, 4, 1)
+	pgm.AdjustBrightness(-1)
+	pgm.AdjustBrightness(5)
+	if got, want := pgm.data[0], []uint8{5, 5, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
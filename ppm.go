@@ -2,11 +2,19 @@ package Netpbm
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"iter"
 	"math"
 	"os"
-	"strings"
+	"slices"
+	"sort"
+	"strconv"
 )
 
 type PPM struct {
@@ -14,6 +22,7 @@ type PPM struct {
 	width, height int
 	magicNumber   string
 	max           uint8
+	supersample   int // Factor set by NewSupersampled, 0 for a regular image.
 }
 
 type Pixel struct {
@@ -21,6 +30,10 @@ type Pixel struct {
 }
 
 // ReadPPM reads a PPM image from a file and returns a struct that represents the image.
+// The four header fields may be separated by any whitespace, so "P6 4 4 255" on
+// a single line is read like the usual one-field-per-line layout. Samples of
+// files with a max value above 255 are scaled down to 8 bits, which loses
+// precision; use ReadPPM16 to keep them.
 func ReadPPM(filename string) (*PPM, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -28,43 +41,30 @@ func ReadPPM(filename string) (*PPM, error) {
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	return DecodePPM(file)
+}
 
-	// Read magic number
-	magicNumber, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading magic number: %v", err)
-	}
-	magicNumber = strings.TrimSpace(magicNumber)
-	if magicNumber != "P3" && magicNumber != "P6" {
-		return nil, fmt.Errorf("invalid magic number: %s", magicNumber)
-	}
+// DecodePPM reads a PPM image from r, such as an HTTP body or an in-memory
+// buffer, and returns a PPM struct and an error if any. The four header fields
+// may be separated by any whitespace. Like ReadPPM, it scales samples above 8
+// bits down to 8 bits; use DecodePPM16 to keep them.
+func DecodePPM(r io.Reader) (*PPM, error) {
+	reader := bufio.NewReader(r)
 
-	// Read dimensions
-	dimensions, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading dimensions: %v", err)
-	}
-	var width, height int
-	_, err = fmt.Sscanf(strings.TrimSpace(dimensions), "%d %d", &width, &height)
+	magicNumber, width, height, maxValue, err := readPPMHeader(reader)
 	if err != nil {
-		return nil, fmt.Errorf("invalid dimensions: %v", err)
-	}
-	if width <= 0 || height <= 0 {
-		return nil, fmt.Errorf("invalid dimensions: width and height must be positive")
+		return nil, err
 	}
 
-	// Read max value
-	maxValue, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("error reading max value: %v", err)
-	}
-	maxValue = strings.TrimSpace(maxValue)
-	var max uint8
-	_, err = fmt.Sscanf(maxValue, "%d", &max)
-	if err != nil {
-		return nil, fmt.Errorf("invalid max value: %v", err)
+	// Samples wider than 8 bits are read at full depth, then scaled down.
+	if maxValue > 255 {
+		ppm16, err := readPPM16Data(reader, magicNumber, width, height, uint16(maxValue), BigEndian)
+		if err != nil {
+			return nil, err
+		}
+		return ppm16.ToPPM(), nil
 	}
+	max := uint8(maxValue)
 
 	// Read image data
 	data := make([][]Pixel, height)
@@ -73,26 +73,18 @@ func ReadPPM(filename string) (*PPM, error) {
 	if magicNumber == "P3" {
 		// Read P3 format (ASCII)
 		for y := 0; y < height; y++ {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				return nil, fmt.Errorf("error reading data at row %d: %v", y, err)
-			}
-			fields := strings.Fields(line)
 			rowData := make([]Pixel, width)
-			for x := 0; x < width; x++ {
-				if x*3+2 >= len(fields) {
-					return nil, fmt.Errorf("index out of range at row %d, column %d", y, x)
-				}
+			for x := range rowData {
 				var pixel Pixel
-				_, err := fmt.Sscanf(fields[x*3], "%d", &pixel.R)
+				pixel.R, err = readSample(reader, max)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing Red value at row %d, column %d: %v", y, x, err)
 				}
-				_, err = fmt.Sscanf(fields[x*3+1], "%d", &pixel.G)
+				pixel.G, err = readSample(reader, max)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing Green value at row %d, column %d: %v", y, x, err)
 				}
-				_, err = fmt.Sscanf(fields[x*3+2], "%d", &pixel.B)
+				pixel.B, err = readSample(reader, max)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing Blue value at row %d, column %d: %v", y, x, err)
 				}
@@ -104,16 +96,16 @@ func ReadPPM(filename string) (*PPM, error) {
 		// Read P6 format (binary)
 		for y := 0; y < height; y++ {
 			row := make([]byte, width*expectedBytesPerPixel)
-			n, err := reader.Read(row)
+			n, err := io.ReadFull(reader, row)
 			if err != nil {
 				if err == io.EOF {
 					return nil, fmt.Errorf("unexpected end of file at row %d", y)
 				}
+				if err == io.ErrUnexpectedEOF {
+					return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, width*expectedBytesPerPixel, n)
+				}
 				return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
 			}
-			if n < width*expectedBytesPerPixel {
-				return nil, fmt.Errorf("unexpected end of file at row %d, expected %d bytes, got %d", y, width*expectedBytesPerPixel, n)
-			}
 
 			rowData := make([]Pixel, width)
 			for x := 0; x < width; x++ {
@@ -125,7 +117,42 @@ func ReadPPM(filename string) (*PPM, error) {
 	}
 
 	// Return the PPM struct
-	return &PPM{data, width, height, magicNumber, max}, nil
+	return &PPM{data: data, width: width, height: height, magicNumber: magicNumber, max: max}, nil
+}
+
+// readPPMHeader reads and validates the magic number, dimensions and max value
+// of a PPM image, leaving the reader on the first pixel. The max value may be
+// up to 65535, for 16-bit images.
+func readPPMHeader(reader *bufio.Reader) (magicNumber string, width, height, max int, err error) {
+	// Read magic number
+	magicNumber, err = readMagic(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("error reading magic number: %v", err)
+	}
+	if magicNumber != "P3" && magicNumber != "P6" {
+		return "", 0, 0, 0, fmt.Errorf("invalid magic number: %s", magicNumber)
+	}
+
+	// Read dimensions
+	width, err = readInt(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	height, err = readInt(reader)
+	if err != nil {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: %v", err)
+	}
+	if width <= 0 || height <= 0 {
+		return "", 0, 0, 0, fmt.Errorf("invalid dimensions: width and height must be positive")
+	}
+
+	// Read max value
+	max, err = readMaxValue(reader)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+
+	return magicNumber, width, height, max, nil
 }
 
 func (ppm *PPM) PrintPPM() {
@@ -144,6 +171,25 @@ func (ppm *PPM) PrintPPM() {
 	}
 }
 
+// NewPPM creates a black P6 image of the given size and max value, ready to be
+// drawn on and saved. It returns an error if the width or height is not positive.
+func NewPPM(width, height int, max uint8) (*PPM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", width, height)
+	}
+	ppm := &PPM{
+		data:        make([][]Pixel, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P6",
+		max:         max,
+	}
+	for i := range ppm.data {
+		ppm.data[i] = make([]Pixel, width)
+	}
+	return ppm, nil
+}
+
 func (ppm *PPM) Size() (int, int) {
 	return ppm.width, ppm.height
 }
@@ -168,38 +214,71 @@ func (ppm *PPM) Set(x, y int, value Pixel) {
 	ppm.data[y][x] = value
 }
 
+// AtSafe returns the pixel at the given coordinates, or an error if they are
+// outside the image. Unlike At, it never panics.
+func (ppm *PPM) AtSafe(x, y int) (Pixel, error) {
+	if err := ppm.checkBounds(x, y); err != nil {
+		return Pixel{}, err
+	}
+	return ppm.data[y][x], nil
+}
+
+// SetSafe sets the pixel at the given coordinates, or returns an error if
+// they are outside the image. Unlike Set, it never panics.
+func (ppm *PPM) SetSafe(x, y int, value Pixel) error {
+	if err := ppm.checkBounds(x, y); err != nil {
+		return err
+	}
+	ppm.data[y][x] = value
+	return nil
+}
+
+// checkBounds returns an error if (x, y) is not a pixel of the image.
+func (ppm *PPM) checkBounds(x, y int) error {
+	if x < 0 || x >= ppm.width || y < 0 || y >= ppm.height {
+		return fmt.Errorf("index out of bounds: (%d, %d) outside %dx%d image", x, y, ppm.width, ppm.height)
+	}
+	return nil
+}
+
 func (ppm *PPM) Save(filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+
+	return ppm.Encode(file)
+}
+
+// Encode writes the PPM image to w in the format given by its magic number (P3
+// or P6), so it can be streamed without touching disk.
+func (ppm *PPM) Encode(w io.Writer) error {
+	writer := bufio.NewWriter(w)
 	if ppm.magicNumber == "P6" || ppm.magicNumber == "P3" {
-		fmt.Fprintf(file, "%s\n%d %d\n%d\n", ppm.magicNumber, ppm.width, ppm.height, ppm.max)
+		fmt.Fprintf(writer, "%s\n%d %d\n%d\n", ppm.magicNumber, ppm.width, ppm.height, ppm.max)
 	} else {
-		err = fmt.Errorf("magic number error")
+		err := fmt.Errorf("magic number error")
 		return err
 	}
 
-	//bytesPerPixel := 3 // Nombre d'octets par pixel pour P6
-
 	for y := 0; y < ppm.height; y++ {
 		for x := 0; x < ppm.width; x++ {
 			pixel := ppm.data[y][x]
 			if ppm.magicNumber == "P6" {
 				// Conversion inverse des pixels
-				file.Write([]byte{pixel.R, pixel.G, pixel.B})
+				writer.Write([]byte{pixel.R, pixel.G, pixel.B})
 			} else if ppm.magicNumber == "P3" {
 				// Conversion inverse des pixels
-				fmt.Fprintf(file, "%d %d %d ", pixel.R, pixel.G, pixel.B)
+				fmt.Fprintf(writer, "%d %d %d ", pixel.R, pixel.G, pixel.B)
 			}
 		}
 		if ppm.magicNumber == "P3" {
-			fmt.Fprint(file, "\n")
+			fmt.Fprint(writer, "\n")
 		}
 	}
 
-	return nil
+	return writer.Flush()
 }
 
 func (ppm *PPM) Invert() {
@@ -254,6 +333,7 @@ func (ppm *PPM) Rotate90CW() {
 		height:      ppm.width,
 		magicNumber: ppm.magicNumber,
 		max:         ppm.max,
+		supersample: ppm.supersample,
 	}
 
 	for i := range newPPM.data {
@@ -269,6 +349,33 @@ func (ppm *PPM) Rotate90CW() {
 	*ppm = newPPM
 }
 
+// Rotate90CCW rotates the image 90 degrees counter-clockwise.
+func (ppm *PPM) Rotate90CCW() {
+	newData := make([][]Pixel, ppm.width)
+	for x := range newData {
+		newData[x] = make([]Pixel, ppm.height)
+		for y := 0; y < ppm.height; y++ {
+			newData[x][y] = ppm.data[y][ppm.width-x-1]
+		}
+	}
+	ppm.data = newData
+	ppm.width, ppm.height = ppm.height, ppm.width
+}
+
+// Rotate180 rotates the image by 180 degrees, reversing both the row and the column order.
+func (ppm *PPM) Rotate180() {
+	ppm.Flip()
+	ppm.Flop()
+}
+
+// Transpose mirrors the image along its main diagonal, swapping rows and
+// columns, so that the pixel at (x, y) moves to (y, x).
+func (ppm *PPM) Transpose() {
+	transposed := &PPM{}
+	ppm.TransposeInto(transposed)
+	ppm.data, ppm.width, ppm.height = transposed.data, transposed.width, transposed.height
+}
+
 // ToPGM converts the PPM image to a PGM image (grayscale).
 func (ppm *PPM) ToPGM() *PGM {
 	pgm := &PGM{
@@ -342,58 +449,178 @@ func (ppm *PPM) SetPixel(p Point, color Pixel) {
 // DrawLine uses Bresenham's line algorithm to draw a line between two points.
 // Bresenham's algorithm efficiently rasterizes a line on a grid of pixels.
 func (ppm *PPM) DrawLine(p1, p2 Point, color Pixel) {
-	// Bresenham's line algorithm
+	// Clip the line to the image first, so that no time is spent on offscreen pixels.
+	line := newBresenhamLine(p1, p2)
+	first, last, visible := ppm.clipLine(line)
+	if !visible {
+		return
+	}
+
+	line.walk(first, last, func(p Point) {
+		ppm.data[p.Y][p.X] = color
+	})
+}
 
-	// Extract coordinates of the two points.
-	x1, y1 := p1.X, p1.Y
-	x2, y2 := p2.X, p2.Y
+// LinePixels returns the pixels DrawLine would set for a line between the two
+// points, in order from p1 to p2, without drawing anything. As with DrawLine,
+// the pixels outside the image are left out.
+func (ppm *PPM) LinePixels(p1, p2 Point) []Point {
+	line := newBresenhamLine(p1, p2)
+	first, last, visible := ppm.clipLine(line)
+	if !visible {
+		return nil
+	}
 
-	// Calculate differences in x and y coordinates.
-	dx := abs(x2 - x1)
-	dy := abs(y2 - y1)
+	points := make([]Point, 0, last-first+1)
+	line.walk(first, last, func(p Point) {
+		points = append(points, p)
+	})
+	return points
+}
 
-	// Determine the direction of the line along the x-axis.
-	var sx, sy int
-	if x1 < x2 {
-		sx = 1
-	} else {
-		sx = -1
+// bresenham calls plot for each pixel of the line between two points, from p1
+// to p2, using Bresenham's line algorithm.
+func bresenham(p1, p2 Point, plot func(Point)) {
+	line := newBresenhamLine(p1, p2)
+	line.walk(0, line.steps, plot)
+}
+
+// bresenhamLine is a line between two points as rasterized by Bresenham's
+// algorithm: steps+1 pixels, one per step along the major axis, the axis of
+// the larger difference.
+type bresenhamLine struct {
+	p1, p2 Point
+	dx, dy int // Absolute differences of the coordinates.
+	sx, sy int // Directions of the line along the x- and y-axes.
+	steps  int // Number of steps from p1 to p2.
+}
+
+// newBresenhamLine returns the Bresenham line from p1 to p2.
+func newBresenhamLine(p1, p2 Point) *bresenhamLine {
+	line := &bresenhamLine{
+		p1: p1, p2: p2,
+		dx: abs(p2.X - p1.X), dy: abs(p2.Y - p1.Y),
+		sx: 1, sy: 1,
 	}
+	if p1.X >= p2.X {
+		line.sx = -1
+	}
+	if p1.Y >= p2.Y {
+		line.sy = -1
+	}
+	line.steps = max(line.dx, line.dy)
+	return line
+}
 
-	// Determine the direction of the line along the y-axis.
-	if y1 < y2 {
-		sy = 1
-	} else {
-		sy = -1
+// minorSteps returns how many of the first k steps also move along the minor
+// axis. The error term of walk makes the n-th such move (from 0) on the first
+// step k for which major*(2n+1) < 2*minor*k, so the count has a closed form.
+func (line *bresenhamLine) minorSteps(k int) int {
+	major, minor := max(line.dx, line.dy), min(line.dx, line.dy)
+	num := 2*minor*k - major
+	if num <= 0 {
+		return 0
 	}
+	return (num + 2*major - 1) / (2 * major)
+}
+
+// at returns the pixel of the line after k steps from p1.
+func (line *bresenhamLine) at(k int) Point {
+	nx, ny := k, line.minorSteps(k)
+	if line.dy > line.dx {
+		nx, ny = ny, nx
+	}
+	return Point{line.p1.X + line.sx*nx, line.p1.Y + line.sy*ny}
+}
 
-	// Initialize the error term.
-	err := dx - dy
+// walk calls plot for the pixels of the steps first to last of the line. The
+// loop starts with the error term it would have after first steps from p1, so
+// the pixels are exactly those of the whole line.
+func (line *bresenhamLine) walk(first, last int, plot func(Point)) {
+	p := line.at(first)
 
-	// Iterate through the points along the line using Bresenham's algorithm.
-	for {
-		// Set the pixel at the current point on the line.
-		ppm.SetPixel(Point{x1, y1}, color)
+	// Each step along x subtracts dy from the error term, and each step along
+	// y adds dx to it.
+	nx, ny := abs(p.X-line.p1.X), abs(p.Y-line.p1.Y)
+	err := line.dx*(1+ny) - line.dy*(1+nx)
 
-		// Check if the end point of the line is reached.
-		if x1 == x2 && y1 == y2 {
+	for k := first; ; k++ {
+		plot(p)
+		if k == last {
 			break
 		}
 
-		// Calculate the doubled error term.
-		e2 := 2 * err
-
 		// Update the error term based on the decision parameter.
-		if e2 > -dy {
-			err -= dy
-			x1 += sx
+		e2 := 2 * err
+		if e2 > -line.dy {
+			err -= line.dy
+			p.X += line.sx
+		}
+		if e2 < line.dx {
+			err += line.dx
+			p.Y += line.sy
 		}
+	}
+}
+
+// Outcodes of the Cohen-Sutherland algorithm, locating a point relative to the image.
+const (
+	outsideLeft = 1 << iota
+	outsideRight
+	outsideTop
+	outsideBottom
+)
+
+// outcode returns the Cohen-Sutherland region code of p.
+func (ppm *PPM) outcode(p Point) int {
+	code := 0
+	if p.X < 0 {
+		code |= outsideLeft
+	} else if p.X >= ppm.width {
+		code |= outsideRight
+	}
+	if p.Y < 0 {
+		code |= outsideTop
+	} else if p.Y >= ppm.height {
+		code |= outsideBottom
+	}
+	return code
+}
+
+// clipLine returns the range of steps of the line whose pixels lie inside the
+// image, so that walking only those steps draws exactly the visible pixels of
+// the whole line. It reports false when no pixel of the line is visible.
+//
+// The Cohen-Sutherland outcodes of the end points accept lines lying inside
+// the image and reject lines lying beyond one of its edges, as the pixels
+// never leave the bounding box of the end points. Other lines are clipped in
+// Bresenham's integer space rather than by intersecting the segment with the
+// edges, which would round the clipped end points differently.
+func (ppm *PPM) clipLine(line *bresenhamLine) (first, last int, visible bool) {
+	code1, code2 := ppm.outcode(line.p1), ppm.outcode(line.p2)
+	if code1&code2 != 0 {
+		return 0, 0, false
+	}
+	first, last = 0, line.steps
+	if code1|code2 == 0 {
+		return first, last, true
+	}
 
-		if e2 < dx {
-			err += dx
-			y1 += sy
+	// Both coordinates move monotonically along the line, so the steps that
+	// keep one inside [0, limit] form an interval, found by binary search.
+	n := line.steps + 1
+	clip := func(coord func(k int) int, dir, limit int) {
+		if dir > 0 {
+			first = max(first, sort.Search(n, func(k int) bool { return coord(k) >= 0 }))
+			last = min(last, sort.Search(n, func(k int) bool { return coord(k) > limit })-1)
+		} else {
+			first = max(first, sort.Search(n, func(k int) bool { return coord(k) <= limit }))
+			last = min(last, sort.Search(n, func(k int) bool { return coord(k) < 0 })-1)
 		}
 	}
+	clip(func(k int) int { return line.at(k).X }, line.sx, ppm.width-1)
+	clip(func(k int) int { return line.at(k).Y }, line.sy, ppm.height-1)
+	return first, last, first <= last
 }
 
 func abs(x int) int {
@@ -437,47 +664,96 @@ func (ppm *PPM) setPixel(x, y int, color Pixel) {
 	}
 }
 
+// DrawCircle draws the outline of a circle with the midpoint circle algorithm,
+// which gives a connected outline for every radius. A radius of 0 draws the
+// center pixel only.
 func (ppm *PPM) DrawCircle(center Point, radius int, color Pixel) {
-	// converts polar coordinates to Cartesian coordinates
-
 	// Ensure non-negative radius.
 	if radius < 0 {
 		return
 	}
-	// -0.01 because 1 fucking pixel is not correct place
-	for theta := -0.01; theta <= 1.99*math.Pi; theta += (1.0 / float64(radius)) {
-		x := center.X + int(float64(radius)*math.Cos(theta))
-		y := center.Y + int(float64(radius)*math.Sin(theta))
 
-		ppm.setPixel(x, y, color)
+	midpointCircle(radius, func(dx, dy int) {
+		ppm.setPixel(center.X+dx, center.Y+dy, color)
+	})
+}
+
+// midpointCircle calls plot with the offset from the center of each pixel of
+// a circle outline, using the midpoint circle algorithm. It walks the octant
+// from the top going right, mirroring each pixel into the other seven octants,
+// so pixels on the octant boundaries may be plotted more than once.
+func midpointCircle(radius int, plot func(dx, dy int)) {
+	x, y := 0, radius
+	d := 1 - radius
+	for x <= y {
+		plot(x, y)
+		plot(-x, y)
+		plot(x, -y)
+		plot(-x, -y)
+		plot(y, x)
+		plot(-y, x)
+		plot(y, -x)
+		plot(-y, -x)
+
+		x++
+		if d < 0 {
+			d += 2*x + 1
+		} else {
+			y--
+			d += 2*(x-y) + 1
+		}
 	}
 }
 
-func (ppm *PPM) DrawFilledCircle(center Point, radius int, color Pixel) {
-	// Assurez-vous que le rayon est non négatif.
+// DrawArc draws the part of the circle outline of DrawCircle that lies between
+// the angles startDeg and endDeg, in degrees measured clockwise on screen from
+// the positive x-axis. Angles may be negative or above 360; the arc always runs
+// clockwise from startDeg to endDeg, wrapping through 0 when startDeg is
+// greater than endDeg. A span of 360 degrees or more draws the full circle.
+func (ppm *PPM) DrawArc(center Point, radius int, startDeg, endDeg float64, color Pixel) {
 	if radius < 0 {
 		return
 	}
+	if endDeg-startDeg >= 360 {
+		ppm.DrawCircle(center, radius, color)
+		return
+	}
 
-	// Remplir le point central du cercle
-	ppm.setPixel(center.X, center.Y, color)
+	start, end := normalizeDegrees(startDeg), normalizeDegrees(endDeg)
+	midpointCircle(radius, func(dx, dy int) {
+		// With y pointing down, atan2 increases clockwise on screen.
+		angle := normalizeDegrees(math.Atan2(float64(dy), float64(dx)) * 180 / math.Pi)
+		inside := angle >= start && angle <= end
+		if start > end {
+			inside = angle >= start || angle <= end
+		}
+		if inside {
+			ppm.setPixel(center.X+dx, center.Y+dy, color)
+		}
+	})
+}
 
-	// -0.01 parce qu'un putain de pixel n'est pas à la bonne place
-	for theta := -0.01; theta <= 1.99*math.Pi; theta += (1.0 / float64(radius)) {
-		x := center.X + int(float64(radius)*math.Cos(theta))
-		y := center.Y + int(float64(radius)*math.Sin(theta))
+// normalizeDegrees maps an angle in degrees to [0, 360).
+func normalizeDegrees(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
 
-		// Remplir la ligne horizontale du centre aux bords du cercle
-		for xi := x; xi < center.X; xi++ {
-			ppm.setPixel(xi, y, color)
-			ppm.setPixel(center.X*2-xi, y, color)
-		}
+// DrawFilledCircle draws a filled circle, one horizontal span per row. The
+// half-width of each span comes from the circle equation x² + y² = r², which
+// leaves no gaps whatever the radius.
+func (ppm *PPM) DrawFilledCircle(center Point, radius int, color Pixel) {
+	// Ensure non-negative radius.
+	if radius < 0 {
+		return
+	}
 
-		// Remplir la ligne verticale du centre aux bords du cercle
-		for yi := y; yi < center.Y; yi++ {
-			ppm.setPixel(x, yi, color)
-			ppm.setPixel(x, center.Y*2-yi, color)
-		}
+	for dy := -radius; dy <= radius; dy++ {
+		half := int(math.Sqrt(float64(radius*radius - dy*dy)))
+		ppm.fillRect(center.X-half, center.Y+dy, 2*half+1, 1, color)
 	}
 }
 
@@ -589,3 +865,2239 @@ func (ppm *PPM) DrawFilledPolygon(points []Point, color Pixel) {
 	}
 
 }
+
+// ppmGob mirrors PPM with exported fields so it can be handled by encoding/gob.
+type ppmGob struct {
+	Data          [][]Pixel
+	Width, Height int
+	MagicNumber   string
+	Max           uint8
+	Supersample   int
+}
+
+// GobEncode implements gob.GobEncoder, including the unexported fields of the PPM.
+func (ppm *PPM) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(ppmGob{ppm.data, ppm.width, ppm.height, ppm.magicNumber, ppm.max, ppm.supersample})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding PPM: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, restoring a PPM written by GobEncode.
+func (ppm *PPM) GobDecode(b []byte) error {
+	var g ppmGob
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g)
+	if err != nil {
+		return fmt.Errorf("error decoding PPM: %v", err)
+	}
+	*ppm = PPM{data: g.Data, width: g.Width, height: g.Height, magicNumber: g.MagicNumber, max: g.Max, supersample: g.Supersample}
+	return nil
+}
+
+// MarshalJSON encodes the PPM metadata (format, width, height and max) as JSON.
+// Pixel data is not included.
+func (ppm *PPM) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metadata{ppm.magicNumber, ppm.width, ppm.height, int(ppm.max)})
+}
+
+// UnmarshalJSON restores the PPM metadata written by MarshalJSON.
+// Since pixel data is not part of the JSON, the image is left blank. Images
+// of more than 1<<26 pixels are rejected rather than allocated.
+func (ppm *PPM) UnmarshalJSON(b []byte) error {
+	var m metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if err := m.checkSize(); err != nil {
+		return err
+	}
+	if m.Max < 0 || m.Max > 255 {
+		return fmt.Errorf("invalid max value: %d", m.Max)
+	}
+	data := make([][]Pixel, m.Height)
+	for i := range data {
+		data[i] = make([]Pixel, m.Width)
+	}
+	*ppm = PPM{data: data, width: m.Width, height: m.Height, magicNumber: m.Format, max: uint8(m.Max)}
+	return nil
+}
+
+// SaveBinary writes the PPM image to a file in binary format (P6),
+// regardless of its current magic number. The image itself is not modified.
+func (ppm *PPM) SaveBinary(filename string) error {
+	if ppm == nil {
+		return errors.New("cannot save a nil PPM")
+	}
+	binary := *ppm
+	binary.magicNumber = "P6"
+	return binary.Save(filename)
+}
+
+// SaveASCII writes the PPM image to a file in ASCII format (P3),
+// regardless of its current magic number. The image itself is not modified.
+func (ppm *PPM) SaveASCII(filename string) error {
+	if ppm == nil {
+		return errors.New("cannot save a nil PPM")
+	}
+	ascii := *ppm
+	ascii.magicNumber = "P3"
+	return ascii.Save(filename)
+}
+
+// Rotate90CWInto writes the image rotated 90 degrees clockwise into dst, which
+// must be a different PPM. The pixel rows of dst are reused when they are large
+// enough, so repeated rotations of same-sized images do not allocate.
+func (ppm *PPM) Rotate90CWInto(dst *PPM) error {
+	if err := ppm.prepareInto(dst, ppm.height, ppm.width); err != nil {
+		return err
+	}
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			dst.data[x][ppm.height-y-1] = ppm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// Rotate90CCWInto is like Rotate90CWInto, but rotates counter-clockwise.
+func (ppm *PPM) Rotate90CCWInto(dst *PPM) error {
+	if err := ppm.prepareInto(dst, ppm.height, ppm.width); err != nil {
+		return err
+	}
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			dst.data[ppm.width-x-1][y] = ppm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// Rotate180Into is like Rotate90CWInto, but rotates by 180 degrees.
+func (ppm *PPM) Rotate180Into(dst *PPM) error {
+	if err := ppm.prepareInto(dst, ppm.width, ppm.height); err != nil {
+		return err
+	}
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			dst.data[ppm.height-y-1][ppm.width-x-1] = ppm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// TransposeInto is like Rotate90CWInto, but writes the transpose of the image,
+// mirrored along its main diagonal, into dst.
+func (ppm *PPM) TransposeInto(dst *PPM) error {
+	if err := ppm.prepareInto(dst, ppm.height, ppm.width); err != nil {
+		return err
+	}
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			dst.data[x][y] = ppm.data[y][x]
+		}
+	}
+	return nil
+}
+
+// prepareInto checks that dst can receive a transform of the image, then
+// reshapes it to width x height and copies the header fields over.
+func (ppm *PPM) prepareInto(dst *PPM, width, height int) error {
+	if dst == nil {
+		return errors.New("destination PPM is nil")
+	}
+	if dst == ppm {
+		return errors.New("destination PPM must differ from the source")
+	}
+
+	dst.reshape(width, height)
+	dst.magicNumber = ppm.magicNumber
+	dst.max = ppm.max
+	dst.supersample = ppm.supersample
+	return nil
+}
+
+// reshape sets the dimensions of the image, reusing the existing pixel rows
+// when their capacity allows it. Pixel values are left unspecified.
+func (ppm *PPM) reshape(width, height int) {
+	if cap(ppm.data) < height {
+		ppm.data = make([][]Pixel, height)
+	}
+	ppm.data = ppm.data[:height]
+	for y := range ppm.data {
+		if cap(ppm.data[y]) < width {
+			ppm.data[y] = make([]Pixel, width)
+		}
+		ppm.data[y] = ppm.data[y][:width]
+	}
+	ppm.width, ppm.height = width, height
+}
+
+// NewSupersampled creates a P6 canvas factor times larger than width x height
+// in each direction. Anything drawn on it is anti-aliased when Downsample
+// averages it back to the nominal size. A non-positive factor is treated as 1.
+//
+// The canvas holds factor*factor times as many pixels as the final image, so
+// the memory cost grows quickly: a factor of 4 already needs 16 times the memory.
+func NewSupersampled(width, height, factor int, max uint8) *PPM {
+	if factor < 1 {
+		factor = 1
+	}
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+
+	ppm := &PPM{
+		data:        make([][]Pixel, height*factor),
+		width:       width * factor,
+		height:      height * factor,
+		magicNumber: "P6",
+		max:         max,
+		supersample: factor,
+	}
+	for i := range ppm.data {
+		ppm.data[i] = make([]Pixel, ppm.width)
+	}
+	return ppm
+}
+
+// Downsample shrinks a canvas created by NewSupersampled back to its nominal
+// size by averaging each factor x factor block of pixels. It does nothing on
+// images that are not supersampled.
+func (ppm *PPM) Downsample() {
+	factor := ppm.supersample
+	if factor <= 1 {
+		ppm.supersample = 0
+		return
+	}
+
+	width, height := ppm.width/factor, ppm.height/factor
+	area := factor * factor
+	newData := make([][]Pixel, height)
+	for y := 0; y < height; y++ {
+		newData[y] = make([]Pixel, width)
+		for x := 0; x < width; x++ {
+			var r, g, b int
+			for sy := y * factor; sy < (y+1)*factor; sy++ {
+				for sx := x * factor; sx < (x+1)*factor; sx++ {
+					pixel := ppm.data[sy][sx]
+					r += int(pixel.R)
+					g += int(pixel.G)
+					b += int(pixel.B)
+				}
+			}
+			newData[y][x] = Pixel{
+				R: uint8((r + area/2) / area),
+				G: uint8((g + area/2) / area),
+				B: uint8((b + area/2) / area),
+			}
+		}
+	}
+
+	ppm.data = newData
+	ppm.width, ppm.height = width, height
+	ppm.supersample = 0
+}
+
+// All returns an iterator over the coordinates and values of every pixel of the
+// PPM image in row-major order, for use as: for p, v := range ppm.All().
+func (ppm *PPM) All() iter.Seq2[Point, Pixel] {
+	return func(yield func(Point, Pixel) bool) {
+		for y := 0; y < ppm.height; y++ {
+			for x := 0; x < ppm.width; x++ {
+				if !yield(Point{x, y}, ppm.data[y][x]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Hash returns a 64-bit FNV-1a hash of the PPM header and pixel data. Images with
+// the same format, dimensions and pixels hash equally, which makes the hash
+// usable as a deduplication or cache key.
+func (ppm *PPM) Hash() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s %d %d %d\n", ppm.magicNumber, ppm.width, ppm.height, ppm.max)
+	row := make([]byte, ppm.width*3)
+	for y := 0; y < ppm.height; y++ {
+		for x, pixel := range ppm.data[y] {
+			row[x*3], row[x*3+1], row[x*3+2] = pixel.R, pixel.G, pixel.B
+		}
+		h.Write(row)
+	}
+	return h.Sum64()
+}
+
+// Resize resizes the image in place to newWidth x newHeight, keeping its
+// magic number and max value. NearestNeighbor copies the closest source pixel,
+// while Bilinear interpolates between the 2x2 closest ones, clamping at the
+// edges rather than wrapping around. Non-positive dimensions leave the image
+// unchanged.
+func (ppm *PPM) Resize(newWidth, newHeight int, interp Interpolation) {
+	ppm.ResizeWith(newWidth, newHeight, interp)
+}
+
+// ResizeWith resizes the image in place to newW x newH using the given
+// resampling filter. Non-positive dimensions leave the image unchanged.
+func (ppm *PPM) ResizeWith(newW, newH int, filter ResampleFilter) {
+	if newW <= 0 || newH <= 0 || ppm.width <= 0 || ppm.height <= 0 {
+		return
+	}
+
+	// Resample the rows horizontally first, then the columns vertically.
+	columns := resampleWeights(ppm.width, newW, filter)
+	rows := resampleWeights(ppm.height, newH, filter)
+
+	horizontal := make([][][3]float64, ppm.height)
+	for y := 0; y < ppm.height; y++ {
+		horizontal[y] = make([][3]float64, newW)
+		for x, c := range columns {
+			var sum [3]float64
+			for k, w := range c.weights {
+				pixel := ppm.data[y][c.start+k]
+				sum[0] += w * float64(pixel.R)
+				sum[1] += w * float64(pixel.G)
+				sum[2] += w * float64(pixel.B)
+			}
+			horizontal[y][x] = sum
+		}
+	}
+
+	newData := make([][]Pixel, newH)
+	for y, c := range rows {
+		newData[y] = make([]Pixel, newW)
+		for x := 0; x < newW; x++ {
+			var sum [3]float64
+			for k, w := range c.weights {
+				h := horizontal[c.start+k][x]
+				sum[0] += w * h[0]
+				sum[1] += w * h[1]
+				sum[2] += w * h[2]
+			}
+			newData[y][x] = Pixel{
+				R: clampSample(sum[0], ppm.max),
+				G: clampSample(sum[1], ppm.max),
+				B: clampSample(sum[2], ppm.max),
+			}
+		}
+	}
+
+	ppm.data = newData
+	ppm.width, ppm.height = newW, newH
+}
+
+// SplitTiles divides the image into a grid of tiles of tileW x tileH pixels,
+// addressed as tiles[row][column]. Tiles on the right and bottom edges are
+// smaller when the image size is not a multiple of the tile size.
+func (ppm *PPM) SplitTiles(tileW, tileH int) ([][]*PPM, error) {
+	if tileW <= 0 || tileH <= 0 {
+		return nil, fmt.Errorf("invalid tile size %dx%d: width and height must be positive", tileW, tileH)
+	}
+
+	rows := (ppm.height + tileH - 1) / tileH
+	cols := (ppm.width + tileW - 1) / tileW
+	tiles := make([][]*PPM, rows)
+	for r := range tiles {
+		tiles[r] = make([]*PPM, cols)
+		for c := range tiles[r] {
+			x0, y0 := c*tileW, r*tileH
+			w, h := min(tileW, ppm.width-x0), min(tileH, ppm.height-y0)
+			tile := &PPM{
+				data:        make([][]Pixel, h),
+				width:       w,
+				height:      h,
+				magicNumber: ppm.magicNumber,
+				max:         ppm.max,
+			}
+			for y := range tile.data {
+				tile.data[y] = make([]Pixel, w)
+				copy(tile.data[y], ppm.data[y0+y][x0:x0+w])
+			}
+			tiles[r][c] = tile
+		}
+	}
+	return tiles, nil
+}
+
+// AssembleTiles stitches a grid of tiles, as returned by SplitTiles, back into
+// a single image. All tiles of a row must share the same height, all tiles of
+// a column the same width, and every tile the same max value.
+func AssembleTiles(tiles [][]*PPM) (*PPM, error) {
+	if len(tiles) == 0 || len(tiles[0]) == 0 {
+		return nil, fmt.Errorf("no tiles to assemble")
+	}
+
+	cols := len(tiles[0])
+	width, height := 0, 0
+	for c := 0; c < cols; c++ {
+		if tiles[0][c] == nil {
+			return nil, fmt.Errorf("missing tile at row 0, column %d", c)
+		}
+		width += tiles[0][c].width
+	}
+	for r, row := range tiles {
+		if len(row) != cols {
+			return nil, fmt.Errorf("row %d has %d tiles, expected %d", r, len(row), cols)
+		}
+		for c, tile := range row {
+			if tile == nil {
+				return nil, fmt.Errorf("missing tile at row %d, column %d", r, c)
+			}
+			if tile.height != row[0].height || tile.width != tiles[0][c].width {
+				return nil, fmt.Errorf("tile at row %d, column %d has mismatched size %dx%d", r, c, tile.width, tile.height)
+			}
+			if tile.max != tiles[0][0].max {
+				return nil, fmt.Errorf("tile at row %d, column %d has max value %d, expected %d", r, c, tile.max, tiles[0][0].max)
+			}
+		}
+		height += row[0].height
+	}
+
+	ppm := &PPM{
+		data:        make([][]Pixel, height),
+		width:       width,
+		height:      height,
+		magicNumber: tiles[0][0].magicNumber,
+		max:         tiles[0][0].max,
+	}
+	y := 0
+	for _, row := range tiles {
+		for ty := 0; ty < row[0].height; ty++ {
+			ppm.data[y] = make([]Pixel, 0, width)
+			for _, tile := range row {
+				ppm.data[y] = append(ppm.data[y], tile.data[ty]...)
+			}
+			y++
+		}
+	}
+	return ppm, nil
+}
+
+// DrawCardinalSpline draws a smooth Cardinal spline passing through all the
+// given points. The tension controls how tight the curve is around the
+// points: 0 gives a Catmull-Rom spline and 1 gives straight segments.
+// The curve is extended past its ends with phantom points placed so that the
+// curve has no curvature at the end points (a natural end). As they depend on
+// the tangent at the neighboring point, the phantom points follow the tension.
+// The curve is clipped to the image.
+func (ppm *PPM) DrawCardinalSpline(points []Point, tension float64, color Pixel) {
+	if len(points) == 0 {
+		return
+	}
+	if len(points) == 1 {
+		ppm.SetPixel(points[0], color)
+		return
+	}
+
+	n := len(points)
+	scale := (1 - tension) / 2
+	pt := func(i int) (float64, float64) {
+		return float64(points[i].X), float64(points[i].Y)
+	}
+
+	// phantom returns the phantom point beyond the end point e, whose
+	// neighbors along the curve are e1 and e2. The second derivative of the
+	// Hermite segment from e vanishes at e when its tangent there is
+	// m = (3(e1-e) - m1)/2, where m1 = scale(e2-e) is the tangent at e1; the
+	// phantom point is the one giving that tangent. With fewer than three
+	// points, or no tangents at all, the end point is mirrored instead.
+	phantom := func(e, e1, e2 int) (float64, float64) {
+		ex, ey := pt(e)
+		e1x, e1y := pt(e1)
+		if n < 3 || scale == 0 {
+			return 2*ex - e1x, 2*ey - e1y
+		}
+		e2x, e2y := pt(e2)
+		mx := (3*(e1x-ex) - scale*(e2x-ex)) / 2
+		my := (3*(e1y-ey) - scale*(e2y-ey)) / 2
+		return e1x - mx/scale, e1y - my/scale
+	}
+	firstX, firstY := phantom(0, 1, min(2, n-1))
+	lastX, lastY := phantom(n-1, n-2, max(n-3, 0))
+
+	// at returns the point at index i, or a phantom point past the ends.
+	at := func(i int) (float64, float64) {
+		switch {
+		case i < 0:
+			return firstX, firstY
+		case i >= n:
+			return lastX, lastY
+		}
+		return pt(i)
+	}
+
+	for i := 0; i < n-1; i++ {
+		x0, y0 := at(i - 1)
+		x1, y1 := at(i)
+		x2, y2 := at(i + 1)
+		x3, y3 := at(i + 2)
+
+		// Tangents at both ends of the segment.
+		mx1, my1 := scale*(x2-x0), scale*(y2-y0)
+		mx2, my2 := scale*(x3-x1), scale*(y3-y1)
+
+		// Sample densely enough that consecutive samples are about a pixel apart.
+		length := math.Hypot(x2-x1, y2-y1) + math.Hypot(mx1, my1) + math.Hypot(mx2, my2)
+		steps := int(math.Ceil(length)) + 1
+
+		prev := points[i]
+		for s := 1; s <= steps; s++ {
+			t := float64(s) / float64(steps)
+			t2, t3 := t*t, t*t*t
+			h00, h10 := 2*t3-3*t2+1, t3-2*t2+t
+			h01, h11 := -2*t3+3*t2, t3-t2
+			x := h00*x1 + h10*mx1 + h01*x2 + h11*mx2
+			y := h00*y1 + h10*my1 + h01*y2 + h11*my2
+
+			next := Point{int(math.Round(x)), int(math.Round(y))}
+			if s == steps {
+				next = points[i+1]
+			}
+			ppm.DrawLine(prev, next, color)
+			prev = next
+		}
+	}
+}
+
+// DrawRadialGradient fills a disc whose color goes from inner at the center to
+// outer at the rim, based on each pixel's distance to the center. The disc is
+// clipped to the image and the colors are clamped to its max value.
+func (ppm *PPM) DrawRadialGradient(center Point, radius int, inner, outer Pixel) {
+	if radius < 0 {
+		return
+	}
+	if radius == 0 {
+		ppm.setPixel(center.X, center.Y, ppm.clampPixel(inner))
+		return
+	}
+
+	r := float64(radius)
+	for dy := -radius; dy <= radius; dy++ {
+		y := center.Y + dy
+		if y < 0 || y >= ppm.height {
+			continue
+		}
+		half := int(math.Sqrt(r*r - float64(dy*dy)))
+		for x := max(center.X-half, 0); x <= min(center.X+half, ppm.width-1); x++ {
+			t := math.Min(math.Hypot(float64(x-center.X), float64(dy))/r, 1)
+			ppm.data[y][x] = ppm.clampPixel(Pixel{
+				R: lerp(inner.R, outer.R, t),
+				G: lerp(inner.G, outer.G, t),
+				B: lerp(inner.B, outer.B, t),
+			})
+		}
+	}
+}
+
+// clampPixel limits each channel of the color to the max value of the image.
+func (ppm *PPM) clampPixel(color Pixel) Pixel {
+	return Pixel{min(color.R, ppm.max), min(color.G, ppm.max), min(color.B, ppm.max)}
+}
+
+// CompositeLayers stacks same-sized layers from bottom (index 0) to top, each
+// blended over the result with its opacity in [0, 1]. The bottom layer is
+// fully opaque whatever its opacity. Blending is done in linear light, which
+// avoids the darkened mixes of blending gamma-encoded values. The result uses
+// the format and max value of the bottom layer.
+func CompositeLayers(layers []*PPM, opacities []float64) (*PPM, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("no layers to composite")
+	}
+	if len(layers) != len(opacities) {
+		return nil, fmt.Errorf("got %d layers but %d opacities", len(layers), len(opacities))
+	}
+	for i, layer := range layers {
+		if layer == nil {
+			return nil, fmt.Errorf("layer %d is nil", i)
+		}
+		if layer.width != layers[0].width || layer.height != layers[0].height {
+			return nil, fmt.Errorf("layer %d is %dx%d, expected %dx%d", i, layer.width, layer.height, layers[0].width, layers[0].height)
+		}
+	}
+
+	bottom := layers[0]
+	result := &PPM{
+		data:        make([][]Pixel, bottom.height),
+		width:       bottom.width,
+		height:      bottom.height,
+		magicNumber: bottom.magicNumber,
+		max:         bottom.max,
+	}
+	linear := make([][3]float64, bottom.width)
+	for y := 0; y < bottom.height; y++ {
+		for x := range linear {
+			linear[x] = bottom.linearAt(x, y)
+		}
+		for i := 1; i < len(layers); i++ {
+			alpha := math.Max(0, math.Min(opacities[i], 1))
+			for x := range linear {
+				top := layers[i].linearAt(x, y)
+				for c := range linear[x] {
+					linear[x][c] += (top[c] - linear[x][c]) * alpha
+				}
+			}
+		}
+
+		result.data[y] = make([]Pixel, bottom.width)
+		for x, l := range linear {
+			result.data[y][x] = Pixel{
+				R: clampSample(toSRGB(l[0])*float64(result.max), result.max),
+				G: clampSample(toSRGB(l[1])*float64(result.max), result.max),
+				B: clampSample(toSRGB(l[2])*float64(result.max), result.max),
+			}
+		}
+	}
+	return result, nil
+}
+
+// linearAt returns the channels of the pixel at the given coordinates in linear light.
+func (ppm *PPM) linearAt(x, y int) [3]float64 {
+	if ppm.max == 0 {
+		return [3]float64{}
+	}
+	pixel := ppm.data[y][x]
+	m := float64(ppm.max)
+	return [3]float64{
+		toLinear(float64(pixel.R) / m),
+		toLinear(float64(pixel.G) / m),
+		toLinear(float64(pixel.B) / m),
+	}
+}
+
+// WarpAffine returns an outW x outH image of this image transformed by the
+// affine matrix [a b c; d e f], given as [6]float64{a, b, c, d, e, f}, which
+// maps a source point (x, y) to (a*x + b*y + c, d*x + e*y + f). Every output
+// pixel is mapped back into the source and sampled bilinearly; pixels whose
+// source falls outside the image, or every pixel if the matrix is not
+// invertible, are set to background.
+func (ppm *PPM) WarpAffine(matrix [6]float64, outW, outH int, background Pixel) *PPM {
+	out := &PPM{
+		data:        make([][]Pixel, max(outH, 0)),
+		width:       max(outW, 0),
+		height:      max(outH, 0),
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+
+	a, b, c, d, e, f := matrix[0], matrix[1], matrix[2], matrix[3], matrix[4], matrix[5]
+	det := a*e - b*d
+	for y := range out.data {
+		out.data[y] = make([]Pixel, out.width)
+		for x := range out.data[y] {
+			out.data[y][x] = background
+			if det == 0 {
+				continue
+			}
+			// Invert the transform: subtract the translation, then apply the inverse 2x2 matrix.
+			tx, ty := float64(x)-c, float64(y)-f
+			sx := (e*tx - b*ty) / det
+			sy := (-d*tx + a*ty) / det
+			if ppm.covers(sx, sy) {
+				out.data[y][x] = ppm.AtBilinear(sx, sy)
+			}
+		}
+	}
+	return out
+}
+
+// covers reports whether the point (x, y) lies within the image, each pixel
+// covering the unit square around its center.
+func (ppm *PPM) covers(x, y float64) bool {
+	return x >= -0.5 && x < float64(ppm.width)-0.5 && y >= -0.5 && y < float64(ppm.height)-0.5
+}
+
+// AtBilinear samples the image at fractional coordinates by interpolating
+// between the four closest pixels; integer coordinates return the stored pixel
+// exactly. Coordinates outside the image are clamped to the nearest edge, so
+// the border pixels extend indefinitely. An empty image samples as the zero Pixel.
+func (ppm *PPM) AtBilinear(x, y float64) Pixel {
+	if ppm.width <= 0 || ppm.height <= 0 {
+		return Pixel{}
+	}
+
+	x = math.Max(0, math.Min(x, float64(ppm.width-1)))
+	y = math.Max(0, math.Min(y, float64(ppm.height-1)))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, ppm.width-1), min(y0+1, ppm.height-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	mix := func(c00, c10, c01, c11 uint8) uint8 {
+		top := float64(c00) + (float64(c10)-float64(c00))*fx
+		bottom := float64(c01) + (float64(c11)-float64(c01))*fx
+		return clampSample(top+(bottom-top)*fy, 255)
+	}
+	p00, p10 := ppm.data[y0][x0], ppm.data[y0][x1]
+	p01, p11 := ppm.data[y1][x0], ppm.data[y1][x1]
+	return Pixel{
+		R: mix(p00.R, p10.R, p01.R, p11.R),
+		G: mix(p00.G, p10.G, p01.G, p11.G),
+		B: mix(p00.B, p10.B, p01.B, p11.B),
+	}
+}
+
+// WarpPerspective returns an outW x outH image in which the quadrilateral
+// srcQuad of this image is mapped onto dstQuad, corners matching by index.
+// The homography between the quads is solved from the four correspondences
+// and every output pixel is mapped back into the source and sampled
+// bilinearly. Pixels whose source falls outside the image, or every pixel if
+// the quads are degenerate, are set to background.
+func (ppm *PPM) WarpPerspective(srcQuad, dstQuad [4]Point, outW, outH int, background Pixel) *PPM {
+	out := &PPM{
+		data:        make([][]Pixel, max(outH, 0)),
+		width:       max(outW, 0),
+		height:      max(outH, 0),
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+
+	// Solve the mapping from destination to source directly, for inverse mapping.
+	h, ok := homography(dstQuad, srcQuad)
+	for y := range out.data {
+		out.data[y] = make([]Pixel, out.width)
+		for x := range out.data[y] {
+			out.data[y][x] = background
+			if !ok {
+				continue
+			}
+			fx, fy := float64(x), float64(y)
+			w := h[6]*fx + h[7]*fy + 1
+			if w == 0 {
+				continue
+			}
+			sx := (h[0]*fx + h[1]*fy + h[2]) / w
+			sy := (h[3]*fx + h[4]*fy + h[5]) / w
+			if ppm.covers(sx, sy) {
+				out.data[y][x] = ppm.AtBilinear(sx, sy)
+			}
+		}
+	}
+	return out
+}
+
+// homography returns the 8 parameters h of the projective transform mapping
+// each from[i] to to[i]:
+//
+//	x' = (h0*x + h1*y + h2) / (h6*x + h7*y + 1)
+//	y' = (h3*x + h4*y + h5) / (h6*x + h7*y + 1)
+//
+// It reports false when the points do not determine a transform.
+func homography(from, to [4]Point) ([8]float64, bool) {
+	// Each correspondence gives two linear equations in the 8 unknowns.
+	var m [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y := float64(from[i].X), float64(from[i].Y)
+		u, v := float64(to[i].X), float64(to[i].Y)
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	// Gauss-Jordan elimination with partial pivoting.
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return [8]float64{}, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			factor := m[row][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[row][k] -= factor * m[col][k]
+			}
+		}
+	}
+
+	var h [8]float64
+	for i := range h {
+		h[i] = m[i][8] / m[i][i]
+	}
+	return h, true
+}
+
+// MotionBlur simulates camera motion by averaging each pixel with its
+// neighbors along a line of the given length, oriented at angleDegrees
+// counter-clockwise from the horizontal. Pixels beyond the borders are
+// clamped to the edges. Lengths below 2 leave the image unchanged.
+func (ppm *PPM) MotionBlur(length int, angleDegrees float64) {
+	if length < 2 {
+		return
+	}
+
+	kernel := motionKernel(length, angleDegrees)
+	n := len(kernel)
+	newData := make([][]Pixel, ppm.height)
+	for y := 0; y < ppm.height; y++ {
+		newData[y] = make([]Pixel, ppm.width)
+		for x := 0; x < ppm.width; x++ {
+			var r, g, b int
+			for _, k := range kernel {
+				sx := min(max(x+k.X, 0), ppm.width-1)
+				sy := min(max(y+k.Y, 0), ppm.height-1)
+				pixel := ppm.data[sy][sx]
+				r += int(pixel.R)
+				g += int(pixel.G)
+				b += int(pixel.B)
+			}
+			newData[y][x] = Pixel{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n)}
+		}
+	}
+	ppm.data = newData
+}
+
+// Solarize inverts, channel by channel, the samples brighter than threshold,
+// leaving the darker ones unchanged, for the classic solarization look.
+func (ppm *PPM) Solarize(threshold uint8) {
+	solarize := func(v uint8) uint8 {
+		if v > threshold {
+			return ppm.max - min(v, ppm.max)
+		}
+		return v
+	}
+	for y := range ppm.data {
+		for x, pixel := range ppm.data[y] {
+			ppm.data[y][x] = Pixel{solarize(pixel.R), solarize(pixel.G), solarize(pixel.B)}
+		}
+	}
+}
+
+// OilPaint gives the image a painterly look. For each pixel, the intensities
+// of the neighbors within radius are binned into intensityLevels buckets and
+// the pixel takes the average color of the most populated bucket. Neighbors
+// beyond the borders are clamped to the edges. Non-positive parameters leave
+// the image unchanged.
+func (ppm *PPM) OilPaint(radius, intensityLevels int) {
+	if radius <= 0 || intensityLevels <= 0 || ppm.max == 0 {
+		return
+	}
+
+	counts := make([]int, intensityLevels)
+	sums := make([][3]int, intensityLevels)
+	newData := make([][]Pixel, ppm.height)
+	for y := 0; y < ppm.height; y++ {
+		newData[y] = make([]Pixel, ppm.width)
+		for x := 0; x < ppm.width; x++ {
+			for i := range counts {
+				counts[i] = 0
+				sums[i] = [3]int{}
+			}
+			for dy := -radius; dy <= radius; dy++ {
+				sy := min(max(y+dy, 0), ppm.height-1)
+				for dx := -radius; dx <= radius; dx++ {
+					sx := min(max(x+dx, 0), ppm.width-1)
+					pixel := ppm.data[sy][sx]
+					intensity := (int(pixel.R) + int(pixel.G) + int(pixel.B)) / 3
+					level := min(intensity*intensityLevels/(int(ppm.max)+1), intensityLevels-1)
+					counts[level]++
+					sums[level][0] += int(pixel.R)
+					sums[level][1] += int(pixel.G)
+					sums[level][2] += int(pixel.B)
+				}
+			}
+
+			best := 0
+			for i, count := range counts {
+				if count > counts[best] {
+					best = i
+				}
+			}
+			n := counts[best]
+			newData[y][x] = Pixel{uint8(sums[best][0] / n), uint8(sums[best][1] / n), uint8(sums[best][2] / n)}
+		}
+	}
+	ppm.data = newData
+}
+
+// FloodFill replaces with newColor the 4-connected region of pixels that have
+// the same color as the pixel at start, like a paint bucket. It does nothing
+// if start lies outside the image or its pixel already has newColor.
+func (ppm *PPM) FloodFill(start Point, newColor Pixel) {
+	if start.X < 0 || start.X >= ppm.width || start.Y < 0 || start.Y >= ppm.height {
+		return
+	}
+	if ppm.data[start.Y][start.X] == newColor {
+		return
+	}
+	ppm.FloodFillTolerance(start, newColor, 0)
+}
+
+// FloodFillTolerance fills with newColor the 4-connected region around start
+// whose pixels differ from the start pixel by at most tolerance on every
+// channel, like a magic wand with tolerance. It does nothing if start lies
+// outside the image.
+func (ppm *PPM) FloodFillTolerance(start Point, newColor Pixel, tolerance uint8) {
+	if start.X < 0 || start.X >= ppm.width || start.Y < 0 || start.Y >= ppm.height {
+		return
+	}
+
+	seed := ppm.data[start.Y][start.X]
+	within := func(a, b uint8) bool {
+		return abs(int(a)-int(b)) <= int(tolerance)
+	}
+
+	visited := make([][]bool, ppm.height)
+	for y := range visited {
+		visited[y] = make([]bool, ppm.width)
+	}
+	visited[start.Y][start.X] = true
+	stack := []Point{start}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		ppm.data[p.Y][p.X] = newColor
+
+		for _, n := range [4]Point{{p.X + 1, p.Y}, {p.X - 1, p.Y}, {p.X, p.Y + 1}, {p.X, p.Y - 1}} {
+			if n.X < 0 || n.X >= ppm.width || n.Y < 0 || n.Y >= ppm.height || visited[n.Y][n.X] {
+				continue
+			}
+			pixel := ppm.data[n.Y][n.X]
+			if within(pixel.R, seed.R) && within(pixel.G, seed.G) && within(pixel.B, seed.B) {
+				visited[n.Y][n.X] = true
+				stack = append(stack, n)
+			}
+		}
+	}
+}
+
+// SimplifyPath reduces the number of points of a polyline with the
+// Ramer-Douglas-Peucker algorithm, keeping every removed point within epsilon
+// of the simplified path. The first and last points are always kept.
+func SimplifyPath(points []Point, epsilon float64) []Point {
+	if len(points) < 3 {
+		return append([]Point(nil), points...)
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// Process the spans with an explicit stack to bound the recursion depth.
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, distance := -1, epsilon
+		for i := s.first + 1; i < s.last; i++ {
+			if d := segmentDistance(points[i], points[s.first], points[s.last]); d > distance {
+				farthest, distance = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+		}
+	}
+
+	var simplified []Point
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// segmentDistance returns the distance between p and the segment a-b.
+func segmentDistance(p, a, b Point) float64 {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	px, py := float64(p.X-a.X), float64(p.Y-a.Y)
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*dx+py*dy)/lengthSquared))
+	return math.Hypot(px-t*dx, py-t*dy)
+}
+
+// ConvexHull returns the convex hull of the points using Andrew's monotone
+// chain algorithm. The hull vertices are listed counter-clockwise with respect
+// to the x and y axes, which appears clockwise on screen since y points down,
+// and collinear points along the edges are dropped. Degenerate inputs give
+// the distinct points for fewer than 3 of them, or the two extreme points if
+// they are all collinear.
+func ConvexHull(points []Point) []Point {
+	sorted := append([]Point(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+
+	// Remove duplicates, which the chain construction does not expect.
+	unique := sorted[:0]
+	for i, p := range sorted {
+		if i == 0 || p != sorted[i-1] {
+			unique = append(unique, p)
+		}
+	}
+	if len(unique) < 3 {
+		return unique
+	}
+
+	cross := func(o, a, b Point) int {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+
+	// Build the lower hull left to right, then the upper hull right to left.
+	hull := make([]Point, 0, 2*len(unique))
+	for _, p := range unique {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(unique) - 2; i >= 0; i-- {
+		p := unique[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	// The last point repeats the first one.
+	return hull[:len(hull)-1]
+}
+
+// DrawConvexHull draws the outline of the convex hull of the points.
+func DrawConvexHull(ppm *PPM, points []Point, color Pixel) {
+	hull := ConvexHull(points)
+	switch len(hull) {
+	case 0:
+	case 1:
+		ppm.SetPixel(hull[0], color)
+	default:
+		ppm.DrawPolygon(hull, color)
+	}
+}
+
+// DrawPieChart draws a filled pie chart in which each value gets a slice
+// proportional to its share of the total, in the matching color. Slices are
+// laid out clockwise starting from the positive x-axis. The values must be
+// non-negative, not all zero, and as many as the colors.
+func (ppm *PPM) DrawPieChart(center Point, radius int, values []float64, colors []Pixel) error {
+	if len(values) != len(colors) {
+		return fmt.Errorf("got %d values but %d colors", len(values), len(colors))
+	}
+	total := 0.0
+	for i, v := range values {
+		if v < 0 {
+			return fmt.Errorf("value %d is negative: %g", i, v)
+		}
+		total += v
+	}
+	if total == 0 {
+		return errors.New("values sum to zero")
+	}
+	if radius < 0 {
+		return nil
+	}
+
+	// Cumulative end angle of each slice, in radians.
+	ends := make([]float64, len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		ends[i] = sum / total * 2 * math.Pi
+	}
+
+	r := float64(radius)
+	for dy := -radius; dy <= radius; dy++ {
+		half := int(math.Sqrt(r*r - float64(dy*dy)))
+		for dx := -half; dx <= half; dx++ {
+			// With y pointing down, atan2 grows clockwise on screen.
+			angle := math.Atan2(float64(dy), float64(dx))
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			slice := sort.SearchFloat64s(ends, angle)
+			for slice < len(ends)-1 && values[slice] == 0 {
+				slice++
+			}
+			ppm.setPixel(center.X+dx, center.Y+dy, colors[min(slice, len(colors)-1)])
+		}
+	}
+	return nil
+}
+
+// DrawBarChart draws a bar chart whose bars stand on the baseline through
+// origin, the bottom-left corner of the first bar, and grow upward. Each bar
+// is barWidth pixels wide, separated by gap pixels, and the largest value
+// reaches maxHeight pixels; negative values give empty bars. Bars are clipped
+// to the image.
+func (ppm *PPM) DrawBarChart(origin Point, barWidth, gap int, values []float64, maxHeight int, color Pixel) {
+	colors := make([]Pixel, len(values))
+	for i := range colors {
+		colors[i] = color
+	}
+	ppm.DrawBarChartColors(origin, barWidth, gap, values, maxHeight, colors)
+}
+
+// DrawBarChartColors is like DrawBarChart with a color for each bar. Bars
+// without a matching color are not drawn.
+func (ppm *PPM) DrawBarChartColors(origin Point, barWidth, gap int, values []float64, maxHeight int, colors []Pixel) {
+	if barWidth <= 0 || maxHeight <= 0 {
+		return
+	}
+
+	largest := 0.0
+	for _, v := range values {
+		largest = math.Max(largest, v)
+	}
+	if largest == 0 {
+		return
+	}
+
+	for i, v := range values {
+		if i >= len(colors) {
+			break
+		}
+		height := int(math.Round(math.Max(v, 0) / largest * float64(maxHeight)))
+		x := origin.X + i*(barWidth+gap)
+		ppm.fillRect(x, origin.Y-height+1, barWidth, height, colors[i])
+	}
+}
+
+// fillRect fills the width x height rectangle whose top-left corner is (x, y),
+// clipped to the image.
+func (ppm *PPM) fillRect(x, y, width, height int, color Pixel) {
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+width, ppm.width), min(y+height, ppm.height)
+	for py := y0; py < y1; py++ {
+		for px := x0; px < x1; px++ {
+			ppm.data[py][px] = color
+		}
+	}
+}
+
+// BlendModeType selects the per-channel formula used by BlendMode.
+type BlendModeType int
+
+const (
+	Multiply   BlendModeType = iota // a*b, always darker.
+	Screen                          // 1-(1-a)*(1-b), always lighter.
+	Overlay                         // Multiply on dark base areas, screen on light ones.
+	Darken                          // min(a, b).
+	Lighten                         // max(a, b).
+	Difference                      // |a-b|.
+)
+
+// BlendMode returns a new image blending other over this image with the given
+// blend mode, as found in image editors. Channels are normalized to [0, 1]
+// by the max value of their image, this image being the base layer a and
+// other the blend layer b, and the result uses the format and max value of
+// this image. Both images must have the same dimensions.
+func (ppm *PPM) BlendMode(other *PPM, mode BlendModeType) (*PPM, error) {
+	if other == nil {
+		return nil, errors.New("cannot blend with a nil PPM")
+	}
+	if ppm.width != other.width || ppm.height != other.height {
+		return nil, fmt.Errorf("image sizes differ: %dx%d and %dx%d", ppm.width, ppm.height, other.width, other.height)
+	}
+
+	var blend func(a, b float64) float64
+	switch mode {
+	case Multiply:
+		blend = func(a, b float64) float64 { return a * b }
+	case Screen:
+		blend = func(a, b float64) float64 { return 1 - (1-a)*(1-b) }
+	case Overlay:
+		blend = func(a, b float64) float64 {
+			if a < 0.5 {
+				return 2 * a * b
+			}
+			return 1 - 2*(1-a)*(1-b)
+		}
+	case Darken:
+		blend = math.Min
+	case Lighten:
+		blend = math.Max
+	case Difference:
+		blend = func(a, b float64) float64 { return math.Abs(a - b) }
+	default:
+		return nil, fmt.Errorf("unknown blend mode: %d", mode)
+	}
+
+	normalize := func(v, max uint8) float64 {
+		if max == 0 {
+			return 0
+		}
+		return float64(v) / float64(max)
+	}
+	channel := func(a, b uint8) uint8 {
+		v := blend(normalize(a, ppm.max), normalize(b, other.max))
+		return clampSample(v*float64(ppm.max), ppm.max)
+	}
+
+	result := &PPM{
+		data:        make([][]Pixel, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+	for y := 0; y < ppm.height; y++ {
+		result.data[y] = make([]Pixel, ppm.width)
+		for x := 0; x < ppm.width; x++ {
+			a, b := ppm.data[y][x], other.data[y][x]
+			result.data[y][x] = Pixel{channel(a.R, b.R), channel(a.G, b.G), channel(a.B, b.B)}
+		}
+	}
+	return result, nil
+}
+
+// ResizeToFill scales the image, keeping its aspect ratio, until it covers the
+// whole width x height box, then crops the overflow evenly on both sides so
+// the image ends up exactly width x height ("cover" behavior). Shrinking
+// uses area averaging and enlarging uses bilinear interpolation.
+// Non-positive dimensions leave the image unchanged.
+func (ppm *PPM) ResizeToFill(width, height int) {
+	if width <= 0 || height <= 0 || ppm.width <= 0 || ppm.height <= 0 {
+		return
+	}
+
+	scale := math.Max(float64(width)/float64(ppm.width), float64(height)/float64(ppm.height))
+	scaledW := max(int(math.Ceil(float64(ppm.width)*scale-1e-9)), width)
+	scaledH := max(int(math.Ceil(float64(ppm.height)*scale-1e-9)), height)
+	filter := Bilinear
+	if scale < 1 {
+		filter = Area
+	}
+	ppm.ResizeWith(scaledW, scaledH, filter)
+	ppm.crop((scaledW-width)/2, (scaledH-height)/2, width, height)
+}
+
+// crop keeps only the width x height rectangle at (x, y), which must lie
+// within the image, sharing the existing rows.
+func (ppm *PPM) crop(x, y, width, height int) {
+	ppm.data = ppm.data[y : y+height]
+	for i := range ppm.data {
+		ppm.data[i] = ppm.data[i][x : x+width]
+	}
+	ppm.width, ppm.height = width, height
+}
+
+// IsGrayscale reports whether every pixel of the image is gray, that is with
+// equal red, green and blue values.
+func (ppm *PPM) IsGrayscale() bool {
+	for y := range ppm.data {
+		for _, pixel := range ppm.data[y] {
+			if pixel.R != pixel.G || pixel.G != pixel.B {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DistinctColors returns the number of different colors in the image.
+func (ppm *PPM) DistinctColors() int {
+	colors := make(map[Pixel]struct{})
+	for y := range ppm.data {
+		for _, pixel := range ppm.data[y] {
+			colors[pixel] = struct{}{}
+		}
+	}
+	return len(colors)
+}
+
+// grayChannel returns a PGM holding the red channel of the image, which is
+// exact for grayscale images. The magic number keeps the ASCII or binary form.
+func (ppm *PPM) grayChannel() *PGM {
+	pgm := &PGM{
+		data:        make([][]uint8, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		magicNumber: "P2",
+		max:         ppm.max,
+	}
+	if ppm.magicNumber == "P6" {
+		pgm.magicNumber = "P5"
+	}
+	for y := range pgm.data {
+		pgm.data[y] = make([]uint8, ppm.width)
+		for x, pixel := range ppm.data[y] {
+			pgm.data[y][x] = pixel.R
+		}
+	}
+	return pgm
+}
+
+// DrawFilledRectanglePattern fills the rectangle at p1 by tiling pattern
+// instead of using a solid color, the top-left pixel of the pattern being
+// aligned with p1. The fill is clipped to the image.
+func (ppm *PPM) DrawFilledRectanglePattern(p1 Point, width, height int, pattern *PPM) {
+	if width <= 0 || height <= 0 || pattern == nil || pattern.width <= 0 || pattern.height <= 0 {
+		return
+	}
+
+	for y := max(p1.Y, 0); y < min(p1.Y+height, ppm.height); y++ {
+		row := pattern.data[(y-p1.Y)%pattern.height]
+		for x := max(p1.X, 0); x < min(p1.X+width, ppm.width); x++ {
+			ppm.data[y][x] = row[(x-p1.X)%pattern.width]
+		}
+	}
+}
+
+// digitGlyphs is a 3x5 bitmap font for the decimal digits, one string per row
+// with '#' marking the set pixels.
+var digitGlyphs = [10][5]string{
+	{"###", "#.#", "#.#", "#.#", "###"},
+	{".#.", "##.", ".#.", ".#.", "###"},
+	{"###", "..#", "###", "#..", "###"},
+	{"###", "..#", "###", "..#", "###"},
+	{"#.#", "#.#", "###", "..#", "..#"},
+	{"###", "#..", "###", "..#", "###"},
+	{"###", "#..", "###", "#.#", "###"},
+	{"###", "..#", "..#", "..#", "..#"},
+	{"###", "#.#", "###", "#.#", "###"},
+	{"###", "#.#", "###", "..#", "###"},
+}
+
+// Size of the digit glyphs, and the advance from one digit to the next.
+const glyphWidth, glyphHeight, glyphAdvance = 3, 5, 4
+
+// drawNumber writes n with the digit font, its top-left corner at p.
+func (ppm *PPM) drawNumber(p Point, n int, color Pixel) {
+	for i, digit := range strconv.Itoa(n) {
+		glyph := digitGlyphs[digit-'0']
+		for gy, row := range glyph {
+			for gx, c := range row {
+				if c == '#' {
+					ppm.setPixel(p.X+i*glyphAdvance+gx, p.Y+gy, color)
+				}
+			}
+		}
+	}
+}
+
+// DrawRuler draws tick marks every spacing pixels along the top and left edges
+// of the image, labeled with their coordinate, to check where shapes land.
+// Labels that would not fit inside the image or before the next tick are skipped.
+func (ppm *PPM) DrawRuler(spacing int, color Pixel) {
+	const tick = 4
+	if spacing <= 0 {
+		return
+	}
+
+	labelWidth := func(n int) int {
+		return len(strconv.Itoa(n))*glyphAdvance - 1
+	}
+
+	for x := 0; x < ppm.width; x += spacing {
+		ppm.DrawLine(Point{x, 0}, Point{x, tick - 1}, color)
+		w := labelWidth(x)
+		if w+2 < spacing && x+2+w <= ppm.width && glyphHeight <= ppm.height {
+			ppm.drawNumber(Point{x + 2, 0}, x, color)
+		}
+	}
+	// The origin is already marked by the top ruler.
+	for y := spacing; y < ppm.height; y += spacing {
+		ppm.DrawLine(Point{0, y}, Point{tick - 1, y}, color)
+		if glyphHeight+2 < spacing && y+2+glyphHeight <= ppm.height && labelWidth(y) <= ppm.width {
+			ppm.drawNumber(Point{0, y + 2}, y, color)
+		}
+	}
+}
+
+// ReplaceHueRange replaces the pixels whose HSV hue, in degrees, lies within
+// [loHue, hiHue] with replacement. A range with loHue greater than hiHue wraps
+// around 360, so 330 to 30 selects the reds. Gray pixels have no hue and are
+// never replaced. With keepLuminance, replaced pixels take the hue and
+// saturation of replacement but keep their own brightness (HSV value), which
+// preserves the shading.
+func (ppm *PPM) ReplaceHueRange(loHue, hiHue float64, replacement Pixel, keepLuminance bool) {
+	if ppm.max == 0 {
+		return
+	}
+	loHue, hiHue = normalizeHue(loHue), normalizeHue(hiHue)
+	m := float64(ppm.max)
+	rh, rs, _ := rgbToHSV(float64(replacement.R)/m, float64(replacement.G)/m, float64(replacement.B)/m)
+
+	for y := range ppm.data {
+		for x, pixel := range ppm.data[y] {
+			h, s, v := rgbToHSV(float64(pixel.R)/m, float64(pixel.G)/m, float64(pixel.B)/m)
+			if s == 0 {
+				continue
+			}
+			if loHue <= hiHue && (h < loHue || h > hiHue) || loHue > hiHue && h < loHue && h > hiHue {
+				continue
+			}
+			if !keepLuminance {
+				ppm.data[y][x] = replacement
+				continue
+			}
+			r, g, b := hsvToRGB(rh, rs, v)
+			ppm.data[y][x] = Pixel{clampSample(r*m, ppm.max), clampSample(g*m, ppm.max), clampSample(b*m, ppm.max)}
+		}
+	}
+}
+
+// normalizeHue brings a hue in degrees into [0, 360).
+func normalizeHue(h float64) float64 {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// rgbToHSV converts normalized RGB values to a hue in degrees [0, 360) and a
+// saturation and value in [0, 1].
+func rgbToHSV(r, g, b float64) (h, s, v float64) {
+	v = math.Max(r, math.Max(g, b))
+	chroma := v - math.Min(r, math.Min(g, b))
+	if v > 0 {
+		s = chroma / v
+	}
+	if chroma == 0 {
+		return 0, s, v
+	}
+	switch v {
+	case r:
+		h = 60 * math.Mod((g-b)/chroma, 6)
+	case g:
+		h = 60 * ((b-r)/chroma + 2)
+	default:
+		h = 60 * ((r-g)/chroma + 4)
+	}
+	return normalizeHue(h), s, v
+}
+
+// hsvToRGB converts a hue in degrees and a saturation and value in [0, 1] to
+// normalized RGB values.
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	chroma := v * s
+	h = normalizeHue(h) / 60
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+	switch {
+	case h < 1:
+		r, g, b = chroma, x, 0
+	case h < 2:
+		r, g, b = x, chroma, 0
+	case h < 3:
+		r, g, b = 0, chroma, x
+	case h < 4:
+		r, g, b = 0, x, chroma
+	case h < 5:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	m := v - chroma
+	return r + m, g + m, b + m
+}
+
+// ChromaKey returns a new image in which the pixels within tolerance of
+// keyColor on every channel, such as a green screen, are replaced with the
+// pixels of background at the same position. Both images must have the same
+// dimensions.
+func (ppm *PPM) ChromaKey(background *PPM, keyColor Pixel, tolerance uint8) (*PPM, error) {
+	return ppm.ChromaKeyFeathered(background, keyColor, tolerance, 0)
+}
+
+// ChromaKeyFeathered is like ChromaKey but softens the edges of the subject:
+// pixels whose distance to keyColor is up to feather beyond the tolerance are
+// blended with the background, the more so the closer they are to the key.
+func (ppm *PPM) ChromaKeyFeathered(background *PPM, keyColor Pixel, tolerance, feather uint8) (*PPM, error) {
+	if background == nil {
+		return nil, errors.New("background PPM is nil")
+	}
+	if ppm.width != background.width || ppm.height != background.height {
+		return nil, fmt.Errorf("image sizes differ: %dx%d and %dx%d", ppm.width, ppm.height, background.width, background.height)
+	}
+
+	result := &PPM{
+		data:        make([][]Pixel, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+	for y := 0; y < ppm.height; y++ {
+		result.data[y] = make([]Pixel, ppm.width)
+		for x, pixel := range ppm.data[y] {
+			distance := max(abs(int(pixel.R)-int(keyColor.R)), abs(int(pixel.G)-int(keyColor.G)), abs(int(pixel.B)-int(keyColor.B)))
+			switch {
+			case distance <= int(tolerance):
+				result.data[y][x] = background.data[y][x]
+			case distance <= int(tolerance)+int(feather):
+				// Opacity of the subject grows from 0 at the tolerance to 1 past the feather.
+				alpha := float64(distance-int(tolerance)) / float64(int(feather)+1)
+				back := background.data[y][x]
+				result.data[y][x] = Pixel{
+					R: lerp(back.R, pixel.R, alpha),
+					G: lerp(back.G, pixel.G, alpha),
+					B: lerp(back.B, pixel.B, alpha),
+				}
+			default:
+				result.data[y][x] = pixel
+			}
+		}
+	}
+	return result, nil
+}
+
+// AverageColor returns the mean of each channel over all the pixels.
+func (ppm *PPM) AverageColor() Pixel {
+	n := ppm.width * ppm.height
+	if n == 0 {
+		return Pixel{}
+	}
+	var r, g, b int
+	for y := range ppm.data {
+		for _, pixel := range ppm.data[y] {
+			r += int(pixel.R)
+			g += int(pixel.G)
+			b += int(pixel.B)
+		}
+	}
+	return Pixel{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n)}
+}
+
+// DominantColor returns the most frequent color of the image. Ties go to the
+// color that reaches the count first in row-major order.
+func (ppm *PPM) DominantColor() Pixel {
+	counts := make(map[Pixel]int)
+	var dominant Pixel
+	best := 0
+	for y := range ppm.data {
+		for _, pixel := range ppm.data[y] {
+			counts[pixel]++
+			if counts[pixel] > best {
+				dominant, best = pixel, counts[pixel]
+			}
+		}
+	}
+	return dominant
+}
+
+// SaveASCIIWrapped writes the image to a file in P3 format with the samples
+// wrapped so that no line is longer than maxLineLen characters. A maxLineLen
+// of 0 writes all the samples on a single line. A sample is never split, so a
+// maxLineLen shorter than a sample gives one sample per line.
+func (ppm *PPM) SaveASCIIWrapped(filename string, maxLineLen int) error {
+	if maxLineLen < 0 {
+		return fmt.Errorf("invalid max line length: %d", maxLineLen)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	_, err = fmt.Fprintf(writer, "P3\n%d %d\n%d\n", ppm.width, ppm.height, ppm.max)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+
+	tw := &tokenWriter{writer: writer, maxLineLen: maxLineLen}
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			pixel := ppm.data[y][x]
+			for _, v := range [3]uint8{pixel.R, pixel.G, pixel.B} {
+				if err := tw.write(strconv.Itoa(int(v))); err != nil {
+					return fmt.Errorf("error writing pixel data at row %d, column %d: %v", y, x, err)
+				}
+			}
+		}
+	}
+	if err := tw.end(); err != nil {
+		return fmt.Errorf("error writing pixel data: %v", err)
+	}
+
+	return writer.Flush()
+}
+
+// ToPGMLuminosity converts the PPM image to a PGM image using the luminosity
+// of each pixel, 0.299*R + 0.587*G + 0.114*B, which matches perceived
+// brightness better than the plain average of ToPGM. By default the weights
+// are applied in 8-bit fixed point, which is much faster on large images and
+// within 1 of the exact value; set exact to compute in floating point.
+func (ppm *PPM) ToPGMLuminosity(exact bool) *PGM {
+	pgm := &PGM{
+		data:        make([][]uint8, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		magicNumber: "P2",
+		max:         ppm.max,
+	}
+
+	convert := rgbToGray
+	if exact {
+		convert = rgbToGrayExact
+	}
+	for y := 0; y < ppm.height; y++ {
+		pgm.data[y] = make([]uint8, ppm.width)
+		for x, pixel := range ppm.data[y] {
+			pgm.data[y][x] = convert(pixel)
+		}
+	}
+	return pgm
+}
+
+// rgbToGray returns the luminosity of a pixel using fixed-point weights that
+// add up to 256, so that the division is a shift.
+func rgbToGray(pixel Pixel) uint8 {
+	return uint8((77*uint32(pixel.R) + 150*uint32(pixel.G) + 29*uint32(pixel.B) + 128) >> 8)
+}
+
+// rgbToGrayExact returns the luminosity of a pixel computed in floating point.
+func rgbToGrayExact(pixel Pixel) uint8 {
+	return uint8(math.Round(0.299*float64(pixel.R) + 0.587*float64(pixel.G) + 0.114*float64(pixel.B)))
+}
+
+// Count returns the number of pixels for which pred returns true, e.g. the
+// number of pure red pixels:
+//
+//	ppm.Count(func(p Pixel) bool { return p.R == ppm.max && p.G == 0 && p.B == 0 })
+func (ppm *PPM) Count(pred func(Pixel) bool) int {
+	count := 0
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			if pred(ppm.data[y][x]) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ColorMatrix replaces each pixel by a linear combination of its channels:
+// output channel i is m[i][0]*R + m[i][1]*G + m[i][2]*B + bias[i], clamped to
+// [0, max]. This expresses channel mixing, saturation changes and color
+// effects such as sepia:
+//
+//	ppm.ColorMatrix([3][3]float64{
+//		{0.393, 0.769, 0.189},
+//		{0.349, 0.686, 0.168},
+//		{0.272, 0.534, 0.131},
+//	}, [3]float64{})
+func (ppm *PPM) ColorMatrix(m [3][3]float64, bias [3]float64) {
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			p := ppm.data[y][x]
+			r, g, b := float64(p.R), float64(p.G), float64(p.B)
+			ppm.data[y][x] = Pixel{
+				R: clampSample(m[0][0]*r+m[0][1]*g+m[0][2]*b+bias[0], ppm.max),
+				G: clampSample(m[1][0]*r+m[1][1]*g+m[1][2]*b+bias[1], ppm.max),
+				B: clampSample(m[2][0]*r+m[2][1]*g+m[2][2]*b+bias[2], ppm.max),
+			}
+		}
+	}
+}
+
+// Decimate keeps every factorX-th column and every factorY-th row, starting
+// with the first ones, without any averaging. This is a fast, crude downscale
+// for previews or point sampling of sparse data. Factors below 1 leave the
+// image unchanged.
+func (ppm *PPM) Decimate(factorX, factorY int) {
+	if factorX < 1 || factorY < 1 {
+		return
+	}
+
+	width := (ppm.width + factorX - 1) / factorX
+	height := (ppm.height + factorY - 1) / factorY
+	data := make([][]Pixel, height)
+	for y := range data {
+		data[y] = make([]Pixel, width)
+		for x := range data[y] {
+			data[y][x] = ppm.data[y*factorY][x*factorX]
+		}
+	}
+	ppm.data, ppm.width, ppm.height = data, width, height
+}
+
+// HideMessage stores msg in the least significant bits of the pixel channels,
+// one bit per channel in row-major R, G, B order, most significant bit of each
+// byte first. The change is invisible to the eye and survives saving in any
+// PPM format. It returns an error if the message needs more bits than the
+// image has channels, or if the max value is 0 and leaves no room for a set
+// bit; the length is not stored, so the reader must know it.
+func (ppm *PPM) HideMessage(msg []byte) error {
+	if ppm.max < 1 {
+		return fmt.Errorf("cannot hide a message in an image with max value %d", ppm.max)
+	}
+	capacity := ppm.width * ppm.height * 3 / 8
+	if len(msg) > capacity {
+		return fmt.Errorf("message too large: %d bytes, image holds %d", len(msg), capacity)
+	}
+
+	for i := 0; i < len(msg)*8; i++ {
+		bit := msg[i/8] >> (7 - i%8) & 1
+		channel := ppm.channel(i)
+		if bit == 1 {
+			*channel |= 1
+			if *channel > ppm.max {
+				// An even max value has no odd value above it, use the one below.
+				*channel -= 2
+			}
+		} else {
+			*channel &^= 1
+		}
+	}
+	return nil
+}
+
+// ExtractMessage returns the length bytes stored by HideMessage.
+func (ppm *PPM) ExtractMessage(length int) ([]byte, error) {
+	capacity := ppm.width * ppm.height * 3 / 8
+	if length < 0 || length > capacity {
+		return nil, fmt.Errorf("invalid message length: %d, image holds %d bytes", length, capacity)
+	}
+
+	msg := make([]byte, length)
+	for i := 0; i < length*8; i++ {
+		msg[i/8] |= (*ppm.channel(i) & 1) << (7 - i%8)
+	}
+	return msg, nil
+}
+
+// channel returns a pointer to the i-th channel of the image, counting the
+// R, G and B channels of each pixel in row-major order.
+func (ppm *PPM) channel(i int) *uint8 {
+	pixel := &ppm.data[i/3/ppm.width][i/3%ppm.width]
+	switch i % 3 {
+	case 0:
+		return &pixel.R
+	case 1:
+		return &pixel.G
+	default:
+		return &pixel.B
+	}
+}
+
+// RotateAngle rotates the image by the given angle in degrees around its
+// center, positive angles turning counter-clockwise on screen. The canvas is
+// enlarged to fit the whole rotated image and the newly exposed corners are
+// set to fill. Pixels are resampled with nearest neighbor, which is fast and
+// keeps the original values, at the cost of jagged edges.
+func (ppm *PPM) RotateAngle(degrees float64, fill Pixel) {
+	ppm.data, ppm.width, ppm.height = rotateExpanded(ppm.data, ppm.width, ppm.height, degrees, fill)
+}
+
+// Crop returns a new image holding a copy of the width x height rectangle whose
+// top-left corner is (x, y), with the same magic number, max value and
+// supersampling factor. It returns an error if the rectangle is empty or
+// extends beyond the image.
+func (ppm *PPM) Crop(x, y, width, height int) (*PPM, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid crop dimensions: %dx%d", width, height)
+	}
+	if x < 0 || y < 0 || x+width > ppm.width || y+height > ppm.height {
+		return nil, fmt.Errorf("crop rectangle (%d, %d) %dx%d out of bounds of %dx%d image", x, y, width, height, ppm.width, ppm.height)
+	}
+
+	return ppm.CropRect(Rect{Point{x, y}, Point{x + width, y + height}}), nil
+}
+
+// LineProfile returns the pixels along the line between two points, in order
+// from p1 to p2, following the same Bresenham line as DrawLine. Points outside
+// the image are skipped rather than clamped, so the profile only holds pixels
+// of the image and is shorter than the line where it leaves the image.
+func (ppm *PPM) LineProfile(p1, p2 Point) []Pixel {
+	return lineProfile(ppm.data, ppm.width, ppm.height, p1, p2)
+}
+
+// ContactSheet lays out thumbnails of the images in a grid of cols columns,
+// each thumbnail being thumbW x thumbH pixels, separated and surrounded by
+// padding pixels of the background color. The last row may be partial, its
+// empty cells left as background. Thumbnails are area-averaged in linear light,
+// so that shrinking does not darken fine detail, and scaled to the max value
+// of 255 used by the P6 sheet.
+func ContactSheet(imgs []*PPM, cols, thumbW, thumbH, padding int, bg Pixel) (*PPM, error) {
+	if len(imgs) == 0 {
+		return nil, errors.New("no images for the contact sheet")
+	}
+	if cols <= 0 || thumbW <= 0 || thumbH <= 0 || padding < 0 {
+		return nil, fmt.Errorf("invalid layout: %d columns of %dx%d thumbnails with padding %d", cols, thumbW, thumbH, padding)
+	}
+	for i, img := range imgs {
+		if img == nil || img.width <= 0 || img.height <= 0 {
+			return nil, fmt.Errorf("image %d is empty", i)
+		}
+	}
+
+	rows := (len(imgs) + cols - 1) / cols
+	cols = min(cols, len(imgs))
+	sheet, err := NewPPM(cols*thumbW+(cols+1)*padding, rows*thumbH+(rows+1)*padding, 255)
+	if err != nil {
+		return nil, err
+	}
+	sheet.fillRect(0, 0, sheet.width, sheet.height, bg)
+
+	for i, img := range imgs {
+		x := padding + (i%cols)*(thumbW+padding)
+		y := padding + (i/cols)*(thumbH+padding)
+		sheet.PasteRect(img.linearThumbnail(thumbW, thumbH), Rect{Point{x, y}, Point{x + thumbW, y + thumbH}})
+	}
+	return sheet, nil
+}
+
+// linearThumbnail returns a copy of the image resized to width x height with
+// area averaging in linear light, with a max value of 255.
+func (ppm *PPM) linearThumbnail(width, height int) *PPM {
+	columns := resampleWeights(ppm.width, width, Area)
+	rows := resampleWeights(ppm.height, height, Area)
+
+	horizontal := make([][][3]float64, ppm.height)
+	for y := 0; y < ppm.height; y++ {
+		horizontal[y] = make([][3]float64, width)
+		for x, c := range columns {
+			for k, w := range c.weights {
+				linear := ppm.linearAt(c.start+k, y)
+				for ch := range linear {
+					horizontal[y][x][ch] += w * linear[ch]
+				}
+			}
+		}
+	}
+
+	thumb := &PPM{
+		data:        make([][]Pixel, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P6",
+		max:         255,
+	}
+	for y, c := range rows {
+		thumb.data[y] = make([]Pixel, width)
+		for x := 0; x < width; x++ {
+			var sum [3]float64
+			for k, w := range c.weights {
+				for ch := range sum {
+					sum[ch] += w * horizontal[c.start+k][x][ch]
+				}
+			}
+			thumb.data[y][x] = Pixel{
+				R: clampSample(255*toSRGB(math.Max(sum[0], 0)), 255),
+				G: clampSample(255*toSRGB(math.Max(sum[1], 0)), 255),
+				B: clampSample(255*toSRGB(math.Max(sum[2], 0)), 255),
+			}
+		}
+	}
+	return thumb
+}
+
+// CropTo crops the image in place to the rectangle between two corners, both
+// included, which may be given in any order. It returns an error, leaving the
+// image unchanged, if the rectangle extends beyond the image.
+func (ppm *PPM) CropTo(topLeft, bottomRight Point) error {
+	x0, x1 := min(topLeft.X, bottomRight.X), max(topLeft.X, bottomRight.X)
+	y0, y1 := min(topLeft.Y, bottomRight.Y), max(topLeft.Y, bottomRight.Y)
+	cropped, err := ppm.Crop(x0, y0, x1-x0+1, y1-y0+1)
+	if err != nil {
+		return err
+	}
+	*ppm = *cropped
+	return nil
+}
+
+// IsUniform reports whether every pixel of the image has the same color, and
+// returns that color, or the zero color otherwise. It stops at the first differing
+// pixel, which makes it a cheap check for blank frames. An empty image is not
+// uniform.
+func (ppm *PPM) IsUniform() (bool, Pixel) {
+	var zero Pixel
+	if ppm.width <= 0 || ppm.height <= 0 {
+		return false, zero
+	}
+
+	first := ppm.data[0][0]
+	for y := 0; y < ppm.height; y++ {
+		for x := 0; x < ppm.width; x++ {
+			if ppm.data[y][x] != first {
+				return false, zero
+			}
+		}
+	}
+	return true, first
+}
+
+// Clone returns a deep copy of the image: modifying the copy does not affect
+// the original, unlike assigning the struct, which shares the pixel rows.
+func (ppm *PPM) Clone() *PPM {
+	clone := *ppm
+	clone.data = make([][]Pixel, len(ppm.data))
+	for y, row := range ppm.data {
+		clone.data[y] = append([]Pixel(nil), row...)
+	}
+	return &clone
+}
+
+// Equal reports whether both images have the same magic number, dimensions, max value
+// and pixels. It returns false if either image is nil.
+func (ppm *PPM) Equal(other *PPM) bool {
+	return ppm.EqualPixels(other) && ppm.magicNumber == other.magicNumber
+}
+
+// EqualPixels is like Equal but ignores the magic number, so that the ASCII
+// and binary forms of the same image compare equal.
+func (ppm *PPM) EqualPixels(other *PPM) bool {
+	if ppm == nil || other == nil {
+		return false
+	}
+	if ppm.width != other.width || ppm.height != other.height || ppm.max != other.max {
+		return false
+	}
+	for y := 0; y < ppm.height; y++ {
+		if !slices.Equal(ppm.data[y], other.data[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractROI returns the width x height region of interest centered at center
+// and rotated by angleDegrees, counter-clockwise on screen as for RotateAngle,
+// as an axis-aligned image: content tilted by that angle comes out upright.
+// Every output pixel is mapped back into the image and sampled bilinearly in
+// a single pass, without intermediate images. Pixels whose source falls
+// outside the image are black.
+func (ppm *PPM) ExtractROI(center Point, width, height int, angleDegrees float64) *PPM {
+	roi := &PPM{
+		data:        make([][]Pixel, max(height, 0)),
+		width:       max(width, 0),
+		height:      max(height, 0),
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+	}
+
+	sin, cos := math.Sincos(angleDegrees * math.Pi / 180)
+	cx, cy := float64(roi.width-1)/2, float64(roi.height-1)/2
+	for y := range roi.data {
+		roi.data[y] = make([]Pixel, roi.width)
+		for x := range roi.data[y] {
+			// The axes of the region are the image axes rotated by the angle.
+			du, dv := float64(x)-cx, float64(y)-cy
+			sx := float64(center.X) + du*cos + dv*sin
+			sy := float64(center.Y) - du*sin + dv*cos
+			if ppm.covers(sx, sy) {
+				roi.data[y][x] = ppm.AtBilinear(sx, sy)
+			}
+		}
+	}
+	return roi
+}
+
+// Flatten returns a copy of the samples in a single row-major slice of
+// width*height*3 values, R, G and B for each pixel in turn.
+func (ppm *PPM) Flatten() []uint8 {
+	flat := make([]uint8, 0, ppm.width*ppm.height*3)
+	for y := 0; y < ppm.height; y++ {
+		for _, pixel := range ppm.data[y] {
+			flat = append(flat, pixel.R, pixel.G, pixel.B)
+		}
+	}
+	return flat
+}
+
+// UnflattenPPM creates a P6 PPM with a max value of 255 from row-major RGB
+// samples, as returned by Flatten. It returns an error if the dimensions are
+// not positive or do not match the length of data.
+func UnflattenPPM(width, height int, data []uint8) (*PPM, error) {
+	ppm, err := NewPPM(width, height, 255)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != width*height*3 {
+		return nil, fmt.Errorf("data length %d does not match %dx%d image", len(data), width, height)
+	}
+	for y := range ppm.data {
+		for x := range ppm.data[y] {
+			i := (y*width + x) * 3
+			ppm.data[y][x] = Pixel{R: data[i], G: data[i+1], B: data[i+2]}
+		}
+	}
+	return ppm, nil
+}
+
+// Swap exchanges the pixel data of two same-sized images in constant time, by
+// swapping their rows rather than copying pixels. This supports the classic
+// front and back buffer pattern when rendering many animation frames. Only the
+// pixels are exchanged; the other attributes of each image are kept.
+func (ppm *PPM) Swap(other *PPM) error {
+	if other == nil {
+		return errors.New("cannot swap with a nil PPM")
+	}
+	if ppm.width != other.width || ppm.height != other.height {
+		return fmt.Errorf("size mismatch: %dx%d vs %dx%d", ppm.width, ppm.height, other.width, other.height)
+	}
+	ppm.data, other.data = other.data, ppm.data
+	return nil
+}
+
+// DrawLineAA draws an anti-aliased line between two points with Xiaolin Wu's
+// algorithm. Along the major axis, each step covers the two pixels straddling
+// the exact line, each blended with its current value in proportion to its
+// coverage, so the line looks smooth instead of jagged. Since the endpoints lie
+// on pixel centers, they are drawn at full intensity.
+func (ppm *PPM) DrawLineAA(p1, p2 Point, color Pixel) {
+	x0, y0, x1, y1 := p1.X, p1.Y, p2.X, p2.Y
+	steep := abs(y1-y0) > abs(x1-x0)
+	if steep {
+		x0, y0, x1, y1 = y0, x0, y1, x1
+	}
+	if x0 > x1 {
+		x0, y0, x1, y1 = x1, y1, x0, y0
+	}
+
+	// plot blends the pixel at (x, y), in the possibly swapped coordinates.
+	plot := func(x, y int, coverage float64) {
+		if steep {
+			x, y = y, x
+		}
+		if x < 0 || x >= ppm.width || y < 0 || y >= ppm.height || coverage <= 0 {
+			return
+		}
+		bg := ppm.data[y][x]
+		ppm.data[y][x] = Pixel{
+			R: lerp(bg.R, color.R, coverage),
+			G: lerp(bg.G, color.G, coverage),
+			B: lerp(bg.B, color.B, coverage),
+		}
+	}
+
+	plot(x0, y0, 1)
+	if x1 == x0 {
+		return
+	}
+	plot(x1, y1, 1)
+
+	gradient := float64(y1-y0) / float64(x1-x0)
+	for x := x0 + 1; x < x1; x++ {
+		intery := float64(y0) + gradient*float64(x-x0)
+		ipart := math.Floor(intery)
+		fpart := intery - ipart
+		plot(x, int(ipart), 1-fpart)
+		plot(x, int(ipart)+1, fpart)
+	}
+}
+
+// DrawEllipse draws the outline of an axis-aligned ellipse with radii rx and ry
+// using the midpoint ellipse algorithm, which touches the four extreme points
+// center±rx and center±ry exactly. Non-positive radii draw nothing.
+func (ppm *PPM) DrawEllipse(center Point, rx, ry int, color Pixel) {
+	if rx <= 0 || ry <= 0 {
+		return
+	}
+
+	plot := func(x, y int) {
+		ppm.setPixel(center.X+x, center.Y+y, color)
+		ppm.setPixel(center.X-x, center.Y+y, color)
+		ppm.setPixel(center.X+x, center.Y-y, color)
+		ppm.setPixel(center.X-x, center.Y-y, color)
+	}
+
+	rx2, ry2 := float64(rx*rx), float64(ry*ry)
+	x, y := 0, ry
+	dx, dy := 0.0, 2*rx2*float64(y)
+
+	// Region 1, from the top, where the slope is below 1: step along x.
+	d := ry2 - rx2*float64(ry) + rx2/4
+	for dx < dy {
+		plot(x, y)
+		x++
+		dx += 2 * ry2
+		if d < 0 {
+			d += dx + ry2
+		} else {
+			y--
+			dy -= 2 * rx2
+			d += dx - dy + ry2
+		}
+	}
+
+	// Region 2, down to the side, where the slope is above 1: step along y.
+	fx, fy := float64(x)+0.5, float64(y-1)
+	d = ry2*fx*fx + rx2*fy*fy - rx2*ry2
+	for y >= 0 {
+		plot(x, y)
+		y--
+		dy -= 2 * rx2
+		if d > 0 {
+			d += rx2 - dy
+		} else {
+			x++
+			dx += 2 * ry2
+			d += dx - dy + rx2
+		}
+	}
+}
+
+// DrawFilledEllipse draws a filled axis-aligned ellipse with radii rx and ry,
+// one horizontal span per row computed from the ellipse equation. Non-positive
+// radii draw nothing.
+func (ppm *PPM) DrawFilledEllipse(center Point, rx, ry int, color Pixel) {
+	if rx <= 0 || ry <= 0 {
+		return
+	}
+
+	for dy := -ry; dy <= ry; dy++ {
+		t := float64(dy) / float64(ry)
+		half := int(float64(rx)*math.Sqrt(1-t*t) + 1e-9)
+		ppm.fillRect(center.X-half, center.Y+dy, 2*half+1, 1, color)
+	}
+}
+
+// AdjustBrightness adds delta to the R, G and B samples of every pixel,
+// saturating each channel at 0 and the max value instead of wrapping around,
+// so a negative delta darkens the image. Use AdjustBrightnessReport to learn
+// how many samples were clamped.
+func (ppm *PPM) AdjustBrightness(delta int) {
+	ppm.AdjustBrightnessReport(delta)
+}
+
+// AdjustBrightnessReport adds delta to the R, G and B samples of every pixel,
+// saturating each channel at 0 and the max value, and returns how many samples
+// were clamped at each end. A large count tells that the adjustment crushed
+// the shadows or blew out the highlights and should be backed off.
+func (ppm *PPM) AdjustBrightnessReport(delta int) (clampedLow, clampedHigh int) {
+	return ppm.adjustReport(func(v float64) float64 { return v + float64(delta) })
+}
+
+// AdjustContrast scales the distance of every sample from the middle of the
+// range [0, max] by factor, saturating at 0 and the max value, so that factors
+// above 1 increase the contrast and factors below 1 reduce it. Use
+// AdjustContrastReport to learn how many samples were clamped.
+func (ppm *PPM) AdjustContrast(factor float64) {
+	ppm.AdjustContrastReport(factor)
+}
+
+// AdjustContrastReport scales the distance of every sample from the middle of
+// the range [0, max] by factor, so that factors above 1 increase the contrast,
+// and returns how many samples were clamped at each end.
+func (ppm *PPM) AdjustContrastReport(factor float64) (clampedLow, clampedHigh int) {
+	mid := float64(ppm.max) / 2
+	return ppm.adjustReport(func(v float64) float64 { return (v-mid)*factor + mid })
+}
+
+// Multiply multiplies every sample by factor, saturating at 0 and the max
+// value. Use MultiplyReport to learn how many samples were clamped.
+func (ppm *PPM) Multiply(factor float64) {
+	ppm.MultiplyReport(factor)
+}
+
+// MultiplyReport multiplies every sample by factor and returns how many
+// samples were clamped at each end.
+func (ppm *PPM) MultiplyReport(factor float64) (clampedLow, clampedHigh int) {
+	return ppm.adjustReport(func(v float64) float64 { return v * factor })
+}
+
+// adjustReport replaces every sample v by f(v), rounded and clamped to
+// [0, max] per channel, counting the clamped samples.
+func (ppm *PPM) adjustReport(f func(float64) float64) (low, high int) {
+	for y := range ppm.data {
+		for x := range ppm.data[y] {
+			p := &ppm.data[y][x]
+			p.R = clampCount(f(float64(p.R)), ppm.max, &low, &high)
+			p.G = clampCount(f(float64(p.G)), ppm.max, &low, &high)
+			p.B = clampCount(f(float64(p.B)), ppm.max, &low, &high)
+		}
+	}
+	return low, high
+}
+
+// DrawQuadraticBezier draws the quadratic Bézier curve from p0 to p2 with
+// control point p1. The curve is drawn exactly as the cubic curve it is
+// equivalent to, see DrawCubicBezier.
+func (ppm *PPM) DrawQuadraticBezier(p0, p1, p2 Point, color Pixel) {
+	// Degree elevation: the inner cubic control points lie two thirds of the
+	// way from each end point to p1.
+	c1x, c1y := float64(p0.X)+2*float64(p1.X-p0.X)/3, float64(p0.Y)+2*float64(p1.Y-p0.Y)/3
+	c2x, c2y := float64(p2.X)+2*float64(p1.X-p2.X)/3, float64(p2.Y)+2*float64(p1.Y-p2.Y)/3
+	ppm.drawBezier(bezier{
+		{float64(p0.X), float64(p0.Y)}, {c1x, c1y}, {c2x, c2y}, {float64(p2.X), float64(p2.Y)},
+	}, color)
+}
+
+// DrawCubicBezier draws the cubic Bézier curve from p0 to p3 with control
+// points p1 and p2. The curve is split until every piece is within half a pixel
+// of a straight segment, so the number of pieces adapts to how far the control
+// points spread, and the pieces are joined with DrawLine, leaving no gaps. The
+// curve starts exactly at p0 and ends exactly at p3; when all control points
+// lie on the segment p0-p3 it draws the same pixels as DrawLine.
+func (ppm *PPM) DrawCubicBezier(p0, p1, p2, p3 Point, color Pixel) {
+	ppm.drawBezier(bezier{
+		{float64(p0.X), float64(p0.Y)}, {float64(p1.X), float64(p1.Y)},
+		{float64(p2.X), float64(p2.Y)}, {float64(p3.X), float64(p3.Y)},
+	}, color)
+}
+
+// bezier holds the four control points of a cubic Bézier curve as x, y pairs.
+type bezier [4][2]float64
+
+// drawBezier flattens the curve by de Casteljau subdivision at t = 0.5 and
+// draws each flat piece as a line between its rounded end points.
+func (ppm *PPM) drawBezier(curve bezier, color Pixel) {
+	// Deep enough for any curve that fits in memory; guards against NaNs.
+	const maxDepth = 24
+
+	round := func(p [2]float64) Point {
+		return Point{int(math.Round(p[0])), int(math.Round(p[1]))}
+	}
+
+	type piece struct {
+		curve bezier
+		depth int
+	}
+	stack := []piece{{curve, 0}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		c := p.curve
+		if p.depth == maxDepth || c.flat(0.5) {
+			ppm.DrawLine(round(c[0]), round(c[3]), color)
+			continue
+		}
+		left, right := c.split()
+		// Push the right half first so that the curve is drawn from start to end.
+		stack = append(stack, piece{right, p.depth + 1}, piece{left, p.depth + 1})
+	}
+}
+
+// flat reports whether both inner control points lie within tolerance of the
+// segment between the end points, which bounds the distance of the whole
+// curve from that segment.
+func (c bezier) flat(tolerance float64) bool {
+	for _, p := range c[1:3] {
+		dx, dy := c[3][0]-c[0][0], c[3][1]-c[0][1]
+		px, py := p[0]-c[0][0], p[1]-c[0][1]
+		t := 0.0
+		if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+			t = math.Max(0, math.Min(1, (px*dx+py*dy)/lengthSquared))
+		}
+		if math.Hypot(px-t*dx, py-t*dy) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// split divides the curve at t = 0.5 into two curves with de Casteljau's
+// algorithm.
+func (c bezier) split() (left, right bezier) {
+	mid := func(a, b [2]float64) [2]float64 {
+		return [2]float64{(a[0] + b[0]) / 2, (a[1] + b[1]) / 2}
+	}
+	ab, bc, cd := mid(c[0], c[1]), mid(c[1], c[2]), mid(c[2], c[3])
+	abc, bcd := mid(ab, bc), mid(bc, cd)
+	m := mid(abc, bcd)
+	return bezier{c[0], ab, abc, m}, bezier{m, bcd, cd, c[3]}
+}
@@ -0,0 +1,171 @@
+package Netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Pixel16 represents a PPM16 pixel with red, green and blue samples of up to 16 bits.
+type Pixel16 struct {
+	R, G, B uint16
+}
+
+// PPM16 represents a PPM image with samples of up to 16 bits, for max values
+// up to 65535. Binary (P6) files store such samples as two big-endian bytes
+// when the max value is above 255.
+type PPM16 struct {
+	data          [][]Pixel16 // 2D slice to store the pixel values.
+	width, height int         // Width and height of the image.
+	magicNumber   string      // Magic number indicating PPM format (P3 for ASCII, P6 for Binary).
+	max           uint16      // Maximum sample value.
+}
+
+// ReadPPM16 reads a PPM file of any depth, keeping its samples at full
+// precision, and returns a PPM16 struct and an error if any.
+func ReadPPM16(filename string) (*PPM16, error) {
+	return ReadPPM16Opts(filename, Options16{})
+}
+
+// ReadPPM16Opts reads a PPM file like ReadPPM16, with options to decode
+// non-standard files, such as 16-bit samples written in little-endian order.
+func ReadPPM16Opts(filename string, opts Options16) (*PPM16, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return decodePPM16(file, opts)
+}
+
+// DecodePPM16 reads a PPM image of any depth from r, keeping its samples at
+// full precision, and returns a PPM16 struct and an error if any.
+func DecodePPM16(r io.Reader) (*PPM16, error) {
+	return decodePPM16(r, Options16{})
+}
+
+// decodePPM16 implements DecodePPM16 and ReadPPM16Opts.
+func decodePPM16(r io.Reader, opts Options16) (*PPM16, error) {
+	reader := bufio.NewReader(r)
+	magicNumber, width, height, max, err := readPPMHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return readPPM16Data(reader, magicNumber, width, height, uint16(max), opts.Endianness)
+}
+
+// NewPPM16 creates a black P6 image of the given size and max value.
+// It returns an error if the width or height is not positive.
+func NewPPM16(width, height int, max uint16) (*PPM16, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", width, height)
+	}
+	ppm := &PPM16{
+		data:        make([][]Pixel16, height),
+		width:       width,
+		height:      height,
+		magicNumber: "P6",
+		max:         max,
+	}
+	for i := range ppm.data {
+		ppm.data[i] = make([]Pixel16, width)
+	}
+	return ppm, nil
+}
+
+// readPPM16Data reads the pixel data following a PPM header, with 2-byte
+// samples in the given byte order.
+func readPPM16Data(reader *bufio.Reader, magicNumber string, width, height int, max uint16, order Endianness) (*PPM16, error) {
+	plain := magicNumber == "P3"
+	reader, order, err := resolveEndianness(reader, plain, max, order, width*height*3)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]Pixel16, height)
+	samples := make([]uint16, width*3)
+	for y := range data {
+		err := readSamples16(reader, plain, max, order, samples)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pixel data at row %d: %v", y, err)
+		}
+		data[y] = make([]Pixel16, width)
+		for x := range data[y] {
+			data[y][x] = Pixel16{samples[x*3], samples[x*3+1], samples[x*3+2]}
+		}
+	}
+	return &PPM16{data, width, height, magicNumber, max}, nil
+}
+
+// Size returns the width and height of the PPM16 image.
+func (ppm *PPM16) Size() (int, int) {
+	return ppm.width, ppm.height
+}
+
+// At returns the pixel at (x, y).
+func (ppm *PPM16) At(x, y int) Pixel16 {
+	return ppm.data[y][x]
+}
+
+// Set sets the pixel at (x, y).
+func (ppm *PPM16) Set(x, y int, value Pixel16) {
+	ppm.data[y][x] = value
+}
+
+// Save saves the PPM16 image to a file, with two bytes per sample in binary
+// (P6) form when the max value is above 255.
+func (ppm *PPM16) Save(filename string) error {
+	if ppm.magicNumber != "P3" && ppm.magicNumber != "P6" {
+		return fmt.Errorf("unsupported magic number: %s", ppm.magicNumber)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	_, err = fmt.Fprintf(writer, "%s\n%d %d\n%d\n", ppm.magicNumber, ppm.width, ppm.height, ppm.max)
+	if err != nil {
+		return fmt.Errorf("error writing header: %v", err)
+	}
+	samples := make([]uint16, ppm.width*3)
+	for y, row := range ppm.data {
+		for x, pixel := range row {
+			samples[x*3], samples[x*3+1], samples[x*3+2] = pixel.R, pixel.G, pixel.B
+		}
+		err := writeSamples16(writer, ppm.magicNumber == "P3", ppm.max, samples)
+		if err != nil {
+			return fmt.Errorf("error writing pixel data at row %d: %v", y, err)
+		}
+	}
+	return writer.Flush()
+}
+
+// ToPPM converts the image to an 8-bit PPM with the same magic number. Samples
+// are scaled to a max value of 255 when the max value is above 255.
+func (ppm *PPM16) ToPPM() *PPM {
+	out := &PPM{
+		data:        make([][]Pixel, ppm.height),
+		width:       ppm.width,
+		height:      ppm.height,
+		magicNumber: ppm.magicNumber,
+		max:         uint8(min(ppm.max, 255)),
+	}
+	scale := func(v uint16) uint8 {
+		if ppm.max > 255 {
+			return scaleTo8(v, ppm.max)
+		}
+		return uint8(v)
+	}
+	for y := 0; y < ppm.height; y++ {
+		out.data[y] = make([]Pixel, ppm.width)
+		for x, p := range ppm.data[y] {
+			out.data[y][x] = Pixel{R: scale(p.R), G: scale(p.G), B: scale(p.B)}
+		}
+	}
+	return out
+}
@@ -0,0 +1,1648 @@
+package Netpbm
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testPPM returns a P6 PPM whose pixels all differ, so that transforms that
+// move pixels to the wrong place are detected.
+func testPPM(t testing.TB, width, height int) *PPM {
+	t.Helper()
+	ppm, err := NewPPM(width, height, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ppm.Set(x, y, Pixel{uint8(x), uint8(y), uint8(x*7 + y*13)})
+		}
+	}
+	return ppm
+}
+
+func TestPPMTransformsInto(t *testing.T) {
+	tests := []struct {
+		name     string
+		into     func(src, dst *PPM) error
+		inPlace  func(*PPM)
+		swapSize bool
+	}{
+		{"Rotate90CW", (*PPM).Rotate90CWInto, (*PPM).Rotate90CW, true},
+		{"Rotate90CCW", (*PPM).Rotate90CCWInto, (*PPM).Rotate90CCW, true},
+		{"Rotate180", (*PPM).Rotate180Into, (*PPM).Rotate180, false},
+		{"Transpose", (*PPM).TransposeInto, (*PPM).Transpose, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testPPM(t, 5, 3)
+			want := src.Clone()
+			tt.inPlace(want)
+
+			// Start from a destination of another size to exercise reshape.
+			dst := testPPM(t, 2, 7)
+			if err := tt.into(src, dst); err != nil {
+				t.Fatal(err)
+			}
+			if !dst.Equal(want) {
+				t.Errorf("got %v, want %v", dst.data, want.data)
+			}
+			if w, _ := dst.Size(); (w == 3) != tt.swapSize {
+				t.Errorf("got width %d", w)
+			}
+			if err := tt.into(src, src); err == nil {
+				t.Error("src as dst: got no error")
+			}
+			if err := tt.into(src, nil); err == nil {
+				t.Error("nil dst: got no error")
+			}
+		})
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	ppm := testPPM(t, 4, 2)
+	orig := ppm.Clone()
+	ppm.Transpose()
+	if w, h := ppm.Size(); w != 2 || h != 4 {
+		t.Fatalf("size: got %dx%d, want 2x4", w, h)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if ppm.At(y, x) != orig.At(x, y) {
+				t.Errorf("pixel (%d, %d) did not move to (%d, %d)", x, y, y, x)
+			}
+		}
+	}
+}
+
+func BenchmarkRotate90CW(b *testing.B) {
+	ppm := testPPM(b, 640, 480)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ppm.Rotate90CW()
+	}
+}
+
+func BenchmarkRotate90CWInto(b *testing.B) {
+	src, dst := testPPM(b, 640, 480), &PPM{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// Rotating back and forth between two buffers reuses both.
+		src.Rotate90CWInto(dst)
+		src, dst = dst, src
+	}
+}
+
+func TestSupersampledLineIsSmoother(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	intermediate := func(ppm *PPM) int {
+		return ppm.Count(func(p Pixel) bool { return p.R > 0 && p.R < 255 })
+	}
+
+	direct := NewSupersampled(20, 20, 1, 255)
+	direct.DrawLine(Point{0, 0}, Point{19, 7}, white)
+	direct.Downsample()
+	if n := intermediate(direct); n != 0 {
+		t.Errorf("direct line: got %d intermediate pixels, want 0", n)
+	}
+
+	const factor = 4
+	super := NewSupersampled(20, 20, factor, 255)
+	super.DrawLine(Point{0, 0}, Point{19*factor + factor - 1, 7*factor + factor - 1}, white)
+	super.Downsample()
+	if w, h := super.Size(); w != 20 || h != 20 {
+		t.Fatalf("downsampled size: got %dx%d, want 20x20", w, h)
+	}
+	if n := intermediate(super); n == 0 {
+		t.Error("supersampled line: got no intermediate gray values")
+	}
+}
+
+// unclippedLine draws the whole Bresenham line from p1 to p2, dropping the
+// pixels outside the image, as DrawLine did before it clipped lines.
+func unclippedLine(ppm *PPM, p1, p2 Point, color Pixel) {
+	bresenham(p1, p2, func(p Point) {
+		ppm.SetPixel(p, color)
+	})
+}
+
+func TestDrawLineClippingMatchesUnclipped(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	check := func(p1, p2 Point) {
+		t.Helper()
+		got, _ := NewPPM(10, 10, 255)
+		got.DrawLine(p1, p2, white)
+		want, _ := NewPPM(10, 10, 255)
+		unclippedLine(want, p1, p2, white)
+		if !got.Equal(want) {
+			t.Errorf("line %v-%v: got %v, want %v", p1, p2, got.data, want.data)
+		}
+	}
+
+	// A partly offscreen line that rounding the clipped end points used to shift.
+	check(Point{-7, 2}, Point{12, 9})
+	check(Point{-1000, -3}, Point{1000, 12})
+	for x1 := -14; x1 <= 23; x1 += 3 {
+		for y1 := -13; y1 <= 22; y1 += 5 {
+			for x2 := -12; x2 <= 21; x2 += 4 {
+				for y2 := -11; y2 <= 20; y2 += 3 {
+					check(Point{x1, y1}, Point{x2, y2})
+				}
+			}
+		}
+	}
+}
+
+func TestLinePixelsMatchesDrawLine(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	for _, line := range [][2]Point{{{1, 1}, {8, 5}}, {{-7, 2}, {12, 9}}, {{5, -20}, {2, 30}}, {{-5, -5}, {-1, 20}}} {
+		ppm, _ := NewPPM(10, 10, 255)
+		points := ppm.LinePixels(line[0], line[1])
+		ppm.DrawLine(line[0], line[1], white)
+
+		lit := map[Point]bool{}
+		for _, p := range points {
+			if ppm.At(p.X, p.Y) != white {
+				t.Errorf("line %v: LinePixels returned %v, which DrawLine did not set", line, p)
+			}
+			lit[p] = true
+		}
+		if n := ppm.Count(func(p Pixel) bool { return p == white }); n != len(lit) || n != len(points) {
+			t.Errorf("line %v: DrawLine set %d pixels, LinePixels returned %d", line, n, len(points))
+		}
+	}
+}
+
+// farOffscreen is a line that starts far outside a 640x480 image and only
+// crosses it at the end.
+var farOffscreen = [2]Point{{-1_000_000, -600_000}, {600, 400}}
+
+func BenchmarkDrawLineFarOffscreen(b *testing.B) {
+	ppm := testPPM(b, 640, 480)
+	for i := 0; i < b.N; i++ {
+		ppm.DrawLine(farOffscreen[0], farOffscreen[1], Pixel{255, 0, 0})
+	}
+}
+
+func BenchmarkDrawLineFarOffscreenUnclipped(b *testing.B) {
+	ppm := testPPM(b, 640, 480)
+	for i := 0; i < b.N; i++ {
+		unclippedLine(ppm, farOffscreen[0], farOffscreen[1], Pixel{255, 0, 0})
+	}
+}
+
+func TestDrawCardinalSpline(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	for _, tension := range []float64{-0.5, 0, 0.5, 1} {
+		// Collinear points, even unevenly spaced, give a straight line.
+		ppm, _ := NewPPM(20, 10, 255)
+		ppm.DrawCardinalSpline([]Point{{1, 5}, {4, 5}, {12, 5}, {18, 5}}, tension, white)
+		for x := 1; x <= 18; x++ {
+			if ppm.At(x, 5) != white {
+				t.Errorf("tension %v: pixel (%d, 5) not set", tension, x)
+			}
+		}
+		if n := ppm.Count(func(p Pixel) bool { return p == white }); n != 18 {
+			t.Errorf("tension %v: got %d pixels, want the 18 of the line", tension, n)
+		}
+
+		// The curve passes through every point.
+		points := []Point{{2, 8}, {6, 1}, {11, 7}, {17, 2}}
+		ppm, _ = NewPPM(20, 10, 255)
+		ppm.DrawCardinalSpline(points, tension, white)
+		for _, p := range points {
+			if ppm.At(p.X, p.Y) != white {
+				t.Errorf("tension %v: curve misses %v", tension, p)
+			}
+		}
+	}
+}
+
+func TestWarpAffineIdentity(t *testing.T) {
+	ppm := testPPM(t, 7, 5)
+	got := ppm.WarpAffine([6]float64{1, 0, 0, 0, 1, 0}, 7, 5, Pixel{1, 2, 3})
+	if !got.Equal(ppm) {
+		t.Errorf("got %v, want %v", got.data, ppm.data)
+	}
+
+	// A translation moves the pixels and uncovers the background.
+	moved := ppm.WarpAffine([6]float64{1, 0, 2, 0, 1, 1}, 7, 5, Pixel{1, 2, 3})
+	if got, want := moved.At(3, 2), ppm.At(1, 1); got != want {
+		t.Errorf("translated pixel (3, 2): got %v, want %v", got, want)
+	}
+	if got := moved.At(0, 0); got != (Pixel{1, 2, 3}) {
+		t.Errorf("uncovered pixel (0, 0): got %v, want the background", got)
+	}
+}
+
+// coordinatePPM returns an image whose red and green samples are four times
+// the x and y coordinates of each pixel, so that resampled pixels tell where
+// they were sampled from.
+func coordinatePPM(t *testing.T, width, height int) *PPM {
+	t.Helper()
+	ppm, err := NewPPM(width, height, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			ppm.Set(x, y, Pixel{uint8(4 * x), uint8(4 * y), 0})
+		}
+	}
+	return ppm
+}
+
+func TestWarpPerspectiveTrapezoid(t *testing.T) {
+	src := coordinatePPM(t, 64, 64)
+	trapezoid := [4]Point{{20, 10}, {44, 10}, {60, 50}, {4, 50}}
+	rectangle := [4]Point{{0, 0}, {39, 0}, {39, 39}, {0, 39}}
+	out := src.WarpPerspective(trapezoid, rectangle, 40, 40, Pixel{0, 0, 255})
+
+	for i, corner := range rectangle {
+		got, want := out.At(corner.X, corner.Y), src.At(trapezoid[i].X, trapezoid[i].Y)
+		if got != want {
+			t.Errorf("corner %v: got %v, want %v", corner, got, want)
+		}
+	}
+	for x := 0; x < 40; x++ {
+		// The top and bottom edges of the rectangle come from the horizontal
+		// top and bottom of the trapezoid.
+		if g := out.At(x, 0).G; g != 40 {
+			t.Errorf("top row at x=%d: sampled from y=%d, want 10", x, g/4)
+		}
+		if g := out.At(x, 39).G; g != 200 {
+			t.Errorf("bottom row at x=%d: sampled from y=%d, want 50", x, g/4)
+		}
+	}
+	for y := 0; y < 40; y++ {
+		// The left and right columns come from the slanted sides.
+		p, q := out.At(0, y), out.At(39, y)
+		sy := float64(p.G) / 4
+		if left := 20 - 16*(sy-10)/40; math.Abs(float64(p.R)/4-left) > 0.5 {
+			t.Errorf("left column at y=%d: sampled from (%v, %v), off the left side", y, float64(p.R)/4, sy)
+		}
+		sy = float64(q.G) / 4
+		if right := 44 + 16*(sy-10)/40; math.Abs(float64(q.R)/4-right) > 0.5 {
+			t.Errorf("right column at y=%d: sampled from (%v, %v), off the right side", y, float64(q.R)/4, sy)
+		}
+	}
+	if n := out.Count(func(p Pixel) bool { return p.B == 255 }); n != 0 {
+		t.Errorf("got %d background pixels, want the trapezoid to fill the rectangle", n)
+	}
+}
+
+func TestMotionBlurPreservesBrightness(t *testing.T) {
+	ppm, _ := NewPPM(9, 3, 255)
+	ppm.FillRect(Rect{Point{4, 0}, Point{5, 3}}, Pixel{250, 100, 50})
+	ppm.MotionBlur(5, 0)
+	for x := 2; x <= 6; x++ {
+		if got, want := ppm.At(x, 1), (Pixel{50, 20, 10}); got != want {
+			t.Errorf("pixel (%d, 1): got %v, want the line spread evenly as %v", x, got, want)
+		}
+	}
+	if got := ppm.At(1, 1); got != (Pixel{}) {
+		t.Errorf("pixel (1, 1): got %v, want black beyond the kernel", got)
+	}
+}
+
+func TestSolarizePerChannel(t *testing.T) {
+	ppm, _ := NewPPM(2, 1, 255)
+	ppm.Set(0, 0, Pixel{10, 128, 200})
+	ppm.Set(1, 0, Pixel{255, 127, 0})
+	ppm.Solarize(127)
+	if got, want := ppm.At(0, 0), (Pixel{10, 127, 55}); got != want {
+		t.Errorf("pixel 0: got %v, want %v", got, want)
+	}
+	if got, want := ppm.At(1, 0), (Pixel{0, 127, 0}); got != want {
+		t.Errorf("pixel 1: got %v, want %v", got, want)
+	}
+}
+
+func TestOilPaintReducesColors(t *testing.T) {
+	// A noisy texture with many distinct colors.
+	textured, _ := NewPPM(24, 24, 255)
+	seed := uint32(1)
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 24; x++ {
+			seed = seed*1664525 + 1013904223
+			v := uint8(seed >> 24)
+			textured.Set(x, y, Pixel{v, v / 2, 255 - v})
+		}
+	}
+	before := textured.DistinctColors()
+
+	painted := textured.Clone()
+	painted.OilPaint(2, 8)
+	if after := painted.DistinctColors(); after >= before {
+		t.Errorf("got %d distinct colors, want fewer than the %d of the texture", after, before)
+	}
+
+	for _, params := range [][2]int{{0, 8}, {2, 0}, {-1, 8}} {
+		unchanged := textured.Clone()
+		unchanged.OilPaint(params[0], params[1])
+		if !unchanged.Equal(textured) {
+			t.Errorf("OilPaint(%d, %d): got a changed image, want it left unchanged", params[0], params[1])
+		}
+	}
+}
+
+func TestFloodFill(t *testing.T) {
+	white, red, blue := Pixel{255, 255, 255}, Pixel{255, 0, 0}, Pixel{0, 0, 255}
+	// walled returns an image with white pixels at '#'.
+	walled := func(rows ...string) *PPM {
+		ppm, err := NewPPM(len(rows[0]), len(rows), 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y, row := range rows {
+			for x, c := range row {
+				if c == '#' {
+					ppm.Set(x, y, white)
+				}
+			}
+		}
+		return ppm
+	}
+	// The only gap in the wall, between rows 1 and 2, is diagonal.
+	ppm := walled(
+		"...#...",
+		"...#...",
+		"....#..",
+		"....#..",
+		"....#..",
+	)
+	count := func(color Pixel) int {
+		return ppm.Count(func(p Pixel) bool { return p == color })
+	}
+
+	ppm.FloodFill(Point{0, 0}, red)
+	if n := count(red); n != 18 {
+		t.Errorf("got %d red pixels, want the 18 left of the wall", n)
+	}
+	if ppm.At(6, 0) != (Pixel{}) || count(white) != 5 {
+		t.Error("the fill leaked through the diagonal gap or over the wall")
+	}
+
+	// Filling with the same color, or from outside the image, does nothing.
+	before := ppm.Clone()
+	ppm.FloodFill(Point{0, 0}, red)
+	ppm.FloodFill(Point{-1, 0}, blue)
+	ppm.FloodFill(Point{0, 5}, blue)
+	if !ppm.Equal(before) {
+		t.Error("got a changed image, want it left unchanged")
+	}
+
+	// A gap a pixel wide along an edge lets the fill through.
+	ppm = walled(
+		"...#...",
+		"...#...",
+		"....#..",
+		".......",
+		"....#..",
+	)
+	ppm.FloodFill(Point{0, 0}, blue)
+	if n := count(blue); n != 18+1+12 {
+		t.Errorf("through the gap: got %d blue pixels, want %d", n, 18+1+12)
+	}
+
+	// Large regions do not overflow the stack.
+	big, err := NewPPM(1000, 1000, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big.FloodFill(Point{500, 500}, red)
+	if n := big.Count(func(p Pixel) bool { return p == red }); n != 1000*1000 {
+		t.Errorf("got %d red pixels, want all %d", n, 1000*1000)
+	}
+}
+
+func TestFloodFillToleranceNoisyRegion(t *testing.T) {
+	// A slightly noisy gray region enclosed by a black wall.
+	ppm, _ := NewPPM(8, 6, 255)
+	for y := 1; y < 5; y++ {
+		for x := 1; x < 7; x++ {
+			n := uint8((x*3 + y*5) % 7)
+			ppm.Set(x, y, Pixel{120 + n, 124 - n, 118 + n/2})
+		}
+	}
+	red := Pixel{255, 0, 0}
+
+	strict := ppm.Clone()
+	strict.FloodFillTolerance(Point{3, 2}, red, 0)
+	if n := strict.Count(func(p Pixel) bool { return p == red }); n >= 24 {
+		t.Errorf("tolerance 0: filled %d pixels, want only the exact matches", n)
+	}
+
+	ppm.FloodFillTolerance(Point{3, 2}, red, 8)
+	if n := ppm.Count(func(p Pixel) bool { return p == red }); n != 24 {
+		t.Errorf("tolerance 8: filled %d pixels, want the whole 6x4 region", n)
+	}
+	if n := ppm.Count(func(p Pixel) bool { return p == Pixel{} }); n != 48-24 {
+		t.Errorf("tolerance 8: got %d wall pixels, want the wall untouched", n)
+	}
+}
+
+func TestSimplifyPath(t *testing.T) {
+	var line []Point
+	for i := 0; i <= 50; i++ {
+		line = append(line, Point{2 * i, i + 3})
+	}
+	if got, want := SimplifyPath(line, 0.5), []Point{{0, 3}, {100, 53}}; !slices.Equal(got, want) {
+		t.Errorf("collinear points: got %v, want %v", got, want)
+	}
+
+	// A corner is kept, and points within epsilon of the simplified path go.
+	path := []Point{{0, 0}, {5, 1}, {10, 0}, {10, 10}}
+	if got, want := SimplifyPath(path, 1.5), []Point{{0, 0}, {10, 0}, {10, 10}}; !slices.Equal(got, want) {
+		t.Errorf("epsilon 1.5: got %v, want %v", got, want)
+	}
+	if got := SimplifyPath(path, 0.5); !slices.Equal(got, path) {
+		t.Errorf("epsilon 0.5: got %v, want every point kept", got)
+	}
+}
+
+func TestDrawFilledRectanglePattern(t *testing.T) {
+	a, b, c, d := Pixel{255, 0, 0}, Pixel{0, 255, 0}, Pixel{0, 0, 255}, Pixel{255, 255, 255}
+	pattern, err := NewPPM(2, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern.Set(0, 0, a)
+	pattern.Set(1, 0, b)
+	pattern.Set(0, 1, c)
+	pattern.Set(1, 1, d)
+	tile := [2][2]Pixel{{a, b}, {c, d}}
+
+	tests := []struct {
+		name          string
+		p1            Point
+		width, height int
+	}{
+		{"inside, odd offset", Point{3, 1}, 5, 4},
+		{"clipped top-left", Point{-3, -1}, 6, 5},
+		{"clipped bottom-right", Point{7, 6}, 10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ppm := testPPM(t, 10, 8)
+			orig := ppm.Clone()
+			ppm.DrawFilledRectanglePattern(tt.p1, tt.width, tt.height, pattern)
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 10; x++ {
+					want := orig.At(x, y)
+					dx, dy := x-tt.p1.X, y-tt.p1.Y
+					if dx >= 0 && dx < tt.width && dy >= 0 && dy < tt.height {
+						want = tile[dy%2][dx%2]
+					}
+					if got := ppm.At(x, y); got != want {
+						t.Errorf("(%d, %d): got %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReplaceHueRange(t *testing.T) {
+	gray, green, blue := Pixel{128, 128, 128}, Pixel{0, 200, 0}, Pixel{0, 0, 255}
+	// A square of shaded reds on either side of hue 0, on a gray background,
+	// next to a green square.
+	reds := []Pixel{{255, 0, 0}, {200, 0, 30}, {120, 20, 0}, {60, 0, 0}}
+	setup := func(t *testing.T) *PPM {
+		ppm, err := NewPPM(8, 4, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ppm.DrawFilledRectangle(Point{0, 0}, 8, 4, gray)
+		for i, red := range reds {
+			ppm.Set(1+i%2, 1+i/2, red)
+		}
+		ppm.Set(5, 1, green)
+		ppm.Set(6, 2, Pixel{255, 170, 0}) // Orange, hue 40.
+		return ppm
+	}
+	check := func(t *testing.T, ppm *PPM, want func(i int, red Pixel) Pixel) {
+		t.Helper()
+		orig := setup(t)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 8; x++ {
+				if (x == 1 || x == 2) && (y == 1 || y == 2) {
+					continue
+				}
+				if got := ppm.At(x, y); got != orig.At(x, y) {
+					t.Errorf("(%d, %d): got %v, want it unchanged at %v", x, y, got, orig.At(x, y))
+				}
+			}
+		}
+		for i, red := range reds {
+			if got := ppm.At(1+i%2, 1+i/2); got != want(i, red) {
+				t.Errorf("red %v: got %v, want %v", red, got, want(i, red))
+			}
+		}
+	}
+
+	t.Run("solid", func(t *testing.T) {
+		ppm := setup(t)
+		ppm.ReplaceHueRange(330, 30, blue, false)
+		check(t, ppm, func(int, Pixel) Pixel { return blue })
+	})
+	t.Run("keep luminance", func(t *testing.T) {
+		ppm := setup(t)
+		ppm.ReplaceHueRange(-30, 390, blue, true) // Normalized to 330 to 30.
+		check(t, ppm, func(_ int, red Pixel) Pixel {
+			return Pixel{0, 0, max(red.R, red.G, red.B)}
+		})
+	})
+}
+
+func TestAverageAndDominantColor(t *testing.T) {
+	teal := Pixel{0, 128, 128}
+	ppm, err := NewPPM(10, 10, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawFilledRectangle(Point{0, 0}, 10, 10, teal)
+	// Four outliers, two of them the same color, in 100 pixels.
+	ppm.Set(0, 0, Pixel{255, 255, 255})
+	ppm.Set(9, 9, Pixel{255, 255, 255})
+	ppm.Set(3, 4, Pixel{200, 0, 0})
+	ppm.Set(7, 1, Pixel{0, 0, 0})
+
+	if got := ppm.DominantColor(); got != teal {
+		t.Errorf("DominantColor: got %v, want %v", got, teal)
+	}
+	// R: (2*255 + 200) / 100, G: (96*128 + 2*255) / 100, B: (96*128 + 2*255) / 100.
+	if got, want := ppm.AverageColor(), (Pixel{7, 128, 128}); got != want {
+		t.Errorf("AverageColor: got %v, want %v", got, want)
+	}
+
+	empty := &PPM{}
+	if got := empty.AverageColor(); got != (Pixel{}) {
+		t.Errorf("AverageColor of an empty image: got %v", got)
+	}
+}
+
+func TestToPGMLuminosityFixedPoint(t *testing.T) {
+	// The fixed-point weights stay within 1 of the exact luminosity for
+	// every 8-bit color.
+	for r := 0; r < 256; r++ {
+		for g := 0; g < 256; g++ {
+			for b := 0; b < 256; b++ {
+				pixel := Pixel{uint8(r), uint8(g), uint8(b)}
+				fixed, exact := int(rgbToGray(pixel)), int(rgbToGrayExact(pixel))
+				if fixed < exact-1 || fixed > exact+1 {
+					t.Fatalf("%v: got %d, want %d ± 1", pixel, fixed, exact)
+				}
+			}
+		}
+	}
+
+	ppm := testPPM(t, 64, 48)
+	fixed, exact := ppm.ToPGMLuminosity(false), ppm.ToPGMLuminosity(true)
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			if d := int(fixed.At(x, y)) - int(exact.At(x, y)); d < -1 || d > 1 {
+				t.Errorf("(%d, %d): fixed point %d, exact %d", x, y, fixed.At(x, y), exact.At(x, y))
+			}
+		}
+	}
+}
+
+func BenchmarkToPGMLuminosity(b *testing.B) {
+	ppm := testPPM(b, 1920, 1080)
+	for _, exact := range []bool{false, true} {
+		name := "fixed"
+		if exact {
+			name = "exact"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ppm.ToPGMLuminosity(exact)
+			}
+		})
+	}
+}
+
+func TestAtBilinear(t *testing.T) {
+	ppm := testPPM(t, 5, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			if got, want := ppm.AtBilinear(float64(x), float64(y)), ppm.At(x, y); got != want {
+				t.Errorf("(%d, %d): got %v, want the stored %v", x, y, got, want)
+			}
+		}
+	}
+
+	coords := coordinatePPM(t, 5, 4)
+	tests := []struct {
+		x, y float64
+		want Pixel
+	}{
+		{1.5, 2, Pixel{6, 8, 0}},
+		{2.25, 0.5, Pixel{9, 2, 0}},
+		{-3, -1, Pixel{0, 0, 0}},    // Clamped to the top-left corner.
+		{10, 2.5, Pixel{16, 10, 0}}, // Clamped to the right edge.
+	}
+	for _, tt := range tests {
+		if got := coords.AtBilinear(tt.x, tt.y); got != tt.want {
+			t.Errorf("(%g, %g): got %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	for _, empty := range []*PPM{{}, {width: 3, data: [][]Pixel{}}} {
+		if got := empty.AtBilinear(0, 0); got != (Pixel{}) {
+			t.Errorf("empty %dx%d image: got %v, want the zero Pixel", empty.width, empty.height, got)
+		}
+	}
+}
+
+func TestColorMatrix(t *testing.T) {
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	ppm := testPPM(t, 16, 16)
+	ppm.ColorMatrix(identity, [3]float64{})
+	if !ppm.Equal(testPPM(t, 16, 16)) {
+		t.Error("the identity matrix changed the image")
+	}
+
+	tests := []struct {
+		name string
+		m    [3][3]float64
+		bias [3]float64
+		in   Pixel
+		want Pixel
+	}{
+		{"swap red and blue", [3][3]float64{{0, 0, 1}, {0, 1, 0}, {1, 0, 0}}, [3]float64{}, Pixel{10, 20, 30}, Pixel{30, 20, 10}},
+		{"bias", identity, [3]float64{5, -5, 0.4}, Pixel{10, 20, 30}, Pixel{15, 15, 30}},
+		{"clamped", [3][3]float64{{2, 0, 0}, {0, -1, 0}, {0, 0, 1}}, [3]float64{}, Pixel{200, 20, 30}, Pixel{255, 0, 30}},
+	}
+	for _, tt := range tests {
+		ppm, err := NewPPM(1, 1, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ppm.Set(0, 0, tt.in)
+		ppm.ColorMatrix(tt.m, tt.bias)
+		if got := ppm.At(0, 0); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAtSafeSetSafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		x, y    int
+		wantErr bool
+	}{
+		{"origin", 0, 0, false},
+		{"interior", 2, 1, false},
+		{"last pixel", 3, 2, false},
+		{"negative x", -1, 1, true},
+		{"negative y", 1, -1, true},
+		{"x equal to width", 4, 0, true},
+		{"y equal to height", 0, 3, true},
+		{"both far out", 100, -100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ppm := testPPM(t, 4, 3)
+			color := Pixel{1, 2, 3}
+			err := ppm.SetSafe(tt.x, tt.y, color)
+			got, atErr := ppm.AtSafe(tt.x, tt.y)
+			if !tt.wantErr {
+				if err != nil || atErr != nil {
+					t.Fatalf("got errors %v and %v", err, atErr)
+				}
+				if got != color {
+					t.Errorf("AtSafe: got %v, want the value set %v", got, color)
+				}
+				return
+			}
+
+			for _, err := range []error{err, atErr} {
+				want := fmt.Sprintf("(%d, %d) outside 4x3 image", tt.x, tt.y)
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want one containing %q", err, want)
+				}
+			}
+			if !ppm.Equal(testPPM(t, 4, 3)) {
+				t.Error("SetSafe out of bounds changed the image")
+			}
+		})
+	}
+}
+
+func TestDecodePPMHeaderWhitespace(t *testing.T) {
+	// Four pixels whose bytes would be misread if the header consumed too
+	// much or too little; 0x0a and 0x20 are whitespace themselves.
+	pixels := "\x0a\x20\x09" + "\x01\x02\x03" + "\xff\x00\x7f" + "\x20\x20\x0a"
+	want := []Pixel{{10, 32, 9}, {1, 2, 3}, {255, 0, 127}, {32, 32, 10}}
+
+	headers := []string{
+		"P6 2 2 255\n",
+		"P6\n2 2\n255\n",
+		"P6\n2\n2\n255\n",
+		"P6\t2\t2\t255\n",
+		"P6 2\r\n 2 255\n",
+		"P6\n# comment\n2 2 # another\n255\n",
+	}
+	for _, header := range headers {
+		ppm, err := DecodePPM(strings.NewReader(header + pixels))
+		if err != nil {
+			t.Errorf("%q: %v", header, err)
+			continue
+		}
+		got := slices.Concat(ppm.data...)
+		if w, h := ppm.Size(); w != 2 || h != 2 || ppm.max != 255 || !slices.Equal(got, want) {
+			t.Errorf("%q: got %dx%d max %d %v, want 2x2 max 255 %v", header, w, h, ppm.max, got, want)
+		}
+	}
+
+	ppm, err := DecodePPM(strings.NewReader("P3 1 1 15 1 2\t3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ppm.At(0, 0); got != (Pixel{1, 2, 3}) || ppm.max != 15 {
+		t.Errorf("P3 on one line: got %v max %d, want {1 2 3} max 15", got, ppm.max)
+	}
+}
+
+func TestHideMessage(t *testing.T) {
+	msg := []byte("meet at noon")
+	for _, maxValue := range []uint8{255, 100, 1} {
+		ppm, err := NewPPM(8, 5, maxValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 8; x++ {
+				v := uint8((x*31 + y*17) % (int(maxValue) + 1))
+				ppm.Set(x, y, Pixel{v, maxValue - v, v / 2})
+			}
+		}
+		orig := ppm.Clone()
+
+		if err := ppm.HideMessage(msg); err != nil {
+			t.Fatalf("max %d: %v", maxValue, err)
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 8; x++ {
+				for i, v := range [3][2]uint8{
+					{ppm.At(x, y).R, orig.At(x, y).R},
+					{ppm.At(x, y).G, orig.At(x, y).G},
+					{ppm.At(x, y).B, orig.At(x, y).B},
+				} {
+					if v[0] > maxValue || int(v[0]) < int(v[1])-2 || int(v[0]) > int(v[1])+1 {
+						t.Errorf("max %d, (%d, %d) channel %d: %d changed too much from %d", maxValue, x, y, i, v[0], v[1])
+					}
+				}
+			}
+		}
+
+		// The message survives saving and reading back.
+		filename := filepath.Join(t.TempDir(), "image.ppm")
+		if err := ppm.SaveBinary(filename); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadPPM(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := read.ExtractMessage(len(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(msg) {
+			t.Errorf("max %d: got %q, want %q", maxValue, got, msg)
+		}
+	}
+
+	ppm := testPPM(t, 8, 5) // 120 channels hold 15 bytes.
+	if err := ppm.HideMessage(make([]byte, 16)); err == nil {
+		t.Error("a message too large was accepted")
+	}
+	if _, err := ppm.ExtractMessage(16); err == nil {
+		t.Error("extracting more than the capacity was accepted")
+	}
+
+	black, err := NewPPM(8, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blank := black.Clone()
+	if err := black.HideMessage([]byte("x")); err == nil {
+		t.Error("an image with max value 0 was accepted")
+	}
+	if !black.Equal(blank) {
+		t.Error("a rejected message changed the image")
+	}
+}
+
+func TestRotateAngleFillPixel(t *testing.T) {
+	fill := Pixel{1, 2, 3}
+	ppm := testPPM(t, 6, 6)
+	ppm.RotateAngle(30, fill)
+	if w, h := ppm.Size(); w != 9 || h != 9 {
+		t.Fatalf("got size %dx%d, want 9x9", w, h)
+	}
+	if got := ppm.At(0, 0); got != fill {
+		t.Errorf("corner: got %v, want the fill %v", got, fill)
+	}
+}
+
+func TestLinePixels(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	center := Point{8, 8}
+	// A fan of lines in every octant, a single point, and lines leaving the image.
+	var ends []Point
+	for i := -12; i <= 12; i += 3 {
+		ends = append(ends, Point{center.X + i, -4}, Point{center.X + i, 20}, Point{-4, center.Y + i}, Point{20, center.Y + i})
+	}
+	ends = append(ends, center, Point{12, 3})
+
+	for _, end := range ends {
+		points := (&PPM{width: 17, height: 17}).LinePixels(center, end)
+
+		before := testPPM(t, 17, 17)
+		after := testPPM(t, 17, 17)
+		after.DrawLine(center, end, white)
+		var changed []Point
+		for y := 0; y < 17; y++ {
+			for x := 0; x < 17; x++ {
+				if after.At(x, y) != before.At(x, y) {
+					changed = append(changed, Point{x, y})
+				}
+			}
+		}
+
+		sorted := slices.SortedFunc(slices.Values(points), func(a, b Point) int {
+			return cmp.Or(a.Y-b.Y, a.X-b.X)
+		})
+		if !slices.Equal(sorted, changed) {
+			t.Errorf("line to %v: LinePixels %v, DrawLine changed %v", end, points, changed)
+			continue
+		}
+		// The pixels run from center to end, each one a neighbor of the previous.
+		if points[0] != center {
+			t.Errorf("line to %v: starts at %v", end, points[0])
+		}
+		for i := 1; i < len(points); i++ {
+			dx, dy := points[i].X-points[i-1].X, points[i].Y-points[i-1].Y
+			if max(dx, -dx, dy, -dy) != 1 {
+				t.Errorf("line to %v: %v does not follow %v", end, points[i], points[i-1])
+			}
+		}
+	}
+}
+
+func TestPPMResize(t *testing.T) {
+	ppm, err := NewPPM(2, 2, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.SetMagicNumber("P3")
+	ppm.Set(0, 0, Pixel{0, 240, 7})
+	ppm.Set(1, 0, Pixel{80, 160, 7})
+	ppm.Set(0, 1, Pixel{160, 80, 7})
+	ppm.Set(1, 1, Pixel{240, 0, 7})
+	nearest := ppm.Clone()
+
+	ppm.Resize(4, 4, Bilinear)
+	for y, row := range [][]uint8{{0, 20, 60, 80}, {40, 60, 100, 120}, {120, 140, 180, 200}, {160, 180, 220, 240}} {
+		for x, v := range row {
+			if got, want := ppm.At(x, y), (Pixel{v, 240 - v, 7}); got != want {
+				t.Errorf("bilinear (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if ppm.magicNumber != "P3" || ppm.max != 250 {
+		t.Errorf("got %s max %d, want P3 max 250", ppm.magicNumber, ppm.max)
+	}
+
+	nearest.Resize(3, 1, NearestNeighbor)
+	// The single row samples the center of the image, on the second row.
+	if got, want := nearest.data[0], []Pixel{{160, 80, 7}, {240, 0, 7}, {240, 0, 7}}; !slices.Equal(got, want) {
+		t.Errorf("nearest: got %v, want %v", got, want)
+	}
+}
+
+func TestPPMCrop(t *testing.T) {
+	ppm := coordinatePPM(t, 5, 4)
+	got, err := ppm.Crop(3, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]Pixel{{{12, 4, 0}, {16, 4, 0}}, {{12, 8, 0}, {16, 8, 0}}, {{12, 12, 0}, {16, 12, 0}}}
+	if !slices.EqualFunc(got.data, want, slices.Equal) || got.magicNumber != ppm.magicNumber || got.max != ppm.max {
+		t.Errorf("got %s max %d %v, want %s max %d %v", got.magicNumber, got.max, got.data, ppm.magicNumber, ppm.max, want)
+	}
+	got.Set(0, 0, Pixel{})
+	if ppm.At(3, 1) == (Pixel{}) {
+		t.Error("changing the crop changed the original")
+	}
+	if _, err := ppm.Crop(4, 0, 2, 1); err == nil {
+		t.Error("a rectangle out of bounds was accepted")
+	}
+}
+
+func TestPPMLineProfile(t *testing.T) {
+	ppm := coordinatePPM(t, 10, 6)
+	gray := ppm.ToPGMLuminosity(true)
+	for _, line := range [][2]Point{{{0, 0}, {9, 5}}, {{-4, 7}, {12, -3}}, {{8, 1}, {1, 4}}} {
+		got := ppm.LineProfile(line[0], line[1])
+		points := ppm.LinePixels(line[0], line[1])
+		if len(got) != len(points) {
+			t.Fatalf("line %v: got %d pixels, want the %d of LinePixels", line, len(got), len(points))
+		}
+		for i, p := range points {
+			if got[i] != ppm.At(p.X, p.Y) {
+				t.Errorf("line %v: pixel %d is %v, want %v at %v", line, i, got[i], ppm.At(p.X, p.Y), p)
+			}
+		}
+
+		// The PGM profile follows the same pixels.
+		grays := gray.LineProfile(line[0], line[1])
+		for i, p := range points {
+			if i >= len(grays) || grays[i] != gray.At(p.X, p.Y) {
+				t.Errorf("line %v: the PGM profile %v does not follow the same pixels", line, grays)
+				break
+			}
+		}
+	}
+}
+
+func TestCropTo(t *testing.T) {
+	ppm := coordinatePPM(t, 6, 5)
+	// Corners in any order give the same rectangle, both included.
+	for _, corners := range [][2]Point{{{1, 2}, {3, 4}}, {{3, 4}, {1, 2}}, {{1, 4}, {3, 2}}} {
+		got := ppm.Clone()
+		if err := got.CropTo(corners[0], corners[1]); err != nil {
+			t.Fatalf("corners %v: %v", corners, err)
+		}
+		want, err := ppm.Crop(1, 2, 3, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("corners %v: got %v, want %v", corners, got.data, want.data)
+		}
+	}
+
+	orig := ppm.Clone()
+	if err := ppm.CropTo(Point{4, 0}, Point{6, 2}); err == nil {
+		t.Error("a rectangle out of bounds was accepted")
+	}
+	if !ppm.Equal(orig) {
+		t.Error("a rejected crop changed the image")
+	}
+
+	// A supersampled canvas stays one, so it can still be downsampled.
+	canvas := NewSupersampled(4, 4, 2, 255)
+	canvas.DrawFilledRectangle(Point{0, 0}, 4, 4, Pixel{200, 100, 0})
+	if err := canvas.CropTo(Point{0, 0}, Point{3, 5}); err != nil {
+		t.Fatal(err)
+	}
+	canvas.Downsample()
+	if w, h := canvas.Size(); w != 2 || h != 3 {
+		t.Errorf("downsampled crop: got size %dx%d, want 2x3", w, h)
+	}
+	if got := canvas.At(0, 0); got != (Pixel{200, 100, 0}) {
+		t.Errorf("downsampled crop: got %v at (0, 0), want {200 100 0}", got)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	base := testPPM(t, 4, 3)
+	binary := base.Clone()
+	binary.SetMagicNumber("P6")
+	ascii := base.Clone()
+	ascii.SetMagicNumber("P3")
+	onePixel := binary.Clone()
+	onePixel.Set(3, 2, Pixel{0, 0, 0})
+	otherMax := binary.Clone()
+	otherMax.max = 254
+
+	tests := []struct {
+		name              string
+		a, b              *PPM
+		equal, equalPixel bool
+	}{
+		{"same pixels", binary, binary.Clone(), true, true},
+		{"P3 and P6", binary, ascii, false, true},
+		{"one pixel differs", binary, onePixel, false, false},
+		{"wider", binary, testPPM(t, 5, 3), false, false},
+		{"taller", binary, testPPM(t, 4, 4), false, false},
+		{"max value differs", binary, otherMax, false, false},
+		{"nil argument", binary, nil, false, false},
+		{"nil receiver", nil, binary, false, false},
+		{"both nil", nil, nil, false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Equal(tt.b); got != tt.equal {
+			t.Errorf("%s: Equal got %v, want %v", tt.name, got, tt.equal)
+		}
+		if got := tt.a.EqualPixels(tt.b); got != tt.equalPixel {
+			t.Errorf("%s: EqualPixels got %v, want %v", tt.name, got, tt.equalPixel)
+		}
+	}
+
+	pgm, pgmASCII := testPGM(t, 3, 3), testPGM(t, 3, 3)
+	pgmASCII.SetMagicNumber("P2")
+	if pgm.Equal(pgmASCII) || !pgm.EqualPixels(pgmASCII) || (*PGM)(nil).Equal(pgm) || pgm.EqualPixels(nil) {
+		t.Error("PGM: wrong comparison of P5 and P2 or nil images")
+	}
+	pbm, pbmOther := pbmFromRows("#.", ".#"), pbmFromRows("#.", "##")
+	if pbm.Equal(pbmOther) || pbm.EqualPixels(pbmOther) || !pbm.Equal(pbm.Clone()) || (*PBM)(nil).EqualPixels(pbm) {
+		t.Error("PBM: wrong comparison of differing or nil images")
+	}
+}
+
+func TestExtractROI(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	// A square of side 20 rotated by 45° around (20, 20).
+	ppm, err := NewPPM(41, 41, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawFilledPolygon([]Point{{34, 20}, {20, 6}, {6, 20}, {20, 34}}, white)
+
+	filled := func(img *PPM) int {
+		return img.Count(func(p Pixel) bool { return p == white })
+	}
+	roi := ppm.ExtractROI(Point{20, 20}, 18, 18, 45)
+	if w, h := roi.Size(); w != 18 || h != 18 {
+		t.Fatalf("got size %dx%d, want 18x18", w, h)
+	}
+	if n := filled(roi); n != 18*18 {
+		t.Errorf("45° ROI inside the square: got %d white pixels, want all %d", n, 18*18)
+	}
+	// Upright, the same window takes in the black corners around the diamond.
+	if n := filled(ppm.ExtractROI(Point{20, 20}, 18, 18, 0)); n >= 18*18-20 {
+		t.Errorf("upright ROI: got %d white pixels, want the corners black", n)
+	}
+
+	// A bar tilted by 30° counter-clockwise comes out upright, but not when
+	// extracted the other way.
+	bar, err := NewPPM(81, 81, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sin, cos := math.Sincos(30 * math.Pi / 180)
+	corner := func(su, sv float64) Point {
+		return Point{int(math.Round(40 + su*20*cos + sv*4*sin)), int(math.Round(40 - su*20*sin + sv*4*cos))}
+	}
+	bar.DrawFilledPolygon([]Point{corner(-1, -1), corner(1, -1), corner(1, 1), corner(-1, 1)}, white)
+	if n := filled(bar.ExtractROI(Point{40, 40}, 30, 5, 30)); n != 30*5 {
+		t.Errorf("30° ROI along the bar: got %d white pixels, want all %d", n, 30*5)
+	}
+	if n := filled(bar.ExtractROI(Point{40, 40}, 30, 5, -30)); n >= 30*5/2 {
+		t.Errorf("-30° ROI across the bar: got %d white pixels, want less than half", n)
+	}
+
+	// Pixels mapped outside the image are black.
+	edge := testPPM(t, 10, 10).ExtractROI(Point{0, 0}, 7, 7, 0)
+	if got := edge.At(0, 0); got != (Pixel{}) {
+		t.Errorf("outside the image: got %v, want black", got)
+	}
+	if got, want := edge.At(6, 6), testPPM(t, 10, 10).At(3, 3); got != want {
+		t.Errorf("inside the image: got %v, want %v", got, want)
+	}
+}
+
+func TestDrawFilledCircle(t *testing.T) {
+	red := Pixel{255, 0, 0}
+	ppm, err := NewPPM(31, 31, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawFilledCircle(Point{15, 15}, 10, red)
+
+	tests := []struct {
+		x, y int
+		want Pixel
+	}{
+		{15, 15, red},     // center
+		{22, 22, red},     // inside, distance ≈ 9.9
+		{15, 5, red},      // top of the circle
+		{25, 15, red},     // right of the circle
+		{23, 23, Pixel{}}, // outside, distance ≈ 11.3
+		{15, 4, Pixel{}},
+		{26, 15, Pixel{}},
+	}
+	for _, tt := range tests {
+		if got := ppm.At(tt.x, tt.y); got != tt.want {
+			t.Errorf("At(%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// Each row is a single span, symmetric around the center and inside the
+	// circle.
+	for y := range 31 {
+		var xs []int
+		for x := range 31 {
+			if ppm.At(x, y) == red {
+				xs = append(xs, x)
+			}
+		}
+		if len(xs) == 0 {
+			continue
+		}
+		if xs[len(xs)-1]-xs[0]+1 != len(xs) {
+			t.Errorf("row %d has gaps: %v", y, xs)
+		}
+		if xs[0]+xs[len(xs)-1] != 30 {
+			t.Errorf("row %d is not centered: %d..%d", y, xs[0], xs[len(xs)-1])
+		}
+		if dx, dy := xs[0]-15, y-15; dx*dx+dy*dy > 100 {
+			t.Errorf("row %d reaches outside the circle at x = %d", y, xs[0])
+		}
+	}
+}
+
+func TestPPMFlatten(t *testing.T) {
+	ppm := testPPM(t, 3, 2)
+	flat := ppm.Flatten()
+	if len(flat) != 3*2*3 {
+		t.Fatalf("got %d samples, want %d", len(flat), 3*2*3)
+	}
+	// Pixel (1, 1) starts at (1*3 + 1) * 3.
+	if got, want := flat[12:15], []uint8{1, 1, 20}; !slices.Equal(got, want) {
+		t.Errorf("pixel (1, 1): got %v, want %v", got, want)
+	}
+
+	back, err := UnflattenPPM(3, 2, flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.EqualPixels(ppm) {
+		t.Errorf("round trip got %v, want %v", back.data, ppm.data)
+	}
+	if _, err := UnflattenPPM(3, 2, flat[:17]); err == nil {
+		t.Error("UnflattenPPM accepted a short slice")
+	}
+	if _, err := UnflattenPPM(2, 3*3, flat); err == nil {
+		t.Error("UnflattenPPM accepted a mismatched size")
+	}
+}
+
+func TestDrawCircleConnected(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	for radius := 0; radius <= 5; radius++ {
+		ppm, err := NewPPM(15, 15, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ppm.DrawCircle(Point{7, 7}, radius, white)
+
+		var set []Point
+		for y := range 15 {
+			for x := range 15 {
+				if ppm.At(x, y) == white {
+					set = append(set, Point{x, y})
+				}
+			}
+		}
+		if radius == 0 {
+			if len(set) != 1 || set[0] != (Point{7, 7}) {
+				t.Errorf("radius 0: got %v, want only the center", set)
+			}
+			continue
+		}
+		for _, p := range set {
+			dx, dy := float64(p.X-7), float64(p.Y-7)
+			if d := math.Hypot(dx, dy); math.Abs(d-float64(radius)) > 0.75 {
+				t.Errorf("radius %d: pixel %v is %.2f from the center", radius, p, d)
+			}
+		}
+
+		// Walk the outline from one pixel through its 8-neighbours; every
+		// pixel must be reached.
+		seen := map[Point]bool{set[0]: true}
+		queue := []Point{set[0]}
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					q := Point{p.X + dx, p.Y + dy}
+					if !seen[q] && ppm.At(q.X, q.Y) == white {
+						seen[q] = true
+						queue = append(queue, q)
+					}
+				}
+			}
+		}
+		if len(seen) != len(set) {
+			t.Errorf("radius %d: outline is not connected, reached %d of %d pixels", radius, len(seen), len(set))
+		}
+		// Only the outline is drawn, not the disc.
+		if ppm.At(7, 7) == white {
+			t.Errorf("radius %d: center is set", radius)
+		}
+	}
+}
+
+func TestSwap(t *testing.T) {
+	front := testPPM(t, 3, 2)
+	back, err := NewPPM(3, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back.SetMagicNumber("P3")
+	frontRow, backRow := &front.data[0][0], &back.data[0][0]
+	want := front.Clone()
+
+	if err := front.Swap(back); err != nil {
+		t.Fatal(err)
+	}
+	if &front.data[0][0] != backRow || &back.data[0][0] != frontRow {
+		t.Error("Swap copied the pixels instead of exchanging the rows")
+	}
+	if !back.EqualPixels(want) || front.Count(func(p Pixel) bool { return p != Pixel{} }) != 0 {
+		t.Errorf("got front %v and back %v, want the contents exchanged", front.data, back.data)
+	}
+	if front.magicNumber != "P6" || back.magicNumber != "P3" {
+		t.Errorf("got magic numbers %s and %s, want P6 and P3 kept", front.magicNumber, back.magicNumber)
+	}
+
+	small, err := NewPPM(2, 3, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := front.Swap(small); err == nil {
+		t.Error("Swap accepted images of different sizes")
+	}
+	if err := front.Swap(nil); err == nil {
+		t.Error("Swap accepted a nil image")
+	}
+}
+
+func TestDrawLineAA(t *testing.T) {
+	gray := func(v uint8) Pixel { return Pixel{v, v, v} }
+	ppm, err := NewPPM(5, 2, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawLineAA(Point{0, 0}, Point{4, 1}, gray(255))
+	// The line rises by a quarter pixel per column, so the coverage shifts
+	// from the top row to the bottom one in quarters.
+	want := [][]Pixel{
+		{gray(255), gray(191), gray(128), gray(64), gray(0)},
+		{gray(0), gray(64), gray(128), gray(191), gray(255)},
+	}
+	if !slices.EqualFunc(ppm.data, want, slices.Equal) {
+		t.Errorf("got %v, want %v", ppm.data, want)
+	}
+
+	// Blending is against the current pixels and stays within the max value.
+	ppm, err = NewPPM(5, 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawFilledRectangle(Point{0, 0}, 5, 2, Pixel{0, 0, 100})
+	ppm.DrawLineAA(Point{4, 1}, Point{0, 0}, Pixel{100, 0, 0})
+	if got := ppm.At(0, 0); got != (Pixel{100, 0, 0}) {
+		t.Errorf("endpoint: got %v, want full intensity", got)
+	}
+	if got, want := ppm.At(1, 0), (Pixel{75, 0, 25}); got != want {
+		t.Errorf("3/4 coverage: got %v, want %v", got, want)
+	}
+	if got, want := ppm.At(1, 1), (Pixel{25, 0, 75}); got != want {
+		t.Errorf("1/4 coverage: got %v, want %v", got, want)
+	}
+
+	// Steep lines step along y.
+	ppm, err = NewPPM(2, 5, 255)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppm.DrawLineAA(Point{0, 0}, Point{1, 4}, gray(255))
+	if got := ppm.At(0, 1); got != gray(191) {
+		t.Errorf("steep: got %v at (0, 1), want %v", got, gray(191))
+	}
+	if got := ppm.At(1, 4); got != gray(255) {
+		t.Errorf("steep: got %v at the endpoint, want full intensity", got)
+	}
+}
+
+func TestDrawEllipse(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	center := Point{25, 15}
+	extremes := []Point{{5, 15}, {45, 15}, {25, 5}, {25, 25}}
+	beyond := []Point{{4, 15}, {46, 15}, {25, 4}, {25, 26}}
+
+	for _, filled := range []bool{false, true} {
+		ppm, err := NewPPM(51, 31, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		draw := ppm.DrawEllipse
+		if filled {
+			draw = ppm.DrawFilledEllipse
+		}
+		draw(center, 20, 10, white)
+
+		for _, p := range extremes {
+			if ppm.At(p.X, p.Y) != white {
+				t.Errorf("filled %t: extreme point %v is not set", filled, p)
+			}
+		}
+		for _, p := range beyond {
+			if ppm.At(p.X, p.Y) != (Pixel{}) {
+				t.Errorf("filled %t: point %v beyond the ellipse is set", filled, p)
+			}
+		}
+		if got := ppm.At(center.X, center.Y) == white; got != filled {
+			t.Errorf("filled %t: center set = %t", filled, got)
+		}
+		// Nothing is drawn outside the bounding box.
+		if n := ppm.Count(func(p Pixel) bool { return p == white }); n > 41*21 {
+			t.Errorf("filled %t: %d pixels set, more than the bounding box", filled, n)
+		}
+
+		for _, radii := range [][2]int{{0, 10}, {20, 0}, {-1, 5}} {
+			blank, err := NewPPM(51, 31, 255)
+			if err != nil {
+				t.Fatal(err)
+			}
+			draw := blank.DrawEllipse
+			if filled {
+				draw = blank.DrawFilledEllipse
+			}
+			draw(center, radii[0], radii[1], white)
+			if n := blank.Count(func(p Pixel) bool { return p == white }); n != 0 {
+				t.Errorf("filled %t: radii %v drew %d pixels, want none", filled, radii, n)
+			}
+		}
+	}
+}
+
+func TestPPMAdjustReport(t *testing.T) {
+	// Blue samples are 0, 7, 14, 21 and 13, 20, 27, 34; red and green stay
+	// below 4.
+	ppm := testPPM(t, 4, 2)
+	low, high := ppm.AdjustBrightnessReport(240)
+	if low != 0 || high != 4 {
+		t.Errorf("AdjustBrightnessReport(240): got %d low, %d high, want 0 and 4", low, high)
+	}
+	if got := ppm.At(3, 1); got != (Pixel{243, 241, 255}) {
+		t.Errorf("AdjustBrightnessReport(240): got %v, want {243 241 255}", got)
+	}
+
+	// Each channel is counted on its own: only the 7 zero samples, 2 red,
+	// 4 green and 1 blue, are left in range.
+	ppm = testPPM(t, 4, 2)
+	if low, high := ppm.MultiplyReport(-1); low != 3*8-7 || high != 0 {
+		t.Errorf("MultiplyReport(-1): got %d low, %d high, want %d and 0", low, high, 3*8-7)
+	}
+	// All samples are below the middle of the range.
+	ppm = testPPM(t, 4, 2)
+	if low, high := ppm.AdjustContrastReport(100); low != 3*8 || high != 0 {
+		t.Errorf("AdjustContrastReport(100): got %d low, %d high, want %d and 0", low, high, 3*8)
+	}
+
+	// The plain variants adjust the same way.
+	a, b := testPPM(t, 4, 2), testPPM(t, 4, 2)
+	a.AdjustContrast(1.5)
+	b.AdjustContrastReport(1.5)
+	a.Multiply(9)
+	b.MultiplyReport(9)
+	a.AdjustBrightness(-20)
+	b.AdjustBrightnessReport(-20)
+	if !a.Equal(b) {
+		t.Errorf("got %v, want %v", a.data, b.data)
+	}
+}
+
+func TestDrawArc(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	center := Point{15, 15}
+	canvas := func() *PPM {
+		ppm, err := NewPPM(31, 31, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ppm
+	}
+	draw := func(startDeg, endDeg float64) *PPM {
+		ppm := canvas()
+		ppm.DrawArc(center, 10, startDeg, endDeg, white)
+		return ppm
+	}
+	// count returns how many pixels of ppm are set where in returns true
+	// for the offset from the center.
+	count := func(ppm *PPM, in func(dx, dy int) bool) int {
+		n := 0
+		for y := range 31 {
+			for x := range 31 {
+				if ppm.At(x, y) == white && in(x-center.X, y-center.Y) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	all := func(dx, dy int) bool { return true }
+	circle := canvas()
+	circle.DrawCircle(center, 10, white)
+
+	// Clockwise on screen from the positive x-axis, the first quarter is
+	// the bottom right one, as y points down.
+	bottomRight := func(dx, dy int) bool { return dx >= 0 && dy >= 0 }
+	quarter := draw(0, 90)
+	if quarter.At(25, 15) != white || quarter.At(15, 25) != white {
+		t.Error("quarter arc misses its end points")
+	}
+	if n := count(quarter, func(dx, dy int) bool { return !bottomRight(dx, dy) }); n != 0 {
+		t.Errorf("quarter arc: %d pixels outside the bottom right quarter", n)
+	}
+	if got, want := count(quarter, all), count(circle, bottomRight); got != want {
+		t.Errorf("quarter arc: got %d pixels, want the %d of the circle", got, want)
+	}
+	// The octant from 0 to 45 degrees lies below the x-axis, to the right
+	// of the diagonal.
+	octant := draw(0, 45)
+	if n := count(octant, func(dx, dy int) bool { return dy < 0 || dy > dx }); n != 0 {
+		t.Errorf("octant arc: %d pixels outside the octant", n)
+	}
+	if octant.At(25, 15) != white || octant.At(22, 22) != white {
+		t.Error("octant arc misses its end points")
+	}
+
+	// A start above the end wraps through 0, like a negative start.
+	right := func(dx, dy int) bool { return dx > 0 && abs(dy) <= dx }
+	for _, span := range [][2]float64{{315, 45}, {-45, 45}, {675, 45}} {
+		arc := draw(span[0], span[1])
+		if n := count(arc, func(dx, dy int) bool { return !right(dx, dy) }); n != 0 {
+			t.Errorf("arc %v: %d pixels outside the right quarter", span, n)
+		}
+		if got, want := count(arc, all), count(circle, right); got != want {
+			t.Errorf("arc %v: got %d pixels, want %d", span, got, want)
+		}
+	}
+
+	if full := draw(0, 360); !full.Equal(circle) {
+		t.Error("arc from 0 to 360 differs from DrawCircle")
+	}
+}
+
+func TestDrawBezierStraight(t *testing.T) {
+	white := Pixel{255, 255, 255}
+	canvas := func() *PPM {
+		ppm, err := NewPPM(40, 40, 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ppm
+	}
+
+	// The offsets between the end points divide by 3 and 4, so that the
+	// control points lie exactly on the line.
+	for _, ends := range [][2]Point{{{2, 3}, {38, 27}}, {{38, 35}, {2, 11}}, {{10, 2}, {10, 38}}, {{0, 0}, {36, 36}}} {
+		p0, p3 := ends[0], ends[1]
+		// along returns the point at fraction num/den of the way from p0 to p3.
+		along := func(num, den int) Point {
+			return Point{p0.X + (p3.X-p0.X)*num/den, p0.Y + (p3.Y-p0.Y)*num/den}
+		}
+		line := canvas()
+		line.DrawLine(p0, p3, white)
+
+		quadratic := canvas()
+		quadratic.DrawQuadraticBezier(p0, along(1, 2), p3, white)
+		if !quadratic.Equal(line) {
+			t.Errorf("quadratic %v to %v: differs from DrawLine", p0, p3)
+		}
+		for _, controls := range [][2]Point{{along(1, 3), along(2, 3)}, {p0, p3}, {along(3, 4), along(1, 4)}} {
+			cubic := canvas()
+			cubic.DrawCubicBezier(p0, controls[0], controls[1], p3, white)
+			if !cubic.Equal(line) {
+				t.Errorf("cubic %v to %v with controls %v: differs from DrawLine", p0, p3, controls)
+			}
+		}
+	}
+
+	// A curved path starts and ends exactly at its end points.
+	curve := canvas()
+	curve.DrawCubicBezier(Point{2, 30}, Point{10, 0}, Point{30, 39}, Point{37, 5}, white)
+	if curve.At(2, 30) != white || curve.At(37, 5) != white {
+		t.Error("curve misses its end points")
+	}
+}
+
+func TestPPMAdjustBrightnessSaturates(t *testing.T) {
+	for _, max := range []uint8{255, 100} {
+		ppm := testPPM(t, 8, 8)
+		ppm.max = max
+		ppm.Set(0, 0, Pixel{max, 0, max})
+
+		ppm.AdjustBrightness(300)
+		if n := ppm.Count(func(p Pixel) bool { return p == Pixel{max, max, max} }); n != 64 {
+			t.Errorf("max %d, +300: got %d pixels at the max value, want all 64", max, n)
+		}
+		ppm.AdjustBrightness(-300)
+		if n := ppm.Count(func(p Pixel) bool { return p == Pixel{} }); n != 64 {
+			t.Errorf("max %d, -300: got %d black pixels, want all 64", max, n)
+		}
+	}
+
+	// Each channel is clamped on its own.
+	ppm := testPPM(t, 1, 1)
+	ppm.Set(0, 0, Pixel{10, 200, 250})
+	ppm.AdjustBrightness(20)
+	if got, want := ppm.At(0, 0), (Pixel{30, 220, 255}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	ppm.AdjustBrightness(-25)
+	if got, want := ppm.At(0, 0), (Pixel{5, 195, 230}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCropRectPasteRectOutside(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Rect
+	}{
+		{"right of the image", Rect{Point{15, 0}, Point{18, 3}}},
+		{"left of the image", Rect{Point{-20, 0}, Point{-17, 3}}},
+		{"below the image", Rect{Point{0, 12}, Point{3, 15}}},
+		{"above the image", Rect{Point{0, -8}, Point{3, -5}}},
+		{"inverted", Rect{Point{5, 5}, Point{2, 8}}},
+		{"inverted vertically", Rect{Point{2, 8}, Point{5, 5}}},
+		{"zero width", Rect{Point{4, 0}, Point{4, 3}}},
+	}
+	src := testPPM(t, 3, 3)
+	for _, tt := range tests {
+		ppm := testPPM(t, 10, 10)
+		crop := ppm.CropRect(tt.r)
+		if w, h := crop.Size(); w != 0 || h != 0 || len(crop.data) != 0 {
+			t.Errorf("%s: CropRect got %dx%d, want 0x0", tt.name, w, h)
+		}
+
+		ppm.PasteRect(src, tt.r)
+		if !ppm.Equal(testPPM(t, 10, 10)) {
+			t.Errorf("%s: PasteRect changed the image", tt.name)
+		}
+	}
+
+	// Partly outside, only the overlap is cropped or pasted.
+	ppm := testPPM(t, 10, 10)
+	if w, h := ppm.CropRect(Rect{Point{8, -2}, Point{14, 1}}).Size(); w != 2 || h != 1 {
+		t.Errorf("CropRect across the corner: got %dx%d, want 2x1", w, h)
+	}
+	ppm.PasteRect(src, Rect{Point{-1, 8}, Point{2, 11}})
+	if got, want := ppm.At(0, 8), src.At(1, 0); got != want {
+		t.Errorf("PasteRect across the corner: got %v, want %v", got, want)
+	}
+
+	canvas := NewSupersampled(4, 4, 2, 255)
+	if crop := canvas.CropRect(Rect{Point{0, 0}, Point{4, 4}}); crop.supersample != 2 {
+		t.Errorf("CropRect: got supersample %d, want 2", crop.supersample)
+	}
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	canvas := NewSupersampled(3, 2, 4, 200)
+	canvas.DrawFilledRectangle(Point{0, 0}, 6, 8, Pixel{200, 100, 0})
+	canvas.SetMagicNumber("P3")
+
+	b, err := canvas.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PPM
+	if err := got.GobDecode(b); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(canvas) || got.magicNumber != "P3" || got.supersample != 4 {
+		t.Fatalf("got %s max %d supersample %d, want the image back exactly", got.magicNumber, got.max, got.supersample)
+	}
+
+	// The decoded canvas still downsamples.
+	canvas.Downsample()
+	got.Downsample()
+	if w, h := got.Size(); w != 3 || h != 2 || !got.Equal(canvas) {
+		t.Errorf("Downsample after decoding: got %dx%d %v, want %v", w, h, got.data, canvas.data)
+	}
+}
+
+func ExamplePPM_Count() {
+	ppm, err := NewPPM(4, 3, 255)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ppm.FillRect(Rect{Point{0, 0}, Point{2, 2}}, Pixel{255, 0, 0})
+	ppm.Set(3, 2, Pixel{254, 0, 0})
+
+	// Count the fully saturated red pixels.
+	red := ppm.Count(func(p Pixel) bool { return p.R == 255 && p.G == 0 && p.B == 0 })
+	fmt.Println(red)
+	// Output: 4
+}
@@ -0,0 +1,93 @@
+package Netpbm
+
+// Rect is an axis-aligned rectangle containing the points with
+// Min.X <= X < Max.X and Min.Y <= Y < Max.Y, like image.Rectangle.
+// A rectangle whose Max is not beyond its Min on both axes is empty.
+type Rect struct {
+	Min, Max Point
+}
+
+// Width returns the width of the rectangle, 0 if it is empty.
+func (r Rect) Width() int {
+	return max(r.Max.X-r.Min.X, 0)
+}
+
+// Height returns the height of the rectangle, 0 if it is empty.
+func (r Rect) Height() int {
+	return max(r.Max.Y-r.Min.Y, 0)
+}
+
+// Empty reports whether the rectangle contains no points.
+func (r Rect) Empty() bool {
+	return r.Width() == 0 || r.Height() == 0
+}
+
+// Contains reports whether the point lies within the rectangle.
+func (r Rect) Contains(p Point) bool {
+	return p.X >= r.Min.X && p.X < r.Max.X && p.Y >= r.Min.Y && p.Y < r.Max.Y
+}
+
+// Intersect returns the largest rectangle contained by both r and other,
+// which is empty if they do not overlap.
+func (r Rect) Intersect(other Rect) Rect {
+	return Rect{
+		Min: Point{max(r.Min.X, other.Min.X), max(r.Min.Y, other.Min.Y)},
+		Max: Point{min(r.Max.X, other.Max.X), min(r.Max.Y, other.Max.Y)},
+	}
+}
+
+// rect returns the rectangle covering the whole image.
+func (ppm *PPM) rect() Rect {
+	return Rect{Max: Point{ppm.width, ppm.height}}
+}
+
+// DrawRect draws the outline of the rectangle, on its outermost pixels.
+func (ppm *PPM) DrawRect(r Rect, color Pixel) {
+	if r.Empty() {
+		return
+	}
+	ppm.DrawRectangle(r.Min, r.Width()-1, r.Height()-1, color)
+}
+
+// FillRect fills the rectangle, clipped to the image.
+func (ppm *PPM) FillRect(r Rect, color Pixel) {
+	if r.Empty() {
+		return
+	}
+	ppm.fillRect(r.Min.X, r.Min.Y, r.Width(), r.Height(), color)
+}
+
+// CropRect returns a copy of the part of the image inside the rectangle,
+// which is clipped to the image first. A rectangle outside the image gives a
+// 0x0 image.
+func (ppm *PPM) CropRect(r Rect) *PPM {
+	r = r.Intersect(ppm.rect())
+	if r.Empty() {
+		r = Rect{}
+	}
+	return &PPM{
+		data:        copyRect(ppm.data, r.Min.X, r.Min.Y, r.Width(), r.Height()),
+		width:       r.Width(),
+		height:      r.Height(),
+		magicNumber: ppm.magicNumber,
+		max:         ppm.max,
+		supersample: ppm.supersample,
+	}
+}
+
+// PasteRect copies src into the rectangle, with the top-left pixel of src at
+// r.Min. Only the part of src that fits both in the rectangle and in the
+// image is copied.
+func (ppm *PPM) PasteRect(src *PPM, r Rect) {
+	if src == nil {
+		return
+	}
+	r.Max = Point{min(r.Max.X, r.Min.X+src.width), min(r.Max.Y, r.Min.Y+src.height)}
+	clipped := r.Intersect(ppm.rect())
+	if clipped.Empty() {
+		return
+	}
+	for y := clipped.Min.Y; y < clipped.Max.Y; y++ {
+		copy(ppm.data[y][clipped.Min.X:clipped.Max.X], src.data[y-r.Min.Y][clipped.Min.X-r.Min.X:])
+	}
+}
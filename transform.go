@@ -0,0 +1,94 @@
+package Netpbm
+
+import "math"
+
+// Transform is a 2x3 affine matrix [a b c; d e f], stored as {a, b, c, d, e, f},
+// mapping a point (x, y) to (a*x + b*y + c, d*x + e*y + f). It uses the same
+// layout as the matrix of WarpAffine.
+type Transform [6]float64
+
+// Identity returns the transform that leaves points unchanged.
+func Identity() Transform {
+	return Transform{1, 0, 0, 0, 1, 0}
+}
+
+// Translate returns a transform moving points by (dx, dy).
+func Translate(dx, dy float64) Transform {
+	return Transform{1, 0, dx, 0, 1, dy}
+}
+
+// Scale returns a transform scaling points by sx and sy around the origin.
+func Scale(sx, sy float64) Transform {
+	return Transform{sx, 0, 0, 0, sy, 0}
+}
+
+// Rotate returns a transform rotating points around the origin by the given
+// angle in degrees. With the y-axis pointing down, positive angles turn
+// clockwise on screen.
+func Rotate(degrees float64) Transform {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return Transform{cos, -sin, 0, sin, cos, 0}
+}
+
+// Shear returns a transform shearing points by shx along x and shy along y.
+func Shear(shx, shy float64) Transform {
+	return Transform{1, shx, 0, shy, 1, 0}
+}
+
+// Then returns the transform applying t first, then other.
+func (t Transform) Then(other Transform) Transform {
+	return Transform{
+		other[0]*t[0] + other[1]*t[3],
+		other[0]*t[1] + other[1]*t[4],
+		other[0]*t[2] + other[1]*t[5] + other[2],
+		other[3]*t[0] + other[4]*t[3],
+		other[3]*t[1] + other[4]*t[4],
+		other[3]*t[2] + other[4]*t[5] + other[5],
+	}
+}
+
+// Apply returns the transformed point, rounded to the nearest pixel.
+func (t Transform) Apply(p Point) Point {
+	x, y := float64(p.X), float64(p.Y)
+	return Point{
+		int(math.Round(t[0]*x + t[1]*y + t[2])),
+		int(math.Round(t[3]*x + t[4]*y + t[5])),
+	}
+}
+
+// applyAll returns the transformed points.
+func (t Transform) applyAll(points []Point) []Point {
+	transformed := make([]Point, len(points))
+	for i, p := range points {
+		transformed[i] = t.Apply(p)
+	}
+	return transformed
+}
+
+// DrawLineT draws a line between two points given in the coordinate system of t.
+func (ppm *PPM) DrawLineT(t Transform, p1, p2 Point, color Pixel) {
+	ppm.DrawLine(t.Apply(p1), t.Apply(p2), color)
+}
+
+// DrawRectangleT draws a rectangle given in the coordinate system of t, which
+// may turn it into any parallelogram.
+func (ppm *PPM) DrawRectangleT(t Transform, p1 Point, width, height int, color Pixel) {
+	corners := []Point{p1, {p1.X + width, p1.Y}, {p1.X + width, p1.Y + height}, {p1.X, p1.Y + height}}
+	ppm.DrawPolygon(t.applyAll(corners), color)
+}
+
+// DrawPolygonT draws a polygon whose points are given in the coordinate system of t.
+func (ppm *PPM) DrawPolygonT(t Transform, points []Point, color Pixel) {
+	if len(points) == 0 {
+		return
+	}
+	ppm.DrawPolygon(t.applyAll(points), color)
+}
+
+// DrawFilledPolygonT draws a filled polygon whose points are given in the coordinate system of t.
+func (ppm *PPM) DrawFilledPolygonT(t Transform, points []Point, color Pixel) {
+	if len(points) == 0 {
+		return
+	}
+	ppm.DrawFilledPolygon(t.applyAll(points), color)
+}
@@ -0,0 +1,40 @@
+package Netpbm
+
+import "testing"
+
+func TestTransformRotateThenTranslate(t *testing.T) {
+	tr := Rotate(90).Then(Translate(10, 5))
+	tests := []struct{ p, want Point }{
+		{Point{0, 0}, Point{10, 5}},
+		{Point{1, 0}, Point{10, 6}},
+		{Point{0, 1}, Point{9, 5}},
+		{Point{3, -2}, Point{12, 8}},
+	}
+	for _, tt := range tests {
+		if got := tr.Apply(tt.p); got != tt.want {
+			t.Errorf("Apply(%v): got %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	// Composition applies the receiver first: translating then rotating is
+	// a different transform.
+	if got, want := Translate(10, 5).Then(Rotate(90)).Apply(Point{0, 0}), (Point{-5, 10}); got != want {
+		t.Errorf("translate then rotate: got %v, want %v", got, want)
+	}
+	if got := Identity().Then(tr); got != tr {
+		t.Errorf("Identity().Then(t): got %v, want %v", got, tr)
+	}
+}
+
+func TestDrawLineT(t *testing.T) {
+	red := Pixel{255, 0, 0}
+	tr := Rotate(90).Then(Translate(10, 5))
+
+	got := testPPM(t, 16, 16)
+	got.DrawLineT(tr, Point{0, 0}, Point{0, 4}, red)
+	want := testPPM(t, 16, 16)
+	want.DrawLine(Point{10, 5}, Point{6, 5}, red)
+	if !got.Equal(want) {
+		t.Error("DrawLineT does not draw the transformed line")
+	}
+}